@@ -0,0 +1,327 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/attribute"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"go.opentelemetry.io/otel/internal/attribute"
+)
+
+// isArrayMatch reports whether flag is the flag of a match of array
+// attributes.
+func isArrayMatch(flag MatchValueFlag) bool {
+	return flag == CONTAINSALL || flag == CONTAINSANY || flag == LENGTH
+}
+
+// sliceType returns the type of the arrays of elements of type typ.
+func sliceType(typ Type) (Type, bool) {
+	switch typ {
+	case BOOL:
+		return BOOLSLICE, true
+	case INT64:
+		return INT64SLICE, true
+	case FLOAT64:
+		return FLOAT64SLICE, true
+	case STRING:
+		return STRINGSLICE, true
+	}
+	return INVALID, false
+}
+
+// sliceValue returns the array of values, which must be BOOL, INT64, FLOAT64
+// or STRING values of the same type.
+func sliceValue(values []Value) (Value, error) {
+	if len(values) == 0 {
+		return Value{}, errors.New("no element")
+	}
+	typ := values[0].Type()
+	if _, ok := sliceType(typ); !ok {
+		return Value{}, fmt.Errorf("elements of %s type", typ)
+	}
+	for _, v := range values[1:] {
+		if v.Type() != typ {
+			return Value{}, fmt.Errorf("elements of %s and %s types", typ, v.Type())
+		}
+	}
+	switch typ {
+	case BOOL:
+		s := make([]bool, len(values))
+		for i, v := range values {
+			s[i] = v.AsBool()
+		}
+		return BoolSliceValue(s), nil
+	case INT64:
+		s := make([]int64, len(values))
+		for i, v := range values {
+			s[i] = v.AsInt64()
+		}
+		return Int64SliceValue(s), nil
+	case FLOAT64:
+		s := make([]float64, len(values))
+		for i, v := range values {
+			s[i] = v.AsFloat64()
+		}
+		return Float64SliceValue(s), nil
+	default:
+		s := make([]string, len(values))
+		for i, v := range values {
+			s[i] = v.AsString()
+		}
+		return StringSliceValue(s), nil
+	}
+}
+
+// sliceElements returns the elements of the array v, nil if v is not an
+// array.
+func sliceElements(v Value) []Value {
+	var values []Value
+	switch v.Type() {
+	case BOOLSLICE:
+		for _, b := range v.asBoolSlice() {
+			values = append(values, BoolValue(b))
+		}
+	case INT64SLICE:
+		for _, i := range v.asInt64Slice() {
+			values = append(values, Int64Value(i))
+		}
+	case FLOAT64SLICE:
+		for _, f := range v.asFloat64Slice() {
+			values = append(values, Float64Value(f))
+		}
+	case STRINGSLICE:
+		for _, s := range v.asStringSlice() {
+			values = append(values, StringValue(s))
+		}
+	}
+	return values
+}
+
+// sliceLen returns the length of the array v, false if v is not an array.
+func sliceLen(v Value) (int, bool) {
+	switch v.Type() {
+	case BOOLSLICE:
+		return len(v.asBoolSlice()), true
+	case INT64SLICE:
+		return len(v.asInt64Slice()), true
+	case FLOAT64SLICE:
+		return len(v.asFloat64Slice()), true
+	case STRINGSLICE:
+		return len(v.asStringSlice()), true
+	}
+	return 0, false
+}
+
+// containsElements returns true if elems contains all of want, or any of
+// want if all is false.
+func containsElements[T comparable](all bool, want, elems []T) bool {
+	for _, w := range want {
+		found := false
+		for _, e := range elems {
+			if e == w {
+				found = true
+				break
+			}
+		}
+		if found != all {
+			return found
+		}
+	}
+	return all
+}
+
+// matchesArray returns true if the array value matches the CONTAINSALL,
+// CONTAINSANY or LENGTH match m.
+func (m TraceAttributeValueMatch) matchesArray(value Value) bool {
+	if m.mvf == LENGTH {
+		n, ok := sliceLen(value)
+		return ok && m.lb.AsInt64() <= int64(n) && int64(n) <= m.ub.AsInt64()
+	}
+	if value.Type() != m.lb.Type() {
+		return false
+	}
+	all := m.mvf == CONTAINSALL
+	switch value.Type() {
+	case BOOLSLICE:
+		return containsElements(all, m.lb.asBoolSlice(), value.asBoolSlice())
+	case INT64SLICE:
+		return containsElements(all, m.lb.asInt64Slice(), value.asInt64Slice())
+	case FLOAT64SLICE:
+		return containsElements(all, m.lb.asFloat64Slice(), value.asFloat64Slice())
+	case STRINGSLICE:
+		return containsElements(all, m.lb.asStringSlice(), value.asStringSlice())
+	}
+	return false
+}
+
+// newContainsMatch returns the match of the arrays containing all or any of
+// values, depending on flag.
+func newContainsMatch(flag MatchValueFlag, values []Value) (TraceAttributeValueMatch, error) {
+	if flag != CONTAINSALL && flag != CONTAINSANY {
+		return TraceAttributeValueMatch{}, fmt.Errorf("%d is not a contains match", flag)
+	}
+	set, err := sliceValue(values)
+	if err != nil {
+		return TraceAttributeValueMatch{}, err
+	}
+	return TraceAttributeValueMatch{mvf: flag, lb: set, ub: set}, nil
+}
+
+// newLengthMatch returns the match of the arrays of length within lb and ub,
+// inclusive, reversing their order if lb > ub.
+func newLengthMatch(lb, ub int64) TraceAttributeValueMatch {
+	if lb > ub {
+		lb, ub = ub, lb
+	}
+	return TraceAttributeValueMatch{mvf: LENGTH, lb: Int64Value(lb), ub: Int64Value(ub)}
+}
+
+// AddContainsMatch appends a match of the array attributes containing
+// values to the filter. flag is CONTAINSALL to match the arrays containing
+// all of the values, or CONTAINSANY to match the ones containing any of them.
+// values must be BOOL, INT64, FLOAT64 or STRING values of the same type, they
+// only match arrays of that type.
+func (f *mapTraceAttributeFilter) AddContainsMatch(key Key, flag MatchValueFlag, values ...Value) error {
+	m, err := newContainsMatch(flag, values)
+	if err != nil {
+		return err
+	}
+	f.add(key, m)
+	return nil
+}
+
+// AddLengthMatch appends a match of the array attributes of length within lb
+// and ub, inclusive, to the filter. A negative bound, see Rule.Validate, is
+// skipped and reported to the ErrorHandler.
+func (f *mapTraceAttributeFilter) AddLengthMatch(key Key, lb, ub int64) {
+	m := newLengthMatch(lb, ub)
+	if err := checkLength(m.lb, m.ub); err != nil {
+		attribute.HandleError(fmt.Errorf("attribute filter: %s match skipped: %w", key, err))
+		return
+	}
+	f.add(key, m)
+}
+
+// checkLength returns an error if lb and ub are not the bounds of a legal
+// length match: they must be non-negative INT64 values, lb not above ub.
+func checkLength(lb, ub Value) error {
+	if lb.Type() != INT64 || ub.Type() != INT64 {
+		return fmt.Errorf("length bounds of %s and %s types", lb.Type(), ub.Type())
+	}
+	if lb.AsInt64() < 0 {
+		return fmt.Errorf("negative length %d", lb.AsInt64())
+	}
+	if lb.AsInt64() > ub.AsInt64() {
+		return fmt.Errorf("length bounds %d above %d", lb.AsInt64(), ub.AsInt64())
+	}
+	return nil
+}
+
+// ContainsRule returns the rule matching the key array attributes containing
+// values, see AddContainsMatch for flag.
+func ContainsRule(key Key, flag MatchValueFlag, values ...Value) (Rule, error) {
+	m, err := newContainsMatch(flag, values)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{Key: key, Flag: flag, LowerBound: m.lb, UpperBound: m.ub}, nil
+}
+
+// LengthRule returns the rule matching the key array attributes of length
+// within lb and ub, inclusive.
+func LengthRule(key Key, lb, ub int64) Rule {
+	m := newLengthMatch(lb, ub)
+	return Rule{Key: key, Flag: LENGTH, LowerBound: m.lb, UpperBound: m.ub}
+}
+
+// Contains returns a condition true if the key attribute is an array
+// containing values, see AddContainsMatch for flag.
+func Contains(key Key, flag MatchValueFlag, values ...Value) (Condition, error) {
+	m, err := newContainsMatch(flag, values)
+	if err != nil {
+		return Condition{}, err
+	}
+	return Condition{op: condMatch, key: key, match: m}, nil
+}
+
+// LengthInRange returns a condition true if the key attribute is an array of
+// length within lb and ub, inclusive.
+func LengthInRange(key Key, lb, ub int64) Condition {
+	return Condition{op: condMatch, key: key, match: newLengthMatch(lb, ub)}
+}
+
+// arrayRules returns the rules on key of a filter of an update request with
+// the contains_all, contains_any or length op operator and values.
+func arrayRules(key Key, op string, values []Value) ([]Rule, error) {
+	if op != FilterOpLength {
+		flag := CONTAINSALL
+		if op == FilterOpContainsAny {
+			flag = CONTAINSANY
+		}
+		rule, err := ContainsRule(key, flag, values...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return []Rule{rule}, nil
+	}
+	if values[0].Type() != INT64 {
+		return nil, fmt.Errorf("%s of %s type", op, values[0].Type())
+	}
+	switch len(values) {
+	case 1:
+		return []Rule{LengthRule(key, values[0].AsInt64(), values[0].AsInt64())}, nil
+	case 2:
+		return []Rule{LengthRule(key, values[0].AsInt64(), values[1].AsInt64())}, nil
+	}
+	return nil, fmt.Errorf("%s with %d values, want a length or its bounds", op, len(values))
+}
+
+// arrayValuesJSON returns the operator and the values of the array match m
+// in the form of the filters of an update request: the elements of a contains
+// match, and the bounds of a length match.
+func (m TraceAttributeValueMatch) arrayValuesJSON() (string, []Value) {
+	switch m.mvf {
+	case CONTAINSALL:
+		return FilterOpContainsAll, sliceElements(m.lb)
+	case CONTAINSANY:
+		return FilterOpContainsAny, sliceElements(m.lb)
+	default:
+		return FilterOpLength, []Value{m.lb, m.ub}
+	}
+}
+
+// arrayString returns the human readable form of the array match m on key,
+// e.g. tags CONTAINS ANY ("a", "b") or LENGTH(tags) IN [1, 3].
+func (m TraceAttributeValueMatch) arrayString(key Key) string {
+	if m.mvf == LENGTH {
+		if m.ub.AsInt64() == math.MaxInt64 {
+			return fmt.Sprintf("LENGTH(%s) >= %d", key, m.lb.AsInt64())
+		}
+		return fmt.Sprintf("LENGTH(%s) IN [%d, %d]", key, m.lb.AsInt64(), m.ub.AsInt64())
+	}
+	elems := sliceElements(m.lb)
+	parts := make([]string, len(elems))
+	for i, v := range elems {
+		parts[i] = literal(v)
+	}
+	op := "CONTAINS ALL"
+	if m.mvf == CONTAINSANY {
+		op = "CONTAINS ANY"
+	}
+	return fmt.Sprintf("%s %s (%s)", key, op, strings.Join(parts, ", "))
+}
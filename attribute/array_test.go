@@ -0,0 +1,127 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestArrayMatch(t *testing.T) {
+	tags := func(s ...string) []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.StringSlice("tags", s)}
+	}
+	all, err := attribute.Contains("tags", attribute.CONTAINSALL, attribute.StringValue("a"), attribute.StringValue("b"))
+	require.NoError(t, err)
+	assert.True(t, all.Evaluate(tags("b", "c", "a")))
+	assert.False(t, all.Evaluate(tags("a", "c")))
+	assert.False(t, all.Evaluate([]attribute.KeyValue{attribute.String("tags", "a")}), "only arrays contain elements")
+
+	some, err := attribute.Contains("tags", attribute.CONTAINSANY, attribute.StringValue("a"), attribute.StringValue("b"))
+	require.NoError(t, err)
+	assert.True(t, some.Evaluate(tags("c", "b")))
+	assert.False(t, some.Evaluate(tags("c")))
+	assert.False(t, some.Evaluate(tags()))
+
+	codes, err := attribute.Contains("codes", attribute.CONTAINSALL, attribute.Int64Value(500))
+	require.NoError(t, err)
+	assert.True(t, codes.Evaluate([]attribute.KeyValue{attribute.IntSlice("codes", []int{200, 500})}))
+	assert.False(t, codes.Evaluate([]attribute.KeyValue{attribute.Float64Slice("codes", []float64{500})}), "elements should have the same type")
+
+	length := attribute.LengthInRange("tags", 3, 1)
+	assert.True(t, length.Evaluate(tags("a")))
+	assert.True(t, length.Evaluate(tags("a", "b", "c")))
+	assert.False(t, length.Evaluate(tags()))
+	assert.False(t, length.Evaluate([]attribute.KeyValue{attribute.String("tags", "a")}))
+	assert.True(t, attribute.LengthInRange("flags", 2, 2).Evaluate([]attribute.KeyValue{attribute.BoolSlice("flags", []bool{true, true})}))
+
+	assert.Equal(t, `(tags CONTAINS ALL ("a", "b") AND NOT tags CONTAINS ANY ("a", "b") AND LENGTH(tags) IN [1, 3])`,
+		attribute.And(all, attribute.Not(some), length).String())
+}
+
+func TestArrayMatchErrors(t *testing.T) {
+	for _, values := range [][]attribute.Value{
+		nil,
+		{attribute.StringValue("a"), attribute.IntValue(1)},
+		{attribute.StringSliceValue([]string{"a"})},
+	} {
+		_, err := attribute.Contains("tags", attribute.CONTAINSALL, values...)
+		assert.Error(t, err, values)
+	}
+	_, err := attribute.Contains("tags", attribute.EQUALITY, attribute.StringValue("a"))
+	assert.Error(t, err)
+
+	f := attribute.NewMapTraceAttributeFilter()
+	assert.Error(t, f.AddContainsMatch("tags", attribute.CONTAINSANY))
+	assert.Empty(t, f.Rules())
+}
+
+func TestFilterArrayMatch(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	require.NoError(t, f.AddContainsMatch("tags", attribute.CONTAINSANY, attribute.StringValue("a"), attribute.StringValue("b")))
+	require.NoError(t, f.AddContainsMatch("tags", attribute.CONTAINSANY, attribute.StringValue("a"), attribute.StringValue("b")))
+	f.AddLengthMatch("tags", 5, 10)
+	assert.Len(t, f.Rules(), 2, "identical matches should be added once")
+
+	assert.True(t, f.Match("tags", attribute.StringSliceValue([]string{"b"})))
+	assert.True(t, f.Match("tags", attribute.StringSliceValue([]string{"c", "d", "e", "f", "g"})))
+	assert.False(t, f.Match("tags", attribute.StringSliceValue([]string{"c"})))
+
+	data, err := json.Marshal(f.Snapshot())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"filters": [
+		{"key": "tags", "type": "string", "op": "contains_any", "values": ["a", "b"]},
+		{"key": "tags", "type": "int64", "op": "length", "values": [5, 10]}
+	]}`, string(data))
+	var s attribute.Snapshot
+	require.NoError(t, json.Unmarshal(data, &s))
+	other := attribute.NewMapTraceAttributeFilter()
+	other.Restore(s)
+	assert.Equal(t, f.Rules(), other.Rules())
+
+	cond, err := attribute.Contains("tags", attribute.CONTAINSALL, attribute.BoolValue(true))
+	require.NoError(t, err)
+	data, err = json.Marshal(cond)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"key": "tags", "type": "bool", "op": "contains_all", "values": [true]}`, string(data))
+	var decoded attribute.Condition
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, cond, decoded)
+}
+
+func TestArrayFilterRules(t *testing.T) {
+	rules, err := attribute.FilterRules("tags", attribute.FilterOpLength, attribute.Int64Value(2))
+	require.NoError(t, err)
+	assert.Equal(t, []attribute.Rule{attribute.LengthRule("tags", 2, 2)}, rules)
+
+	rules, err = attribute.FilterRules("tags", attribute.FilterOpContainsAll, attribute.StringValue("a"), attribute.StringValue("b"))
+	require.NoError(t, err)
+	want, err := attribute.ContainsRule("tags", attribute.CONTAINSALL, attribute.StringValue("a"), attribute.StringValue("b"))
+	require.NoError(t, err)
+	assert.Equal(t, []attribute.Rule{want}, rules)
+
+	for _, values := range [][]attribute.Value{
+		{attribute.StringValue("2")},
+		{attribute.Int64Value(1), attribute.Int64Value(2), attribute.Int64Value(3)},
+	} {
+		_, err := attribute.FilterRules("tags", attribute.FilterOpLength, values...)
+		assert.Error(t, err, values)
+	}
+}
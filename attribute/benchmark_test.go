@@ -15,6 +15,7 @@
 package attribute_test
 
 import (
+	"fmt"
 	"testing"
 
 	"go.opentelemetry.io/otel/attribute"
@@ -282,3 +283,64 @@ func BenchmarkStringSlice(b *testing.B) {
 	})
 	b.Run("Emit", benchmarkEmit(kv))
 }
+
+// benchmarkFilterRules are the numbers of rules of the filter benchmarks, the
+// cost of a match should not grow with them.
+var benchmarkFilterRules = []int{10, 1000, 100000}
+
+func BenchmarkFilterMatch(b *testing.B) {
+	attrs := []attribute.KeyValue{
+		attribute.String("tenant", "t5"),
+		attribute.Int("http.status_code", 503),
+		attribute.String("http.method", "GET"),
+		attribute.String("http.route", "/cart"),
+	}
+	for _, n := range benchmarkFilterRules {
+		b.Run(fmt.Sprintf("rules=%d", n), func(b *testing.B) {
+			f := attribute.NewMapTraceAttributeFilter()
+			for i := 0; i < n; i++ {
+				f.AddEqualityMatch("tenant", attribute.StringValue(fmt.Sprintf("t%d", i)))
+				f.AddRangeMatch("http.status_code", attribute.Int64Value(int64(2*i)), attribute.Int64Value(int64(2*i)))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f.BatchMatch(attrs, func(kv attribute.KeyValue) error {
+					outKV = kv
+					return nil
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkFilterCondition(b *testing.B) {
+	attrs := []attribute.KeyValue{
+		attribute.String("tenant", "t5"),
+		attribute.Int("http.status_code", 503),
+		attribute.String("http.method", "GET"),
+		attribute.String("http.route", "/cart"),
+	}
+	for _, n := range benchmarkFilterRules {
+		b.Run(fmt.Sprintf("rules=%d", n), func(b *testing.B) {
+			tenants := make([]attribute.Value, n)
+			keys := make([]attribute.Condition, n)
+			for i := range tenants {
+				tenants[i] = attribute.StringValue(fmt.Sprintf("t%d", i+10))
+				keys[i] = attribute.HasKey(attribute.Key(fmt.Sprintf("key%d", i)))
+			}
+			f := attribute.NewMapTraceAttributeFilter()
+			// Neither the tenant nor the keys match, the whole condition
+			// is evaluated.
+			f.SetCondition(attribute.Or(attribute.In("tenant", tenants...), attribute.Or(keys...)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f.BatchNotMatch(attrs, func() error {
+					outBool = true
+					return nil
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/attribute"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type conditionOp int
+
+const (
+	condTrue conditionOp = iota
+	condMatch
+	condAnd
+	condOr
+	condNot
+)
+
+// Condition is a boolean expression over the attributes of a span. Its leaves
+// match a single attribute like the matches of a TraceAttributeFilter, and
+// are combined with And, Or and Not. A leaf is false if its attribute is
+// missing.
+//
+// The zero value is a condition that is always true.
+type Condition struct {
+	op       conditionOp
+	key      Key
+	match    TraceAttributeValueMatch
+	operands []Condition
+}
+
+// HasKey returns a condition true if the key attribute is set.
+func HasKey(key Key) Condition {
+	return Condition{op: condMatch, key: key, match: TraceAttributeValueMatch{mvf: NoValue, lb: InvalidValue(), ub: InvalidValue()}}
+}
+
+// Equal returns a condition true if the key attribute is value.
+func Equal(key Key, value Value) Condition {
+	return Condition{op: condMatch, key: key, match: TraceAttributeValueMatch{mvf: EQUALITY, lb: value, ub: value}}
+}
+
+// InRange returns a condition true if the key attribute is within lb and ub,
+// inclusive. The bounds are swapped if lb is greater than ub.
+func InRange(key Key, lb, ub Value) Condition {
+	return Condition{op: condMatch, key: key, match: newRangeMatch(lb, ub)}
+}
+
+// In returns a condition true if the key attribute is one of values.
+func In(key Key, values ...Value) Condition {
+	conds := make([]Condition, len(values))
+	for i, v := range values {
+		conds[i] = Equal(key, v)
+	}
+	return Or(conds...)
+}
+
+// And returns a condition true if all the conditions are true.
+func And(conds ...Condition) Condition {
+	return Condition{op: condAnd, operands: conds}
+}
+
+// Or returns a condition true if any of the conditions is true. Or() is
+// never true.
+func Or(conds ...Condition) Condition {
+	return Condition{op: condOr, operands: conds}
+}
+
+// Not returns a condition true if cond is false.
+func Not(cond Condition) Condition {
+	return Condition{op: condNot, operands: []Condition{cond}}
+}
+
+// IsZero reports whether c is the zero, always true, condition.
+func (c Condition) IsZero() bool {
+	return c.op == condTrue
+}
+
+// Evaluate reports whether attrs satisfy c.
+func (c Condition) Evaluate(attrs []KeyValue) bool {
+	switch c.op {
+	case condMatch:
+		for _, attr := range attrs {
+			if attr.Key == c.key {
+				return c.match.matches(attr.Value)
+			}
+		}
+		return false
+	case condAnd:
+		for _, operand := range c.operands {
+			if !operand.Evaluate(attrs) {
+				return false
+			}
+		}
+		return true
+	case condOr:
+		for _, operand := range c.operands {
+			if operand.Evaluate(attrs) {
+				return true
+			}
+		}
+		return false
+	case condNot:
+		return !c.operands[0].Evaluate(attrs)
+	default:
+		return true
+	}
+}
+
+// MayBeTrue reports whether c may be true for a span whose attributes include
+// attrs, and possibly others set later. It is false only if c is false
+// whatever the missing attributes, the attributes of attrs are assumed not to
+// change.
+func (c Condition) MayBeTrue(attrs []KeyValue) bool {
+	value, known := c.partialEvaluate(attrs)
+	return value || !known
+}
+
+// partialEvaluate evaluates c over attrs with the attributes missing from
+// attrs unknown. known is false if the value of c depends on them.
+func (c Condition) partialEvaluate(attrs []KeyValue) (value, known bool) {
+	switch c.op {
+	case condMatch:
+		for _, attr := range attrs {
+			if attr.Key == c.key {
+				return c.match.matches(attr.Value), true
+			}
+		}
+		return false, false
+	case condAnd, condOr:
+		// An And is decided by a false operand and an Or by a true one.
+		decisive := c.op == condOr
+		known = true
+		for _, operand := range c.operands {
+			v, k := operand.partialEvaluate(attrs)
+			if k && v == decisive {
+				return decisive, true
+			}
+			known = known && k
+		}
+		return !decisive, known
+	case condNot:
+		value, known = c.operands[0].partialEvaluate(attrs)
+		return !value, known
+	default:
+		return true, true
+	}
+}
+
+// String returns a human readable form of c, e.g.
+// (code IN [500, 599] OR NOT method = "GET").
+func (c Condition) String() string {
+	switch c.op {
+	case condMatch:
+		switch c.match.mvf {
+		case NoValue:
+			return string(c.key) + " EXISTS"
+		case EQUALITY:
+			return string(c.key) + " = " + literal(c.match.lb)
+		case RANGE:
+			return fmt.Sprintf("%s IN [%s, %s]", c.key, literal(c.match.lb), literal(c.match.ub))
+		case CONTAINSALL, CONTAINSANY, LENGTH:
+			return c.match.arrayString(c.key)
+		default:
+			return fmt.Sprintf("%s %s %s", c.key, strings.ToUpper(patternOps[c.match.mvf]), literal(c.match.lb))
+		}
+	case condAnd, condOr:
+		sep := " AND "
+		if c.op == condOr {
+			sep = " OR "
+		}
+		parts := make([]string, len(c.operands))
+		for i, operand := range c.operands {
+			parts[i] = operand.String()
+		}
+		return "(" + strings.Join(parts, sep) + ")"
+	case condNot:
+		return "NOT " + c.operands[0].String()
+	default:
+		return "TRUE"
+	}
+}
+
+func literal(v Value) string {
+	if v.Type() == STRING {
+		return strconv.Quote(v.AsString())
+	}
+	return v.Emit()
+}
+
+// conditionJSON is the JSON form of a Condition. Exactly one of And, Or, Not
+// and Key is set, a leaf has the same form as the filters of an update
+// request: no value for a key match, one for an equality, and the lower and
+// upper bounds of a range. A leaf with several rules, e.g. with the "in"
+// operator, is decoded as their Or.
+type conditionJSON struct {
+	And    []Condition       `json:"and,omitempty"`
+	Or     []Condition       `json:"or,omitempty"`
+	Not    *Condition        `json:"not,omitempty"`
+	Key    Key               `json:"key,omitempty"`
+	Type   string            `json:"type,omitempty"`
+	Op     string            `json:"op,omitempty"`
+	Values []json.RawMessage `json:"values,omitempty"`
+}
+
+// MarshalJSON returns the JSON form of c, e.g.
+//
+//	{"or": [{"key": "code", "type": "int64", "values": [500, 599]},
+//	        {"not": {"key": "method", "type": "string", "values": ["GET"]}}]}
+//
+// The zero condition is encoded as null.
+func (c Condition) MarshalJSON() ([]byte, error) {
+	var cj conditionJSON
+	switch c.op {
+	case condTrue:
+		return []byte("null"), nil
+	case condAnd:
+		// Keep an empty operand list, which is not omitted as an empty cj.And.
+		return json.Marshal(map[string][]Condition{"and": append([]Condition{}, c.operands...)})
+	case condOr:
+		return json.Marshal(map[string][]Condition{"or": append([]Condition{}, c.operands...)})
+	case condNot:
+		cj.Not = &c.operands[0]
+	case condMatch:
+		cj.Key = c.key
+		var err error
+		if cj.Type, cj.Op, cj.Values, err = c.match.valuesJSON(); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(cj)
+}
+
+// valuesJSON returns the type, the operator and the JSON values of m, in the
+// form of the filters of an update request.
+func (m TraceAttributeValueMatch) valuesJSON() (typ, op string, raws []json.RawMessage, err error) {
+	var values []Value
+	switch m.mvf {
+	case EQUALITY:
+		values = []Value{m.lb}
+	case RANGE:
+		values = []Value{m.lb, m.ub}
+	case PREFIX, SUFFIX, REGEX, GLOB, LIKE:
+		values, op = []Value{m.lb}, patternOps[m.mvf]
+	case CONTAINSALL, CONTAINSANY, LENGTH:
+		op, values = m.arrayValuesJSON()
+	}
+	raws = make([]json.RawMessage, 0, len(values))
+	for _, v := range values {
+		typ = strings.ToLower(v.Type().String())
+		raw, err := json.Marshal(v.AsInterface())
+		if err != nil {
+			return "", "", nil, err
+		}
+		raws = append(raws, raw)
+	}
+	return typ, op, raws, nil
+}
+
+// UnmarshalJSON decodes the JSON form of a Condition.
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*c = Condition{}
+		return nil
+	}
+	var cj conditionJSON
+	if err := json.Unmarshal(data, &cj); err != nil {
+		return err
+	}
+	set := 0
+	if cj.And != nil {
+		set++
+		*c = And(append([]Condition(nil), cj.And...)...)
+	}
+	if cj.Or != nil {
+		set++
+		*c = Or(append([]Condition(nil), cj.Or...)...)
+	}
+	if cj.Not != nil {
+		set++
+		*c = Not(*cj.Not)
+	}
+	if cj.Key != "" {
+		set++
+		leaf, err := leafCondition(cj.Key, cj.Type, cj.Op, cj.Values)
+		if err != nil {
+			return err
+		}
+		*c = leaf
+	}
+	if set != 1 {
+		return errors.New("condition: exactly one of and, or, not and key must be set")
+	}
+	return nil
+}
+
+// leafCondition returns the condition on key of the JSON values of type typ
+// with the op operator.
+func leafCondition(key Key, typ, op string, raw []json.RawMessage) (Condition, error) {
+	rules, err := decodeRules(key, typ, op, raw)
+	if err != nil {
+		return Condition{}, err
+	}
+	conds := make([]Condition, len(rules))
+	for i, rule := range rules {
+		conds[i] = Condition{op: condMatch, key: key, match: rule.match()}
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return Or(conds...), nil
+}
+
+// decodeRules returns the rules on key of the JSON values of type typ with the
+// op operator.
+func decodeRules(key Key, typ, op string, raw []json.RawMessage) ([]Rule, error) {
+	values := make([]Value, len(raw))
+	for i, r := range raw {
+		v, err := decodeValue(typ, r)
+		if err != nil {
+			return nil, fmt.Errorf("condition on %s: %w", key, err)
+		}
+		values[i] = v
+	}
+	rules, err := FilterRules(key, op, values...)
+	if err != nil {
+		return nil, fmt.Errorf("condition on %s: %w", key, err)
+	}
+	return rules, nil
+}
+
+// decodeValue decodes the JSON value raw of type typ.
+func decodeValue(typ string, raw json.RawMessage) (Value, error) {
+	switch typ {
+	case "string":
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return Value{}, err
+		}
+		return StringValue(s), nil
+	case "bool":
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return Value{}, err
+		}
+		return BoolValue(b), nil
+	case "int64":
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return Value{}, err
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte(`"`)) {
+			return Value{}, fmt.Errorf("string %s is not an int64", raw)
+		}
+		i, err := n.Int64()
+		if err != nil {
+			// Accept integral floats, e.g. 1e3.
+			f, ferr := n.Float64()
+			if ferr != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return Value{}, err
+			}
+			i = int64(f)
+		}
+		return Int64Value(i), nil
+	case "float64":
+		var f float64
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return Value{}, err
+		}
+		return Float64Value(f), nil
+	default:
+		return Value{}, fmt.Errorf("unsupported type %q", typ)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute_test
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	iattribute "go.opentelemetry.io/otel/internal/attribute"
+)
+
+var (
+	codeInError = attribute.InRange("code", attribute.Int64Value(599), attribute.Int64Value(500))
+	isGet       = attribute.Equal("method", attribute.StringValue("GET"))
+)
+
+func TestConditionEvaluate(t *testing.T) {
+	get500 := []attribute.KeyValue{attribute.Int("code", 500), attribute.String("method", "GET")}
+	post200 := []attribute.KeyValue{attribute.Int("code", 200), attribute.String("method", "POST")}
+	none := []attribute.KeyValue{attribute.String("other", "")}
+
+	for _, test := range []struct {
+		cond attribute.Condition
+		want [3]bool // get500, post200, none
+	}{
+		{attribute.Condition{}, [3]bool{true, true, true}},
+		{attribute.And(), [3]bool{true, true, true}},
+		{attribute.Or(), [3]bool{false, false, false}},
+		{attribute.HasKey("code"), [3]bool{true, true, false}},
+		{codeInError, [3]bool{true, false, false}},
+		{isGet, [3]bool{true, false, false}},
+		{attribute.Not(isGet), [3]bool{false, true, true}},
+		{attribute.And(codeInError, isGet), [3]bool{true, false, false}},
+		{attribute.And(codeInError, attribute.Not(isGet)), [3]bool{false, false, false}},
+		{attribute.Or(codeInError, attribute.Not(isGet)), [3]bool{true, true, true}},
+		{attribute.Or(attribute.And(attribute.Not(codeInError), attribute.HasKey("code")), isGet), [3]bool{true, true, false}},
+	} {
+		got := [3]bool{test.cond.Evaluate(get500), test.cond.Evaluate(post200), test.cond.Evaluate(none)}
+		assert.Equal(t, test.want, got, test.cond.String())
+	}
+}
+
+func TestConditionString(t *testing.T) {
+	cond := attribute.Or(codeInError, attribute.Not(isGet), attribute.HasKey("user"))
+	assert.Equal(t, `(code IN [500, 599] OR NOT method = "GET" OR user EXISTS)`, cond.String())
+	assert.Equal(t, "TRUE", attribute.Condition{}.String())
+}
+
+func TestConditionJSON(t *testing.T) {
+	cond := attribute.And(
+		attribute.Or(codeInError, attribute.Not(isGet)),
+		attribute.HasKey("user"),
+		attribute.Equal("ratio", attribute.Float64Value(0.5)),
+		attribute.Equal("ok", attribute.BoolValue(true)),
+		attribute.Equal("id", attribute.Int64Value(1<<62+1)),
+		attribute.Or(),
+	)
+	data, err := json.Marshal(cond)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"and": [
+		{"or": [
+			{"key": "code", "type": "int64", "values": [500, 599]},
+			{"not": {"key": "method", "type": "string", "values": ["GET"]}}
+		]},
+		{"key": "user"},
+		{"key": "ratio", "type": "float64", "values": [0.5]},
+		{"key": "ok", "type": "bool", "values": [true]},
+		{"key": "id", "type": "int64", "values": [4611686018427387905]},
+		{"or": []}
+	]}`, string(data))
+
+	var decoded attribute.Condition
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, cond, decoded)
+}
+
+func TestConditionJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`{}`,
+		`{"key": "a", "not": {"key": "b"}}`,
+		`{"key": "a", "type": "int64", "values": ["1"]}`,
+		`{"key": "a", "type": "string", "op": "range", "values": ["a", "b"]}`,
+		`{"key": "a", "type": "int64", "op": "range", "values": [1, 2, 3]}`,
+		`{"key": "a", "type": "int64", "op": "like", "values": [1]}`,
+		`{"key": "a", "type": "int64", "values": [1, 2, 3]}`,
+		`{"key": "a", "type": "map", "values": [1]}`,
+	} {
+		var c attribute.Condition
+		assert.Error(t, json.Unmarshal([]byte(data), &c), data)
+	}
+}
+
+func TestConditionJSONMultipleValues(t *testing.T) {
+	var cond attribute.Condition
+	require.NoError(t, json.Unmarshal([]byte(`{"key": "code", "type": "int64", "op": "in", "values": [500, 502, 503]}`), &cond))
+	assert.Equal(t, attribute.In("code", attribute.Int64Value(500), attribute.Int64Value(502), attribute.Int64Value(503)), cond)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"key": "code", "type": "int64", "op": "range", "values": [500, 503, 510, 520]}`), &cond))
+	assert.Equal(t, attribute.Or(
+		attribute.InRange("code", attribute.Int64Value(500), attribute.Int64Value(503)),
+		attribute.InRange("code", attribute.Int64Value(510), attribute.Int64Value(520)),
+	), cond)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"key": "method", "type": "string", "values": ["GET", "HEAD"]}`), &cond))
+	assert.Equal(t, attribute.In("method", attribute.StringValue("GET"), attribute.StringValue("HEAD")), cond)
+}
+
+func TestFilterCondition(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddKeyMatch("method")
+	f.SetCondition(attribute.Or(codeInError, isGet))
+
+	notMatched := func(attrs ...attribute.KeyValue) bool {
+		var called bool
+		f.BatchNotMatch(attrs, func() error {
+			called = true
+			return nil
+		})
+		return called
+	}
+	assert.False(t, notMatched(attribute.Int("code", 503)), "condition should replace key matches")
+	assert.False(t, notMatched(attribute.String("method", "GET")))
+	assert.True(t, notMatched(attribute.Int("code", 200), attribute.String("method", "POST")))
+
+	f.Clear()
+	assert.False(t, notMatched(attribute.Int("code", 200)), "Clear should remove the condition")
+}
+
+func TestFilterWildcardKeyMatch(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddEqualityMatch("a", attribute.IntValue(1))
+	assert.False(t, f.Match("b", attribute.IntValue(1)))
+
+	f.AddKeyMatch(attribute.WildcardKey)
+	assert.True(t, f.Match("b", attribute.IntValue(1)))
+	assert.False(t, f.Match("a", attribute.IntValue(2)), "rules on a key should have precedence")
+
+	var dropped bool
+	f.BatchNotMatch([]attribute.KeyValue{attribute.Int("a", 1)}, func() error {
+		dropped = true
+		return nil
+	})
+	assert.False(t, dropped, "WildcardKey should not be required")
+}
+
+func TestFilterRangeMatchReversedBounds(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddRangeMatch("code", attribute.Int64Value(599), attribute.Int64Value(500))
+	assert.True(t, f.Match("code", attribute.Int64Value(550)))
+}
+
+func TestConditionMayBeTrue(t *testing.T) {
+	cond := attribute.And(codeInError, attribute.Not(isGet))
+	assert.True(t, cond.MayBeTrue(nil), "missing attributes may be set later")
+	assert.True(t, cond.MayBeTrue([]attribute.KeyValue{attribute.Int("code", 503)}))
+	assert.False(t, cond.MayBeTrue([]attribute.KeyValue{attribute.Int("code", 200)}))
+	assert.False(t, cond.MayBeTrue([]attribute.KeyValue{attribute.String("method", "GET")}))
+
+	cond = attribute.Or(codeInError, isGet)
+	assert.True(t, cond.MayBeTrue([]attribute.KeyValue{attribute.Int("code", 200)}))
+	assert.False(t, cond.MayBeTrue([]attribute.KeyValue{attribute.Int("code", 200), attribute.String("method", "POST")}))
+	assert.False(t, attribute.Or().MayBeTrue(nil))
+	assert.True(t, attribute.Condition{}.MayBeTrue(nil))
+}
+
+func TestFilterBatchMayMatch(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddEqualityMatch("method", attribute.StringValue("GET"))
+	f.AddKeyMatch("code")
+	assert.True(t, f.BatchMayMatch(nil))
+	assert.True(t, f.BatchMayMatch([]attribute.KeyValue{attribute.String("method", "GET"), attribute.String("other", "x")}))
+	assert.False(t, f.BatchMayMatch([]attribute.KeyValue{attribute.String("method", "POST")}))
+
+	f.SetCondition(codeInError)
+	assert.True(t, f.BatchMayMatch([]attribute.KeyValue{attribute.String("method", "POST")}), "condition should replace key matches")
+	assert.False(t, f.BatchMayMatch([]attribute.KeyValue{attribute.Int("code", 200)}))
+}
+
+func TestFilterErrorsReported(t *testing.T) {
+	var errs []error
+	iattribute.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	t.Cleanup(func() { iattribute.SetErrorHandler(func(err error) { log.Print(err) }) })
+
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddRangeMatch("code", attribute.BoolValue(true), attribute.BoolValue(false))
+	f.AddEqualityMatch("ids", attribute.Int64SliceValue([]int64{1}))
+	assert.Empty(t, f.Rules(), "invalid matches should be skipped")
+	require.Len(t, errs, 2, "invalid matches should be reported")
+	assert.ErrorContains(t, errs[0], "code")
+
+	errs = nil
+	f.AddKeyMatch("user")
+	f.BatchNotMatch(nil, func() error { return errors.New("dropped") })
+	assert.Equal(t, []error{errors.New("dropped")}, errs, "the error of the callback should be reported")
+}
@@ -1,140 +1,229 @@
 // Qiutong Men 2023 April. 25
 
 package attribute // import "go.opentelemetry.io/otel/attribute"
-import "net/http"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"go.opentelemetry.io/otel/internal/attribute"
+)
 
 type MatchValueFlag int
 
+// WildcardKey is the key of a key match matching every attribute key.
+const WildcardKey Key = "*"
+
 const (
 	NoValue MatchValueFlag = iota
 	EQUALITY
 	RANGE
+	// PREFIX, SUFFIX, REGEX, GLOB and LIKE match string attributes with a
+	// pattern, see AddPatternMatch.
+	PREFIX
+	SUFFIX
+	REGEX
+	GLOB
+	LIKE
+	// CONTAINSALL, CONTAINSANY and LENGTH match array attributes, see
+	// AddContainsMatch and AddLengthMatch.
+	CONTAINSALL
+	CONTAINSANY
+	LENGTH
 )
 
 type TraceAttributeFilter interface {
 	AddRangeMatch(key Key, lb Value, ub Value)
 	AddEqualityMatch(key Key, value Value)
 	AddKeyMatch(key Key)
+	AddPatternMatch(key Key, flag MatchValueFlag, pattern string) error
+	AddContainsMatch(key Key, flag MatchValueFlag, values ...Value) error
+	AddLengthMatch(key Key, lb, ub int64)
 	RemoveMatch(key Key)
 	Match(key Key, value Value) bool
 	BatchMatch(attrs []KeyValue, callback func(KeyValue) error)
 	BatchNotMatch(attrs []KeyValue, callback func() error)
+	// BatchMayMatch reports whether a span whose attributes include attrs,
+	// and possibly others set later, may pass BatchNotMatch.
+	BatchMayMatch(attrs []KeyValue) bool
+	SetCondition(cond Condition)
+	// Rules returns the rules of the filter, sorted by key.
+	Rules() []Rule
+	// Snapshot returns an immutable copy of the rules and the condition of
+	// the filter.
+	Snapshot() Snapshot
+	// Restore replaces the rules and the condition of the filter by the
+	// ones of the snapshot.
+	Restore(s Snapshot)
 	Clear()
 	HandleRequest(req *http.Request) error
 }
 
 type TraceAttributeValueMatch struct {
 	mvf MatchValueFlag
-	lb  Value // range lower bound, inclusive
-	ub  Value // range upper bound, exclusive
-
+	lb  Value          // range lower bound, inclusive
+	ub  Value          // range upper bound, exclusive
+	sm  *stringMatcher // compiled pattern of a string pattern match
 }
 
 type mapTraceAttributeFilter struct {
-	// matches is a map from attribute key to a value specifier
-	matches map[Key]TraceAttributeValueMatch
+	// matches is a map from attribute key to its value specifiers, a value
+	// matches the key if it matches any of them
+	matches map[Key]*keyMatches
+	// condition is the condition spans must satisfy, if set it replaces the
+	// matches in BatchNotMatch, compiled is its compiled form
+	condition Condition
+	compiled  *compiledCondition
 }
 
-// AddRangeMatch appends a legal range match to the filter
+// keyMatches are the matches of a key, in the order they were added, and
+// their index
+type keyMatches struct {
+	list  valueMatches
+	seen  map[TraceAttributeValueMatch]struct{}
+	index valueIndex
+}
+
+// AddRangeMatch appends a legal range match to the filter, the ranges of a
+// key are united. An illegal range, see Rule.Validate, is skipped and
+// reported to the ErrorHandler.
 func (f *mapTraceAttributeFilter) AddRangeMatch(key Key, lb Value, ub Value) {
-	// check if key exists
-	if _, ok := f.matches[key]; ok {
-		// logging duplicate key
-		println("Duplicate key: key already exists in the filter")
-		// overwrite the existing key
+	if err := checkRange(lb, ub); err != nil {
+		attribute.HandleError(fmt.Errorf("attribute filter: %s match skipped: %w", key, err))
+		return
 	}
+	f.add(key, newRangeMatch(lb, ub))
+}
 
-	// do type checking: lb and ub must be of the same type, and must be comparable
+// checkRange returns an error if lb and ub are not the bounds of a legal
+// range: they must be of the same type, INT64 or FLOAT64.
+func checkRange(lb Value, ub Value) error {
 	if lb.Type() != ub.Type() {
-		// logging illegal type
-		println("Illegal type: lower bound and upper bound must be of the same type")
-		return
+		return fmt.Errorf("range bounds of %s and %s types", lb.Type(), ub.Type())
 	}
-
 	if lb.Type() != INT64 && lb.Type() != FLOAT64 {
-		// logging illegal type
-		println("Illegal type: lower bound and upper bound must be of type INT64 or FLOAT64")
-		return
+		return fmt.Errorf("range of %s type", lb.Type())
 	}
+	return nil
+}
 
-	// check if lb <= ub
-	if lb.Type() == INT64 {
-		if lb.AsInt64() > ub.AsInt64() {
-			// reverse the order of lb and ub
-			f.matches[key] = TraceAttributeValueMatch{RANGE, ub, lb}
-		}
-	} else {
-		if lb.AsFloat64() > ub.AsFloat64() {
-			// reverse the order of lb and ub
-			f.matches[key] = TraceAttributeValueMatch{RANGE, ub, lb}
-		}
+// newRangeMatch returns a range match from lb to ub, reversing their order if
+// lb > ub.
+func newRangeMatch(lb Value, ub Value) TraceAttributeValueMatch {
+	if lb.Type() == INT64 && ub.Type() == INT64 && lb.AsInt64() > ub.AsInt64() ||
+		lb.Type() == FLOAT64 && ub.Type() == FLOAT64 && lb.AsFloat64() > ub.AsFloat64() {
+		lb, ub = ub, lb
 	}
-
-	f.matches[key] = TraceAttributeValueMatch{RANGE, lb, ub}
+	return TraceAttributeValueMatch{mvf: RANGE, lb: lb, ub: ub}
 }
 
-// AddEqualityMatch appends a legal equality match to the filter
+// AddEqualityMatch appends a legal equality match to the filter, a key
+// matches the set of its equality matches. An illegal value, see
+// Rule.Validate, is skipped and reported to the ErrorHandler.
 func (f *mapTraceAttributeFilter) AddEqualityMatch(key Key, value Value) {
-	// check if key exists
-	if _, ok := f.matches[key]; ok {
-		// logging duplicate key
-		println("Duplicate key: key already exists in the filter")
-		// overwrite the existing key
-	}
-
-	// do type checking: value must be of type BOOL, INT64, or STRING
-	if value.Type() != BOOL && value.Type() != INT64 && value.Type() != STRING {
-		// logging illegal type
-		println("Illegal type: value must be of type BOOL, INT64, or STRING")
+	if err := checkEquality(value); err != nil {
+		attribute.HandleError(fmt.Errorf("attribute filter: %s match skipped: %w", key, err))
 		return
 	}
+	f.add(key, TraceAttributeValueMatch{mvf: EQUALITY, lb: value, ub: value})
+}
 
-	f.matches[key] = TraceAttributeValueMatch{EQUALITY, value, value}
+// checkEquality returns an error if value cannot be matched by equality: it
+// must be of type BOOL, INT64, FLOAT64, or STRING.
+func checkEquality(value Value) error {
+	if isScalar(value.Type()) {
+		return nil
+	}
+	return fmt.Errorf("equality of %s type", value.Type())
 }
 
 // AddKeyMatch appends a legal key match to the filter, without value
 func (f *mapTraceAttributeFilter) AddKeyMatch(key Key) {
-	// check if key exists
-	if _, ok := f.matches[key]; ok {
-		// logging duplicate key
-		println("Duplicate key: key already exists in the filter")
-		// overwrite the existing key
-	}
+	f.add(key, TraceAttributeValueMatch{mvf: NoValue,
+		lb: InvalidValue(), ub: InvalidValue()})
+}
 
-	f.matches[key] = TraceAttributeValueMatch{NoValue,
-		InvalidValue(), InvalidValue()}
+// add appends m to the matches of key, unless it is already there
+func (f *mapTraceAttributeFilter) add(key Key, m TraceAttributeValueMatch) {
+	km, ok := f.matches[key]
+	if !ok {
+		km = &keyMatches{seen: make(map[TraceAttributeValueMatch]struct{})}
+		f.matches[key] = km
+	}
+	if _, ok := km.seen[m]; ok {
+		return
+	}
+	km.seen[m] = struct{}{}
+	km.list = append(km.list, m)
+	km.index.add(m)
 }
 
-// RemoveMatch removes a match from the filter
+// RemoveMatch removes all the matches of key from the filter
 func (f *mapTraceAttributeFilter) RemoveMatch(key Key) {
 	delete(f.matches, key)
 }
 
 // Match returns true if the key-value pair matches the filter
 func (f *mapTraceAttributeFilter) Match(key Key, value Value) bool {
-	if match, ok := f.matches[key]; ok {
-		switch match.mvf {
-		case NoValue:
+	if km, ok := f.matches[key]; ok {
+		return km.index.matches(value)
+	}
+	// a key match on WildcardKey matches every key
+	if km, ok := f.matches[WildcardKey]; ok {
+		return km.index.any
+	}
+	return false
+}
+
+// valueMatches are the matches of a key
+type valueMatches []TraceAttributeValueMatch
+
+// matches returns true if value matches any of ms
+func (ms valueMatches) matches(value Value) bool {
+	for _, m := range ms {
+		if m.matches(value) {
 			return true
-		case EQUALITY:
-			return match.lb == value
-		case RANGE:
-			if value.Type() == INT64 && match.lb.Type() == INT64 {
-				return match.lb.AsInt64() <= value.AsInt64() &&
-					value.AsInt64() <= match.ub.AsInt64()
-			} else if value.Type() == FLOAT64 && match.lb.Type() == FLOAT64 {
-				return match.lb.AsFloat64() <= value.AsFloat64() &&
-					value.AsFloat64() <= match.ub.AsFloat64()
-			}
 		}
 	}
 	return false
 }
 
+// matches returns true if value matches m
+func (m TraceAttributeValueMatch) matches(value Value) bool {
+	switch m.mvf {
+	case NoValue:
+		return true
+	case EQUALITY:
+		return m.lb == value
+	case RANGE:
+		if value.Type() == INT64 && m.lb.Type() == INT64 {
+			return m.lb.AsInt64() <= value.AsInt64() &&
+				value.AsInt64() <= m.ub.AsInt64()
+		} else if value.Type() == FLOAT64 && m.lb.Type() == FLOAT64 {
+			return m.lb.AsFloat64() <= value.AsFloat64() &&
+				value.AsFloat64() <= m.ub.AsFloat64()
+		}
+	case PREFIX, SUFFIX, REGEX, GLOB, LIKE:
+		return value.Type() == STRING && m.sm != nil && m.sm.match(value.AsString())
+	case CONTAINSALL, CONTAINSANY, LENGTH:
+		return m.matchesArray(value)
+	}
+	return false
+}
+
+// SetCondition sets the condition spans must satisfy, the zero Condition
+// removes it
+func (f *mapTraceAttributeFilter) SetCondition(cond Condition) {
+	f.condition = cond
+	f.compiled = compileCondition(cond)
+}
+
 // Clear clears all filters
 func (f *mapTraceAttributeFilter) Clear() {
 	// directly assign a new map to the map, the old map will be garbage collected
-	f.matches = make(map[Key]TraceAttributeValueMatch)
+	f.matches = make(map[Key]*keyMatches)
+	f.SetCondition(Condition{})
 }
 
 // HandleRequest execute the filter operations and returns an error if the request is unsupported
@@ -155,31 +244,96 @@ func (f *mapTraceAttributeFilter) BatchMatch(attrs []KeyValue, callback func(Key
 	}
 }
 
-// BatchNotMatch execute callback if any existing filter is not matched, callback is executed only once
+// BatchNotMatch execute callback if any existing filter is not matched, callback is executed only once.
+// If a condition is set, callback is executed if attrs do not satisfy the condition instead.
+// The error returned by callback is reported to the ErrorHandler.
 func (f *mapTraceAttributeFilter) BatchNotMatch(attrs []KeyValue, callback func() error) {
+	if !f.condition.IsZero() {
+		if !f.compiled.evaluate(attrs) {
+			notMatched(callback)
+		}
+		return
+	}
 	matchedTarget := len(f.matches)
+	if _, ok := f.matches[WildcardKey]; ok {
+		// WildcardKey selects no span, it is not a key to match
+		matchedTarget--
+	}
 	for _, attr := range attrs {
 		if _, ok := f.matches[attr.Key]; !ok {
 			continue
 		}
 		if !f.Match(attr.Key, attr.Value) {
-			if err := callback(); err != nil {
-				println("Error in callback function of BatchNotMatch: ", err.Error())
-			}
+			notMatched(callback)
 			return
 		} else {
 			matchedTarget--
 		}
 	}
 	if matchedTarget != 0 {
-		if err := callback(); err != nil {
-			println("Error in callback function of BatchNotMatch: ", err.Error())
+		notMatched(callback)
+	}
+}
+
+// notMatched executes callback, the callback of BatchNotMatch, and reports
+// its error to the ErrorHandler.
+func notMatched(callback func() error) {
+	if err := callback(); err != nil {
+		attribute.HandleError(err)
+	}
+}
+
+// BatchMayMatch returns false if a span whose attributes include attrs fails
+// BatchNotMatch whatever the attributes set later: attrs do not satisfy the
+// condition whatever the missing attributes, or without condition, one of
+// attrs does not match the filter of its key.
+func (f *mapTraceAttributeFilter) BatchMayMatch(attrs []KeyValue) bool {
+	if !f.condition.IsZero() {
+		return f.compiled.mayBeTrue(attrs)
+	}
+	for _, attr := range attrs {
+		if _, ok := f.matches[attr.Key]; ok && !f.Match(attr.Key, attr.Value) {
+			return false
 		}
 	}
+	return true
 }
 
-func NewMapTraceAttributeFilter() TraceAttributeFilter {
-	return &mapTraceAttributeFilter{
-		matches: make(map[Key]TraceAttributeValueMatch),
+// Rules returns the rules of the filter, sorted by key.
+func (f *mapTraceAttributeFilter) Rules() []Rule {
+	rules := make([]Rule, 0, len(f.matches))
+	for key, km := range f.matches {
+		for _, match := range km.list {
+			rules = append(rules, Rule{Key: key, Flag: match.mvf, LowerBound: match.lb, UpperBound: match.ub})
+		}
+	}
+	// The matches of a key keep their order.
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Key < rules[j].Key })
+	return rules
+}
+
+// Snapshot returns a copy of the rules and the condition of the filter.
+func (f *mapTraceAttributeFilter) Snapshot() Snapshot {
+	return Snapshot{rules: f.Rules(), condition: f.condition}
+}
+
+// Restore replaces the rules and the condition of the filter by the ones of
+// s. Invalid rules are skipped as by the Add methods.
+func (f *mapTraceAttributeFilter) Restore(s Snapshot) {
+	f.Clear()
+	for _, rule := range s.rules {
+		rule.AddTo(f)
 	}
+	f.SetCondition(s.condition)
+}
+
+// MarshalJSON returns the JSON form of the Snapshot of the filter.
+func (f *mapTraceAttributeFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Snapshot())
+}
+
+func NewMapTraceAttributeFilter() TraceAttributeFilter {
+	f := &mapTraceAttributeFilter{}
+	f.Clear()
+	return f
 }
@@ -0,0 +1,59 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/attribute"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleError is an invalid filter of a filter request.
+type RuleError struct {
+	// Table is the table of the filter in a query request, empty for the
+	// other requests.
+	Table string
+	// Index is the index of the filter in the filters of the request, or of
+	// its table.
+	Index int
+	// Key is the key of the filter.
+	Key Key
+	// Err is the reason the filter is invalid.
+	Err error
+}
+
+func (e RuleError) Error() string {
+	if e.Table != "" {
+		return fmt.Sprintf("table %s filter %d on %q: %v", e.Table, e.Index, e.Key, e.Err)
+	}
+	return fmt.Sprintf("filter %d on %q: %v", e.Index, e.Key, e.Err)
+}
+
+func (e RuleError) Unwrap() error {
+	return e.Err
+}
+
+// FilterRequestError is the error of a filter request with invalid filters,
+// of which none is applied. Rules lists every invalid filter of the request.
+type FilterRequestError struct {
+	Rules []RuleError
+}
+
+func (e *FilterRequestError) Error() string {
+	msgs := make([]string, len(e.Rules))
+	for i, r := range e.Rules {
+		msgs[i] = r.Error()
+	}
+	return "invalid filter request: " + strings.Join(msgs, "; ")
+}
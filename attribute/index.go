@@ -0,0 +1,270 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/attribute"
+
+import (
+	"math"
+	"sort"
+)
+
+// valueIndex is the compiled form of the matches of a key, a value matching
+// any of them matches the index. The EQUALITY values are hashed and the RANGE
+// matches are merged into sorted disjoint intervals, so that the cost of a
+// match does not grow with the number of values and ranges, e.g. of an
+// allowlist of thousands of IDs. The patterns, compiled once (see
+// compilePattern), and the array matches are tried in turn.
+type valueIndex struct {
+	any    bool
+	equal  map[Value]struct{}
+	ints   intervals[int64]
+	floats intervals[float64]
+	others valueMatches
+}
+
+// add adds m to the matches of ix.
+func (ix *valueIndex) add(m TraceAttributeValueMatch) {
+	switch {
+	case m.mvf == NoValue:
+		ix.any = true
+	case m.mvf == EQUALITY && isScalar(m.lb.Type()):
+		if ix.equal == nil {
+			ix.equal = make(map[Value]struct{})
+		}
+		ix.equal[m.lb] = struct{}{}
+	case m.mvf == RANGE && m.lb.Type() == INT64 && m.ub.Type() == INT64:
+		ix.ints.add(m.lb.AsInt64(), m.ub.AsInt64())
+	case m.mvf == RANGE && m.lb.Type() == FLOAT64 && m.ub.Type() == FLOAT64:
+		lb, ub := m.lb.AsFloat64(), m.ub.AsFloat64()
+		// A range with a NaN bound matches no value.
+		if !math.IsNaN(lb) && !math.IsNaN(ub) {
+			ix.floats.add(lb, ub)
+		}
+	default:
+		ix.others = append(ix.others, m)
+	}
+}
+
+// matches reports whether value matches any match of ix.
+func (ix *valueIndex) matches(value Value) bool {
+	if ix.any {
+		return true
+	}
+	switch value.Type() {
+	case INT64:
+		if ix.ints.contains(value.AsInt64()) {
+			return true
+		}
+	case FLOAT64:
+		if ix.floats.contains(value.AsFloat64()) {
+			return true
+		}
+	}
+	if ix.equal != nil && isScalar(value.Type()) {
+		if _, ok := ix.equal[value]; ok {
+			return true
+		}
+	}
+	return ix.others.matches(value)
+}
+
+// isScalar reports whether typ is the type of the values of an EQUALITY
+// match.
+func isScalar(typ Type) bool {
+	switch typ {
+	case BOOL, INT64, FLOAT64, STRING:
+		return true
+	}
+	return false
+}
+
+// interval is an interval of values, bounds included.
+type interval[T int64 | float64] struct {
+	lb, ub T
+}
+
+// intervals are disjoint intervals sorted by their bounds.
+type intervals[T int64 | float64] []interval[T]
+
+// add adds the interval from lb to ub, lb <= ub, merging the intervals it
+// overlaps.
+func (is *intervals[T]) add(lb, ub T) {
+	s := *is
+	// s[i:j] are the intervals overlapping the new one.
+	i := sort.Search(len(s), func(i int) bool { return s[i].ub >= lb })
+	j := sort.Search(len(s), func(j int) bool { return s[j].lb > ub })
+	if i < j {
+		if s[i].lb < lb {
+			lb = s[i].lb
+		}
+		if s[j-1].ub > ub {
+			ub = s[j-1].ub
+		}
+	}
+	merged := append(s[:i:i], interval[T]{lb: lb, ub: ub})
+	*is = append(merged, s[j:]...)
+}
+
+// contains reports whether v is within one of is.
+func (is intervals[T]) contains(v T) bool {
+	i := sort.Search(len(is), func(i int) bool { return is[i].ub >= v })
+	return i < len(is) && is[i].lb <= v
+}
+
+// compiledCondition is the compiled form of a Condition evaluated by a
+// filter. The leaves of an Or are merged by key into a valueIndex, so that an
+// Or of many leaves, e.g. an In of thousands of values or an Or on thousands
+// of keys, is evaluated in a single pass over the attributes.
+//
+// A compiledCondition is evaluated over attributes with unique keys, as the
+// ones of a span: a key set twice may satisfy an Or by its last value where
+// the Condition is only evaluated on the first.
+type compiledCondition struct {
+	op conditionOp
+	// key and index are the leaf of a condMatch.
+	key   Key
+	index *valueIndex
+	// keys are the leaves of an Or by key.
+	keys map[Key]*valueIndex
+	// operands are the operands of an And or a Not, and the operands of an
+	// Or that are not leaves.
+	operands []*compiledCondition
+}
+
+// compileCondition returns the compiled form of c.
+func compileCondition(c Condition) *compiledCondition {
+	cc := &compiledCondition{op: c.op}
+	switch c.op {
+	case condMatch:
+		cc.key = c.key
+		cc.index = &valueIndex{}
+		cc.index.add(c.match)
+	case condOr:
+		for _, operand := range c.operands {
+			if operand.op != condMatch {
+				cc.operands = append(cc.operands, compileCondition(operand))
+				continue
+			}
+			if cc.keys == nil {
+				cc.keys = make(map[Key]*valueIndex)
+			}
+			ix, ok := cc.keys[operand.key]
+			if !ok {
+				ix = &valueIndex{}
+				cc.keys[operand.key] = ix
+			}
+			ix.add(operand.match)
+		}
+	case condAnd, condNot:
+		for _, operand := range c.operands {
+			cc.operands = append(cc.operands, compileCondition(operand))
+		}
+	}
+	return cc
+}
+
+// evaluate reports whether attrs satisfy the condition, see
+// Condition.Evaluate.
+func (c *compiledCondition) evaluate(attrs []KeyValue) bool {
+	switch c.op {
+	case condMatch:
+		for _, attr := range attrs {
+			if attr.Key == c.key {
+				return c.index.matches(attr.Value)
+			}
+		}
+		return false
+	case condAnd:
+		for _, operand := range c.operands {
+			if !operand.evaluate(attrs) {
+				return false
+			}
+		}
+		return true
+	case condOr:
+		if len(c.keys) > 0 {
+			for _, attr := range attrs {
+				if ix, ok := c.keys[attr.Key]; ok && ix.matches(attr.Value) {
+					return true
+				}
+			}
+		}
+		for _, operand := range c.operands {
+			if operand.evaluate(attrs) {
+				return true
+			}
+		}
+		return false
+	case condNot:
+		return !c.operands[0].evaluate(attrs)
+	default:
+		return true
+	}
+}
+
+// mayBeTrue reports whether the condition may be true for a span whose
+// attributes include attrs, see Condition.MayBeTrue.
+func (c *compiledCondition) mayBeTrue(attrs []KeyValue) bool {
+	value, known := c.partialEvaluate(attrs)
+	return value || !known
+}
+
+// partialEvaluate evaluates the condition over attrs with the attributes
+// missing from attrs unknown, see Condition.partialEvaluate.
+func (c *compiledCondition) partialEvaluate(attrs []KeyValue) (value, known bool) {
+	switch c.op {
+	case condMatch:
+		for _, attr := range attrs {
+			if attr.Key == c.key {
+				return c.index.matches(attr.Value), true
+			}
+		}
+		return false, false
+	case condAnd:
+		known = true
+		for _, operand := range c.operands {
+			v, k := operand.partialEvaluate(attrs)
+			if k && !v {
+				return false, true
+			}
+			known = known && k
+		}
+		return true, known
+	case condOr:
+		// The leaves are known if all their keys are set.
+		set := 0
+		for _, attr := range attrs {
+			if ix, ok := c.keys[attr.Key]; ok {
+				if ix.matches(attr.Value) {
+					return true, true
+				}
+				set++
+			}
+		}
+		known = set == len(c.keys)
+		for _, operand := range c.operands {
+			v, k := operand.partialEvaluate(attrs)
+			if k && v {
+				return true, true
+			}
+			known = known && k
+		}
+		return false, known
+	case condNot:
+		value, known = c.operands[0].partialEvaluate(attrs)
+		return !value, known
+	default:
+		return true, true
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestFilterIndexedMatches(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	for i := 0; i < 1000; i++ {
+		f.AddEqualityMatch("tenant", attribute.StringValue(fmt.Sprintf("t%d", i)))
+	}
+	f.AddEqualityMatch("tenant", attribute.StringValue("t1"))
+	f.AddRangeMatch("code", attribute.Int64Value(500), attribute.Int64Value(503))
+	f.AddRangeMatch("code", attribute.Int64Value(400), attribute.Int64Value(404))
+	f.AddRangeMatch("code", attribute.Int64Value(502), attribute.Int64Value(599))
+	f.AddEqualityMatch("code", attribute.Int64Value(200))
+	f.AddRangeMatch("ratio", attribute.Float64Value(0.5), attribute.Float64Value(0.75))
+	f.AddRangeMatch("ratio", attribute.Float64Value(math.NaN()), attribute.Float64Value(1))
+	require.NoError(t, f.AddPatternMatch("route", attribute.PREFIX, "/api/"))
+	f.AddEqualityMatch("route", attribute.StringValue("/health"))
+
+	for _, test := range []struct {
+		kv   attribute.KeyValue
+		want bool
+	}{
+		{attribute.String("tenant", "t0"), true},
+		{attribute.String("tenant", "t999"), true},
+		{attribute.String("tenant", "t1000"), false},
+		{attribute.Int("tenant", 1), false},
+		{attribute.Int("code", 200), true},
+		{attribute.Int("code", 399), false},
+		{attribute.Int("code", 404), true},
+		{attribute.Int("code", 405), false},
+		{attribute.Int("code", 500), true},
+		{attribute.Int("code", 550), true},
+		{attribute.Int("code", 599), true},
+		{attribute.Int("code", 600), false},
+		{attribute.Float64("code", 550), false},
+		{attribute.Float64("ratio", 0.5), true},
+		{attribute.Float64("ratio", 0.8), false},
+		{attribute.Float64("ratio", math.NaN()), false},
+		{attribute.String("route", "/api/cart"), true},
+		{attribute.String("route", "/health"), true},
+		{attribute.String("route", "/"), false},
+	} {
+		assert.Equal(t, test.want, f.Match(test.kv.Key, test.kv.Value), "%s=%s", test.kv.Key, test.kv.Value.Emit())
+	}
+
+	rules := f.Rules()
+	assert.Len(t, rules, 1000+4+2+2, "duplicate matches should be skipped")
+	assert.Equal(t, attribute.RangeRule("code", attribute.Int64Value(500), attribute.Int64Value(503)), rules[0], "the matches of a key should keep their order")
+
+	f.RemoveMatch("tenant")
+	assert.False(t, f.Match("tenant", attribute.StringValue("t0")))
+}
+
+func TestFilterCompiledCondition(t *testing.T) {
+	tenants := make([]attribute.Value, 1000)
+	for i := range tenants {
+		tenants[i] = attribute.StringValue(fmt.Sprintf("t%d", i))
+	}
+	keys := make([]attribute.Condition, 1000)
+	for i := range keys {
+		keys[i] = attribute.Equal(attribute.Key(fmt.Sprintf("k%d", i)), attribute.IntValue(i))
+	}
+	conds := []attribute.Condition{
+		attribute.And(),
+		attribute.Or(),
+		attribute.HasKey("code"),
+		codeInError,
+		attribute.Not(isGet),
+		attribute.And(codeInError, attribute.Not(isGet)),
+		attribute.Or(codeInError, attribute.Not(isGet)),
+		attribute.Or(attribute.And(attribute.Not(codeInError), attribute.HasKey("code")), isGet),
+		attribute.In("tenant", tenants...),
+		attribute.And(attribute.In("tenant", tenants...), codeInError),
+		attribute.Or(keys...),
+		attribute.Or(append([]attribute.Condition{codeInError, attribute.In("tenant", tenants...)}, keys...)...),
+		attribute.Not(attribute.Or(isGet, attribute.Equal("method", attribute.StringValue("POST")))),
+	}
+	attrSets := [][]attribute.KeyValue{
+		nil,
+		{attribute.Int("code", 500), attribute.String("method", "GET")},
+		{attribute.Int("code", 200), attribute.String("method", "POST")},
+		{attribute.String("tenant", "t42"), attribute.Int("code", 503)},
+		{attribute.String("tenant", "t1000"), attribute.Int("code", 503)},
+		{attribute.Int("k7", 7)},
+		{attribute.Int("k7", 8), attribute.String("other", "")},
+		{attribute.String("method", "PUT")},
+	}
+	for _, cond := range conds {
+		f := attribute.NewMapTraceAttributeFilter()
+		f.SetCondition(cond)
+		for _, attrs := range attrSets {
+			var dropped bool
+			f.BatchNotMatch(attrs, func() error {
+				dropped = true
+				return nil
+			})
+			assert.Equal(t, cond.Evaluate(attrs), !dropped, "%.80s over %v", cond, attrs)
+			assert.Equal(t, cond.MayBeTrue(attrs), f.BatchMayMatch(attrs), "%.80s over %v", cond, attrs)
+		}
+	}
+}
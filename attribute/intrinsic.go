@@ -0,0 +1,37 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/attribute"
+
+// The intrinsic keys are the reserved keys of the pseudo-attributes of a span
+// evaluated against the span itself rather than against its attributes, e.g.
+// a condition on SpanDurationKey selects spans by their duration. They are
+// added to the attributes of a span when its filters are evaluated, and are
+// not exported.
+const (
+	// SpanNameKey is the name of the span, a STRING.
+	SpanNameKey Key = "@name"
+	// SpanKindKey is the kind of the span, a STRING among "internal",
+	// "server", "client", "producer" and "consumer".
+	SpanKindKey Key = "@kind"
+	// SpanStatusKey is the status code of the span, a STRING among "UNSET",
+	// "OK" and "ERROR".
+	SpanStatusKey Key = "@status"
+	// SpanDurationKey is the duration of the span in nanoseconds, an INT64
+	// set once the span ended.
+	SpanDurationKey Key = "@duration"
+	// SpanScopeKey is the name of the instrumentation scope of the span, a
+	// STRING.
+	SpanScopeKey Key = "@scope"
+)
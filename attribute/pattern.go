@@ -0,0 +1,193 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/attribute"
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// stringMatcher is a compiled string pattern.
+type stringMatcher struct {
+	match func(string) bool
+}
+
+type patternKey struct {
+	flag    MatchValueFlag
+	pattern string
+}
+
+// patternCache holds the compiled patterns by patternKey, so that a pattern
+// is compiled once and equal pattern matches share the same *stringMatcher.
+var patternCache sync.Map
+
+// isPattern reports whether flag is the flag of a string pattern match.
+func isPattern(flag MatchValueFlag) bool {
+	switch flag {
+	case PREFIX, SUFFIX, REGEX, GLOB, LIKE:
+		return true
+	}
+	return false
+}
+
+// compilePattern returns the cached stringMatcher of pattern, compiling it if
+// needed.
+func compilePattern(flag MatchValueFlag, pattern string) (*stringMatcher, error) {
+	key := patternKey{flag: flag, pattern: pattern}
+	if sm, ok := patternCache.Load(key); ok {
+		return sm.(*stringMatcher), nil
+	}
+	var sm *stringMatcher
+	switch flag {
+	case PREFIX:
+		sm = &stringMatcher{match: func(s string) bool { return strings.HasPrefix(s, pattern) }}
+	case SUFFIX:
+		sm = &stringMatcher{match: func(s string) bool { return strings.HasSuffix(s, pattern) }}
+	case REGEX:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		sm = &stringMatcher{match: re.MatchString}
+	case GLOB:
+		sm = wildcardMatcher(pattern, '*', '?')
+	case LIKE:
+		sm = wildcardMatcher(pattern, '%', '_')
+	default:
+		return nil, fmt.Errorf("%d is not a pattern match", flag)
+	}
+	actual, _ := patternCache.LoadOrStore(key, sm)
+	return actual.(*stringMatcher), nil
+}
+
+// wildcardMatcher returns the matcher of a pattern where many is a wildcard
+// for any sequence of characters and one for a single character, a backslash
+// escaping the next character. Patterns that are a literal prefix followed by
+// many, or many followed by a literal suffix, are matched without regexp.
+func wildcardMatcher(pattern string, many, one rune) *stringMatcher {
+	var expr strings.Builder
+	var literal strings.Builder
+	leading, trailing, inner := false, false, false
+	escaped := false
+	runes := []rune(pattern)
+	for i, r := range runes {
+		switch {
+		case escaped:
+			escaped = false
+			literal.WriteRune(r)
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		case r == '\\':
+			escaped = true
+		case r == many:
+			switch {
+			case i == 0:
+				leading = true
+			case i == len(runes)-1:
+				trailing = true
+			default:
+				inner = true
+			}
+			expr.WriteString(".*")
+		case r == one:
+			inner = true
+			expr.WriteString(".")
+		default:
+			literal.WriteRune(r)
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaped {
+		// A trailing backslash matches itself.
+		literal.WriteRune('\\')
+		expr.WriteString(`\\`)
+	}
+
+	lit := literal.String()
+	switch {
+	case inner:
+	case leading && trailing:
+		return &stringMatcher{match: func(s string) bool { return strings.Contains(s, lit) }}
+	case trailing:
+		return &stringMatcher{match: func(s string) bool { return strings.HasPrefix(s, lit) }}
+	case leading:
+		return &stringMatcher{match: func(s string) bool { return strings.HasSuffix(s, lit) }}
+	default:
+		return &stringMatcher{match: func(s string) bool { return s == lit }}
+	}
+	re := regexp.MustCompile(`^(?s:` + expr.String() + `)$`)
+	return &stringMatcher{match: re.MatchString}
+}
+
+// newPatternMatch returns the match of the strings matching pattern.
+func newPatternMatch(flag MatchValueFlag, pattern string) (TraceAttributeValueMatch, error) {
+	sm, err := compilePattern(flag, pattern)
+	if err != nil {
+		return TraceAttributeValueMatch{}, err
+	}
+	value := StringValue(pattern)
+	return TraceAttributeValueMatch{mvf: flag, lb: value, ub: value, sm: sm}, nil
+}
+
+// AddPatternMatch appends a match of the string attributes matching pattern
+// to the filter. flag is one of PREFIX, SUFFIX, REGEX (unanchored, RE2
+// syntax), GLOB (* and ? wildcards) and LIKE (% and _ wildcards).
+func (f *mapTraceAttributeFilter) AddPatternMatch(key Key, flag MatchValueFlag, pattern string) error {
+	m, err := newPatternMatch(flag, pattern)
+	if err != nil {
+		return err
+	}
+	f.add(key, m)
+	return nil
+}
+
+// PatternRule returns the rule matching the key string attributes matching
+// pattern, see AddPatternMatch for flag.
+func PatternRule(key Key, flag MatchValueFlag, pattern string) (Rule, error) {
+	if _, err := compilePattern(flag, pattern); err != nil {
+		return Rule{}, err
+	}
+	return Rule{Key: key, Flag: flag, LowerBound: StringValue(pattern), UpperBound: StringValue(pattern)}, nil
+}
+
+// Pattern returns a condition true if the key attribute is a string matching
+// pattern, see AddPatternMatch for flag.
+func Pattern(key Key, flag MatchValueFlag, pattern string) (Condition, error) {
+	m, err := newPatternMatch(flag, pattern)
+	if err != nil {
+		return Condition{}, err
+	}
+	return Condition{op: condMatch, key: key, match: m}, nil
+}
+
+// patternOps maps the pattern flags to their operator in update requests.
+var patternOps = map[MatchValueFlag]string{
+	PREFIX: FilterOpPrefix,
+	SUFFIX: FilterOpSuffix,
+	REGEX:  FilterOpRegex,
+	GLOB:   FilterOpGlob,
+	LIKE:   FilterOpLike,
+}
+
+// patternFlag returns the pattern flag of the op operator.
+func patternFlag(op string) (MatchValueFlag, bool) {
+	for flag, o := range patternOps {
+		if o == op {
+			return flag, true
+		}
+	}
+	return 0, false
+}
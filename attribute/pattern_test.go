@@ -0,0 +1,122 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestPatternMatch(t *testing.T) {
+	for _, test := range []struct {
+		flag    attribute.MatchValueFlag
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{attribute.PREFIX, "/api/", []string{"/api/", "/api/users"}, []string{"/ap", "/v1/api/"}},
+		{attribute.SUFFIX, ".json", []string{"a.json"}, []string{"a.json.gz"}},
+		{attribute.REGEX, `^SELECT .* FROM users`, []string{"SELECT id FROM users"}, []string{"select id from users"}},
+		{attribute.REGEX, `users`, []string{"SELECT id FROM users WHERE 1"}, []string{"SELECT 1"}},
+		{attribute.GLOB, "/api/*/users", []string{"/api/v1/users", "/api//users"}, []string{"/api/v1/users/1"}},
+		{attribute.GLOB, "?.txt", []string{"a.txt"}, []string{"ab.txt", ".txt"}},
+		{attribute.GLOB, `\*.txt`, []string{"*.txt"}, []string{"a.txt"}},
+		{attribute.LIKE, "/api/%", []string{"/api/", "/api/users"}, []string{"/v1/api/"}},
+		{attribute.LIKE, "%.json", []string{"a.json"}, []string{"a.json.gz"}},
+		{attribute.LIKE, "%error%", []string{"an error occurred", "error"}, []string{"err"}},
+		{attribute.LIKE, "GET", []string{"GET"}, []string{"GETS", "get"}},
+		{attribute.LIKE, "a_c%", []string{"abc", "a.cdef", "a\ncd"}, []string{"ac", "bac"}},
+		{attribute.LIKE, `100\%`, []string{"100%"}, []string{"1000"}},
+		{attribute.LIKE, "a.c", []string{"a.c"}, []string{"abc"}},
+	} {
+		cond, err := attribute.Pattern("a", test.flag, test.pattern)
+		require.NoError(t, err, test.pattern)
+		for _, s := range test.match {
+			assert.True(t, cond.Evaluate([]attribute.KeyValue{attribute.String("a", s)}), "%q should match %q", s, test.pattern)
+		}
+		for _, s := range test.noMatch {
+			assert.False(t, cond.Evaluate([]attribute.KeyValue{attribute.String("a", s)}), "%q should not match %q", s, test.pattern)
+		}
+		assert.False(t, cond.Evaluate([]attribute.KeyValue{attribute.Int("a", 1)}), "only strings match a pattern")
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	// attribute.Equal patterns share their compiled matcher.
+	a, err := attribute.Pattern("a", attribute.REGEX, "a+b")
+	require.NoError(t, err)
+	b, err := attribute.Pattern("a", attribute.REGEX, "a+b")
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+
+	_, err = attribute.Pattern("a", attribute.REGEX, "a(")
+	assert.Error(t, err)
+	_, err = attribute.Pattern("a", attribute.RANGE, "a")
+	assert.Error(t, err)
+}
+
+func TestFilterPatternMatch(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	require.NoError(t, f.AddPatternMatch("http.target", attribute.LIKE, "/api/%"))
+	require.NoError(t, f.AddPatternMatch("http.target", attribute.LIKE, "/api/%"))
+	require.NoError(t, f.AddPatternMatch("http.target", attribute.SUFFIX, ".json"))
+	assert.Error(t, f.AddPatternMatch("db.statement", attribute.REGEX, "("))
+	assert.Len(t, f.Rules(), 2, "identical patterns should be added once")
+
+	assert.True(t, f.Match("http.target", attribute.StringValue("/api/users")))
+	assert.True(t, f.Match("http.target", attribute.StringValue("/static/a.json")))
+	assert.False(t, f.Match("http.target", attribute.StringValue("/static/a.css")))
+	assert.False(t, f.Match("db.statement", attribute.StringValue("(")))
+
+	// Rules with patterns survive a snapshot copy.
+	data, err := json.Marshal(f.Snapshot())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"filters": [
+		{"key": "http.target", "type": "string", "op": "like", "values": ["/api/%"]},
+		{"key": "http.target", "type": "string", "op": "suffix", "values": [".json"]}
+	]}`, string(data))
+	var s attribute.Snapshot
+	require.NoError(t, json.Unmarshal(data, &s))
+	other := attribute.NewMapTraceAttributeFilter()
+	other.Restore(s)
+	assert.True(t, other.Match("http.target", attribute.StringValue("/api/users")))
+	assert.Equal(t, f.Rules(), other.Rules())
+}
+
+func TestPatternCondition(t *testing.T) {
+	like, err := attribute.Pattern("http.target", attribute.LIKE, "/api/%")
+	require.NoError(t, err)
+	cond := attribute.And(like, attribute.Not(attribute.Equal("http.method", attribute.StringValue("GET"))))
+	assert.Equal(t, `(http.target LIKE "/api/%" AND NOT http.method = "GET")`, cond.String())
+
+	assert.True(t, cond.Evaluate([]attribute.KeyValue{attribute.String("http.target", "/api/a"), attribute.String("http.method", "POST")}))
+	assert.False(t, cond.Evaluate([]attribute.KeyValue{attribute.String("http.target", "/api/a"), attribute.String("http.method", "GET")}))
+
+	data, err := json.Marshal(cond)
+	require.NoError(t, err)
+	var decoded attribute.Condition
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, cond, decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"key": "a", "type": "int64", "op": "like", "values": [1]}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`{"key": "a", "type": "string", "op": "regex", "values": ["("]}`), &decoded))
+	_, err = attribute.Pattern("a", attribute.REGEX, "(")
+	assert.Error(t, err)
+}
@@ -0,0 +1,301 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/attribute"
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Rule is a match of a TraceAttributeFilter on the attribute key. LowerBound
+// and UpperBound are the value of an EQUALITY match and the inclusive bounds
+// of a RANGE or LENGTH match, they are invalid for a NoValue (key) match.
+// Both are the array of the elements of a CONTAINSALL or CONTAINSANY match.
+type Rule struct {
+	Key        Key
+	Flag       MatchValueFlag
+	LowerBound Value
+	UpperBound Value
+}
+
+// KeyRule returns the rule matching any value of key.
+func KeyRule(key Key) Rule {
+	return Rule{Key: key, Flag: NoValue}
+}
+
+// EqualityRule returns the rule matching key attributes equal to value.
+func EqualityRule(key Key, value Value) Rule {
+	return Rule{Key: key, Flag: EQUALITY, LowerBound: value, UpperBound: value}
+}
+
+// RangeRule returns the rule matching key attributes within lb and ub,
+// inclusive.
+func RangeRule(key Key, lb, ub Value) Rule {
+	m := newRangeMatch(lb, ub)
+	return Rule{Key: key, Flag: RANGE, LowerBound: m.lb, UpperBound: m.ub}
+}
+
+// Operators of the filters of an update request.
+const (
+	// FilterOpIn matches the set of the values.
+	FilterOpIn = "in"
+	// FilterOpRange matches the union of the ranges of the values, taken by
+	// pairs of lower and upper bounds.
+	FilterOpRange = "range"
+	// FilterOpPrefix, FilterOpSuffix, FilterOpRegex, FilterOpGlob and
+	// FilterOpLike match the strings matching any of the values, see
+	// AddPatternMatch.
+	FilterOpPrefix = "prefix"
+	FilterOpSuffix = "suffix"
+	FilterOpRegex  = "regex"
+	FilterOpGlob   = "glob"
+	FilterOpLike   = "like"
+	// FilterOpContainsAll and FilterOpContainsAny match the arrays
+	// containing all or any of the values, see AddContainsMatch.
+	FilterOpContainsAll = "contains_all"
+	FilterOpContainsAny = "contains_any"
+	// FilterOpLength matches the arrays of length the value, or within the
+	// two values, see AddLengthMatch.
+	FilterOpLength = "length"
+)
+
+// FilterRules returns the rules on key of a filter of an update request with
+// the op operator and values. All the values must have the same type. No
+// value is a key rule. Without operator, two INT64 or FLOAT64 values are a
+// range and other values are a set, e.g.
+//
+//	FilterRules("code", "", Int64Value(500), Int64Value(599))
+//	FilterRules("code", FilterOpIn, Int64Value(500), Int64Value(502), Int64Value(503))
+//	FilterRules("code", FilterOpRange, Int64Value(500), Int64Value(503), Int64Value(510), Int64Value(520))
+//	FilterRules("tags", FilterOpContainsAny, StringValue("a"), StringValue("b"))
+func FilterRules(key Key, op string, values ...Value) ([]Rule, error) {
+	if len(values) == 0 {
+		return []Rule{KeyRule(key)}, nil
+	}
+	typ := values[0].Type()
+	for _, v := range values[1:] {
+		if v.Type() != typ {
+			return nil, fmt.Errorf("values of %s and %s types", typ, v.Type())
+		}
+	}
+	numeric := typ == INT64 || typ == FLOAT64
+	if op == "" {
+		switch {
+		case numeric && len(values) == 2:
+			op = FilterOpRange
+		case numeric && len(values) > 2:
+			return nil, fmt.Errorf("%d values without %q or %q operator", len(values), FilterOpIn, FilterOpRange)
+		default:
+			op = FilterOpIn
+		}
+	}
+	var rules []Rule
+	switch op {
+	case FilterOpIn:
+		for _, v := range values {
+			rules = append(rules, EqualityRule(key, v))
+		}
+	case FilterOpRange:
+		if !numeric {
+			return nil, fmt.Errorf("range of %s type", typ)
+		}
+		if len(values)%2 != 0 {
+			return nil, fmt.Errorf("range with an odd number of bounds")
+		}
+		for i := 0; i < len(values); i += 2 {
+			rules = append(rules, RangeRule(key, values[i], values[i+1]))
+		}
+	case FilterOpContainsAll, FilterOpContainsAny, FilterOpLength:
+		return arrayRules(key, op, values)
+	default:
+		flag, ok := patternFlag(op)
+		if !ok {
+			return nil, fmt.Errorf("unsupported operator %q", op)
+		}
+		if typ != STRING {
+			return nil, fmt.Errorf("%s of %s type", op, typ)
+		}
+		for _, v := range values {
+			rule, err := PatternRule(key, flag, v.AsString())
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (r Rule) match() TraceAttributeValueMatch {
+	if isPattern(r.Flag) {
+		// An invalid pattern matches nothing.
+		m, _ := newPatternMatch(r.Flag, r.LowerBound.AsString())
+		return m
+	}
+	return TraceAttributeValueMatch{mvf: r.Flag, lb: r.LowerBound, ub: r.UpperBound}
+}
+
+// Validate returns an error if r is not a legal match, the Add methods of a
+// TraceAttributeFilter skip it: the value of an EQUALITY match must be of
+// type BOOL, INT64, FLOAT64, or STRING, the bounds of a RANGE match of the
+// same type, INT64 or FLOAT64, the bounds of a LENGTH match non-negative
+// INT64 values, the lower one not above the upper one, the pattern of a
+// pattern match must compile and the elements of a CONTAINSALL or
+// CONTAINSANY match be a non-empty array.
+func (r Rule) Validate() error {
+	switch {
+	case r.Flag == NoValue:
+		return nil
+	case r.Flag == LENGTH:
+		return checkLength(r.LowerBound, r.UpperBound)
+	case r.Flag == EQUALITY:
+		return checkEquality(r.LowerBound)
+	case r.Flag == RANGE:
+		return checkRange(r.LowerBound, r.UpperBound)
+	case r.Flag == CONTAINSALL, r.Flag == CONTAINSANY:
+		_, err := newContainsMatch(r.Flag, sliceElements(r.LowerBound))
+		return err
+	case isPattern(r.Flag):
+		if r.LowerBound.Type() != STRING {
+			return fmt.Errorf("pattern of %s type", r.LowerBound.Type())
+		}
+		_, err := newPatternMatch(r.Flag, r.LowerBound.AsString())
+		return err
+	default:
+		return fmt.Errorf("unsupported match %d", r.Flag)
+	}
+}
+
+// AddTo adds r to f.
+func (r Rule) AddTo(f TraceAttributeFilter) {
+	switch r.Flag {
+	case NoValue:
+		f.AddKeyMatch(r.Key)
+	case EQUALITY:
+		f.AddEqualityMatch(r.Key, r.LowerBound)
+	case RANGE:
+		f.AddRangeMatch(r.Key, r.LowerBound, r.UpperBound)
+	case CONTAINSALL, CONTAINSANY:
+		_ = f.AddContainsMatch(r.Key, r.Flag, sliceElements(r.LowerBound)...)
+	case LENGTH:
+		f.AddLengthMatch(r.Key, r.LowerBound.AsInt64(), r.UpperBound.AsInt64())
+	default:
+		// An invalid pattern is skipped as the invalid matches of the
+		// other Add methods.
+		_ = f.AddPatternMatch(r.Key, r.Flag, r.LowerBound.AsString())
+	}
+}
+
+// Flag returns the kind of the match.
+func (m TraceAttributeValueMatch) Flag() MatchValueFlag {
+	return m.mvf
+}
+
+// LowerBound returns the value of an EQUALITY match or the lower bound of a
+// RANGE match.
+func (m TraceAttributeValueMatch) LowerBound() Value {
+	return m.lb
+}
+
+// UpperBound returns the value of an EQUALITY match or the upper bound of a
+// RANGE match.
+func (m TraceAttributeValueMatch) UpperBound() Value {
+	return m.ub
+}
+
+// Snapshot is an immutable copy of the rules and the condition of a
+// TraceAttributeFilter. Its JSON form is the one of an update request, e.g.
+//
+//	{"filters": [{"key": "code", "type": "int64", "values": [500, 599]}],
+//	 "where": {"key": "method", "type": "string", "values": ["GET"]}}
+//
+// so that a snapshot of a filter can be restored in another process.
+type Snapshot struct {
+	rules     []Rule
+	condition Condition
+}
+
+// NewSnapshot returns the snapshot of rules and cond. The rules are sorted by
+// key.
+func NewSnapshot(rules []Rule, cond Condition) Snapshot {
+	rules = append([]Rule(nil), rules...)
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Key < rules[j].Key })
+	return Snapshot{rules: rules, condition: cond}
+}
+
+// Rules returns a copy of the rules of s, sorted by key.
+func (s Snapshot) Rules() []Rule {
+	return append([]Rule(nil), s.rules...)
+}
+
+// Condition returns the condition of s, the zero condition if none is set.
+func (s Snapshot) Condition() Condition {
+	return s.condition
+}
+
+// filterJSON is the JSON form of a rule, the same as the filters of an update
+// request. Op is only set for the string patterns and the array matches when
+// encoding.
+type filterJSON struct {
+	Key    Key               `json:"key"`
+	Type   string            `json:"type"`
+	Op     string            `json:"op,omitempty"`
+	Values []json.RawMessage `json:"values"`
+}
+
+type snapshotJSON struct {
+	Filters []filterJSON `json:"filters"`
+	Where   *Condition   `json:"where,omitempty"`
+}
+
+// MarshalJSON returns the JSON form of s, where is omitted if no condition is
+// set.
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	out := snapshotJSON{Filters: make([]filterJSON, 0, len(s.rules))}
+	for _, rule := range s.rules {
+		typ, op, values, err := rule.match().valuesJSON()
+		if err != nil {
+			return nil, err
+		}
+		out.Filters = append(out.Filters, filterJSON{Key: rule.Key, Type: typ, Op: op, Values: values})
+	}
+	if !s.condition.IsZero() {
+		out.Where = &s.condition
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes the JSON form of a Snapshot.
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var in snapshotJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	rules := make([]Rule, 0, len(in.Filters))
+	for _, filter := range in.Filters {
+		filterRules, err := decodeRules(filter.Key, filter.Type, filter.Op, filter.Values)
+		if err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+		rules = append(rules, filterRules...)
+	}
+	var cond Condition
+	if in.Where != nil {
+		cond = *in.Where
+	}
+	*s = NewSnapshot(rules, cond)
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestFilterRules(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddRangeMatch("code", attribute.Int64Value(599), attribute.Int64Value(500))
+	f.AddEqualityMatch("method", attribute.StringValue("GET"))
+	f.AddKeyMatch("user")
+
+	want := []attribute.Rule{
+		attribute.RangeRule("code", attribute.Int64Value(500), attribute.Int64Value(599)),
+		attribute.EqualityRule("method", attribute.StringValue("GET")),
+		attribute.KeyRule("user"),
+	}
+	assert.Equal(t, want, f.Rules())
+	assert.Equal(t, attribute.RANGE, want[0].Flag)
+	assert.Equal(t, int64(500), want[0].LowerBound.AsInt64())
+}
+
+func TestFilterSnapshotRestore(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddRangeMatch("ratio", attribute.Float64Value(0.1), attribute.Float64Value(0.9))
+	f.AddEqualityMatch("ok", attribute.BoolValue(true))
+	f.AddKeyMatch("user")
+	f.SetCondition(attribute.Or(codeInError, isGet))
+
+	snapshot := f.Snapshot()
+	rules := snapshot.Rules()
+	rules[0] = attribute.KeyRule("changed")
+	assert.Equal(t, attribute.EqualityRule("ok", attribute.BoolValue(true)), snapshot.Rules()[0], "snapshot should be immutable")
+
+	f.RemoveMatch("user")
+	f.SetCondition(attribute.Condition{})
+	assert.Len(t, snapshot.Rules(), 3, "snapshot should not follow the filter")
+
+	// Copy the filter to another process.
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	var decoded attribute.Snapshot
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, snapshot, decoded)
+
+	other := attribute.NewMapTraceAttributeFilter()
+	other.AddKeyMatch("stale")
+	other.Restore(decoded)
+	assert.Equal(t, snapshot, other.Snapshot())
+	assert.False(t, other.Match("stale", attribute.StringValue("")))
+	assert.True(t, other.Match("ratio", attribute.Float64Value(0.5)))
+	assert.Equal(t, attribute.Or(codeInError, isGet), other.Snapshot().Condition())
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	snapshot := attribute.NewSnapshot([]attribute.Rule{
+		attribute.KeyRule("user"),
+		attribute.RangeRule("code", attribute.Int64Value(500), attribute.Int64Value(599)),
+	}, attribute.Condition{})
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"filters": [
+		{"key": "code", "type": "int64", "values": [500, 599]},
+		{"key": "user", "type": "", "values": []}
+	]}`, string(data))
+
+	var s attribute.Snapshot
+	assert.Error(t, json.Unmarshal([]byte(`{"filters": [{"key": "a", "type": "int64", "values": [1, 2, 3]}]}`), &s))
+
+	require.NoError(t, json.Unmarshal([]byte(`{"filters": [
+		{"key": "code", "type": "int64", "op": "in", "values": [500, 502]},
+		{"key": "code", "type": "int64", "op": "range", "values": [510, 520]}
+	]}`), &s))
+	assert.Equal(t, []attribute.Rule{
+		attribute.EqualityRule("code", attribute.Int64Value(500)),
+		attribute.EqualityRule("code", attribute.Int64Value(502)),
+		attribute.RangeRule("code", attribute.Int64Value(510), attribute.Int64Value(520)),
+	}, s.Rules())
+}
+
+func TestFilterMultipleValues(t *testing.T) {
+	f := attribute.NewMapTraceAttributeFilter()
+	for _, code := range []int64{500, 502, 503} {
+		f.AddEqualityMatch("code", attribute.Int64Value(code))
+	}
+	f.AddRangeMatch("code", attribute.Int64Value(510), attribute.Int64Value(520))
+	f.AddRangeMatch("code", attribute.Int64Value(510), attribute.Int64Value(520))
+	assert.Len(t, f.Rules(), 4, "identical matches should be added once")
+
+	for code, want := range map[int64]bool{500: true, 501: false, 503: true, 509: false, 515: true, 521: false} {
+		assert.Equal(t, want, f.Match("code", attribute.Int64Value(code)), code)
+	}
+	notMatched := func(attrs ...attribute.KeyValue) bool {
+		var called bool
+		f.BatchNotMatch(attrs, func() error {
+			called = true
+			return nil
+		})
+		return called
+	}
+	assert.False(t, notMatched(attribute.Int("code", 502)))
+	assert.True(t, notMatched(attribute.Int("code", 501)))
+
+	f.RemoveMatch("code")
+	assert.Empty(t, f.Rules())
+}
+
+func TestFilterRulesErrors(t *testing.T) {
+	for _, test := range []struct {
+		op     string
+		values []attribute.Value
+	}{
+		{"", []attribute.Value{attribute.IntValue(1), attribute.StringValue("1")}},
+		{"", []attribute.Value{attribute.IntValue(1), attribute.IntValue(2), attribute.IntValue(3)}},
+		{attribute.FilterOpRange, []attribute.Value{attribute.BoolValue(true), attribute.BoolValue(false)}},
+		{attribute.FilterOpRange, []attribute.Value{attribute.IntValue(1)}},
+		{"between", []attribute.Value{attribute.IntValue(1)}},
+	} {
+		_, err := attribute.FilterRules("a", test.op, test.values...)
+		assert.Error(t, err, test)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	for _, rule := range []attribute.Rule{
+		attribute.KeyRule("a"),
+		attribute.EqualityRule("a", attribute.StringValue("x")),
+		attribute.RangeRule("a", attribute.Float64Value(1), attribute.Float64Value(0)),
+		{Key: "a", Flag: attribute.LENGTH, LowerBound: attribute.Int64Value(1), UpperBound: attribute.Int64Value(2)},
+		{Key: "a", Flag: attribute.LENGTH, LowerBound: attribute.Int64Value(0), UpperBound: attribute.Int64Value(0)},
+		attribute.LengthRule("a", 2, 1),
+		{Key: "a", Flag: attribute.CONTAINSANY, LowerBound: attribute.StringSliceValue([]string{"x"})},
+		{Key: "a", Flag: attribute.REGEX, LowerBound: attribute.StringValue("^x+$")},
+	} {
+		assert.NoError(t, rule.Validate(), rule)
+	}
+	for _, rule := range []attribute.Rule{
+		attribute.EqualityRule("a", attribute.StringSliceValue([]string{"x"})),
+		attribute.RangeRule("a", attribute.IntValue(1), attribute.Float64Value(2)),
+		attribute.RangeRule("a", attribute.StringValue("a"), attribute.StringValue("b")),
+		{Key: "a", Flag: attribute.CONTAINSALL, LowerBound: attribute.StringValue("x")},
+		{Key: "a", Flag: attribute.REGEX, LowerBound: attribute.StringValue("(")},
+		{Key: "a", Flag: attribute.PREFIX, LowerBound: attribute.IntValue(1)},
+		{Key: "a", Flag: attribute.MatchValueFlag(100)},
+		{Key: "a", Flag: attribute.LENGTH, LowerBound: attribute.StringValue("1"), UpperBound: attribute.StringValue("2")},
+		{Key: "a", Flag: attribute.LENGTH, LowerBound: attribute.Float64Value(1), UpperBound: attribute.Float64Value(2)},
+		{Key: "a", Flag: attribute.LENGTH, LowerBound: attribute.Int64Value(1), UpperBound: attribute.Float64Value(2)},
+		{Key: "a", Flag: attribute.LENGTH, LowerBound: attribute.Int64Value(-1), UpperBound: attribute.Int64Value(2)},
+		{Key: "a", Flag: attribute.LENGTH, LowerBound: attribute.Int64Value(3), UpperBound: attribute.Int64Value(2)},
+		{Key: "a", Flag: attribute.LENGTH},
+	} {
+		assert.Error(t, rule.Validate(), rule)
+	}
+
+	// The Add methods skip the illegal matches.
+	f := attribute.NewMapTraceAttributeFilter()
+	f.AddRangeMatch("a", attribute.IntValue(1), attribute.Float64Value(2))
+	f.AddEqualityMatch("a", attribute.StringSliceValue([]string{"x"}))
+	f.AddLengthMatch("a", -1, 2)
+	assert.Empty(t, f.Rules())
+}
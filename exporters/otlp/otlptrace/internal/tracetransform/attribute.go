@@ -18,13 +18,10 @@ package tracetransform // import "go.opentelemetry.io/otel/exporters/otlp/otlptr
 
 import (
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/internal/global"
 	"go.opentelemetry.io/otel/sdk/resource"
 	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
 )
 
-const DEFAULT_INITIAL_FILTER_CAPACITY = 5
-
 // KeyValues transforms a slice of attribute KeyValues into OTLP key-values.
 func KeyValues(attrs []attribute.KeyValue) []*commonpb.KeyValue {
 	if len(attrs) == 0 {
@@ -38,26 +35,6 @@ func KeyValues(attrs []attribute.KeyValue) []*commonpb.KeyValue {
 	return out
 }
 
-// FilteredKeyValues transforms a slice of attribute KeyValues into OTLP key-values that match the global filter.
-func FilteredKeyValues(attrs []attribute.KeyValue) []*commonpb.KeyValue {
-	if len(attrs) == 0 {
-		return nil
-	}
-
-	if global.FilterConfigFlags()&global.AttributeFilter == 0 {
-		// don't to filter, let all traces go.
-		return KeyValues(attrs)
-	}
-	out := make([]*commonpb.KeyValue, 0, DEFAULT_INITIAL_FILTER_CAPACITY)
-
-	global.TraceAttributeFilter().BatchMatch(attrs,
-		func(attr attribute.KeyValue) error {
-			out = append(out, KeyValue(attr))
-			return nil
-		})
-	return out
-}
-
 // Iterator transforms an attribute iterator into OTLP key-values.
 func Iterator(iter attribute.Iterator) []*commonpb.KeyValue {
 	l := iter.Len()
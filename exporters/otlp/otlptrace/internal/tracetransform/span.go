@@ -17,35 +17,17 @@ package tracetransform // import "go.opentelemetry.io/otel/exporters/otlp/otlptr
 import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/internal/global"
 	"go.opentelemetry.io/otel/sdk/instrumentation"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
 )
 
-const DEFAULT_INITIAL_EVENT_CAPACITY = 5
-
-func filterSpan(sd tracesdk.ReadOnlySpan) bool {
-	// do structural, event, attribute matching here, if false, dropped
-	var matched = true
-	flg := global.FilterConfigFlags() // atomic load, in one filtering pass, don't change
-	if flg&global.AttributeNotMatchFullTraceFilter != 0 {
-		global.TraceAttributeFilter().BatchNotMatch(sd.Attributes(), func() error {
-			matched = false
-			return nil
-		})
-	}
-	if flg&global.StructuralTraceFilter != 0 {
-		//TODO: do structural matching here, if false, drop
-	}
-
-	return matched
-}
-
 // Spans transforms a slice of OpenTelemetry spans into a slice of OTLP
-// ResourceSpans.
+// ResourceSpans. The global query filter is applied to sdl first, see
+// tracesdk.FilterSpans.
 func Spans(sdl []tracesdk.ReadOnlySpan) []*tracepb.ResourceSpans {
+	sdl = tracesdk.FilterSpans(sdl)
 	if len(sdl) == 0 {
 		return nil
 	}
@@ -64,11 +46,6 @@ func Spans(sdl []tracesdk.ReadOnlySpan) []*tracepb.ResourceSpans {
 			continue
 		}
 
-		// do structural, event, attribute matching here, if false, dropped
-		if !filterSpan(sd) {
-			continue
-		}
-
 		rKey := sd.Resource().Equivalent()
 		k := key{
 			r:  rKey,
@@ -136,8 +113,8 @@ func span(sd tracesdk.ReadOnlySpan) *tracepb.Span {
 		Links:                  links(sd.Links()),
 		Kind:                   spanKind(sd.SpanKind()),
 		Name:                   sd.Name(),
-		Attributes:             FilteredKeyValues(sd.Attributes()),
-		Events:                 FilteredSpanEvents(sd.Events()),
+		Attributes:             KeyValues(sd.Attributes()),
+		Events:                 spanEvents(sd.Events()),
 		DroppedAttributesCount: uint32(sd.DroppedAttributes()),
 		DroppedEventsCount:     uint32(sd.DroppedEvents()),
 		DroppedLinksCount:      uint32(sd.DroppedLinks()),
@@ -211,26 +188,6 @@ func spanEvents(es []tracesdk.Event) []*tracepb.Span_Event {
 	return events
 }
 
-// FilteredSpanEvents let events with designated names pass through.
-func FilteredSpanEvents(es []tracesdk.Event) []*tracepb.Span_Event {
-	if len(es) == 0 {
-		return nil
-	}
-
-	out := make([]*tracepb.Span_Event, 0, DEFAULT_INITIAL_EVENT_CAPACITY)
-	for _, e := range es {
-		if global.TraceEventFilter().Match(attribute.Key(e.Name), attribute.InvalidValue()) {
-			out = append(out, &tracepb.Span_Event{
-				Name:                   e.Name,
-				TimeUnixNano:           uint64(e.Time.UnixNano()),
-				Attributes:             KeyValues(e.Attributes),
-				DroppedAttributesCount: uint32(e.DroppedAttributeCount),
-			})
-		}
-	}
-	return out
-}
-
 // spanKind transforms a SpanKind to an OTLP span kind.
 func spanKind(kind trace.SpanKind) tracepb.Span_SpanKind {
 	switch kind {
@@ -25,6 +25,7 @@ import (
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/internal/global"
 	"go.opentelemetry.io/otel/sdk/instrumentation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
@@ -325,3 +326,40 @@ func TestRootSpanData(t *testing.T) {
 func TestSpanDataNilResource(t *testing.T) {
 	assert.NotPanics(t, func() { Spans(tracetest.SpanStubs{{}}.Snapshots()) })
 }
+
+func TestSpansQueryFilter(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.ClearScopedTraceAttributeFilters()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	app1 := global.ScopedTraceAttributeFilter("app1")
+	app1.AddKeyMatch("a")
+	app1.SetCondition(attribute.Equal("b", attribute.IntValue(1)))
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+
+	stub := func(name string, attrs ...attribute.KeyValue) tracetest.SpanStub {
+		return tracetest.SpanStub{
+			Name:       name,
+			Attributes: attrs,
+			Resource:   resource.NewSchemaless(semconv.ServiceName("app1")),
+		}
+	}
+	rss := Spans(tracetest.SpanStubs{
+		stub("matched", attribute.Int("a", 0), attribute.Int("b", 1)),
+		stub("dropped", attribute.Int("a", 0), attribute.Int("b", 2)),
+	}.Snapshots())
+
+	got := make(map[string][]string)
+	for _, rs := range rss {
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				for _, kv := range s.Attributes {
+					got[s.Name] = append(got[s.Name], kv.Key)
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string][]string{"matched": {"a"}}, got)
+}
@@ -2,6 +2,8 @@
 
 package otel // import "go.opentelemetry.io/otel"
 import (
+	"net/http"
+
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/internal/global"
 )
@@ -13,24 +15,172 @@ func GetTraceAttributeFilter() attribute.TraceAttributeFilter {
 	return global.TraceAttributeFilter()
 }
 
-// SetTraceAttributeFilter sets the global TraceAttributeFilter.
-// TODO: there's no effect of this function.
+// SetTraceAttributeFilter does nothing: the global TraceAttributeFilter
+// cannot be replaced, since the query filters publish its rules as they
+// change.
+//
+// Deprecated: Change the rules of the filter returned by
+// GetTraceAttributeFilter instead.
 func SetTraceAttributeFilter(f attribute.TraceAttributeFilter) {
 	global.SetTraceAttributeFilter(f)
 }
 
+// GetTraceEventFilter returns the global TraceEventFilter. Its key matches
+// are the names of the span events exported when the EventFilter is enabled,
+// see WithEventFilter, a key match on attribute.WildcardKey keeping all the
+// events.
+func GetTraceEventFilter() attribute.TraceAttributeFilter {
+	return global.TraceEventFilter()
+}
+
+// GetTraceEventAttributeFilter returns the TraceAttributeFilter projecting
+// the attributes of the span events named name, creating it if needed, when
+// the EventAttributeFilter is enabled, see WithEventAttributeFilter. The
+// filter of the attribute.WildcardKey name applies to the events without a
+// filter of their own, the events without any filter keep no attribute.
+func GetTraceEventAttributeFilter(name string) attribute.TraceAttributeFilter {
+	return global.TraceEventAttributeFilter(name)
+}
+
+// ClearTraceEventAttributeFilters removes all the filters of the attributes
+// of the span events.
+func ClearTraceEventAttributeFilters() {
+	global.ClearTraceEventAttributeFilters()
+}
+
+// GetTraceLinkAttributeFilter returns the TraceAttributeFilter projecting the
+// attributes of the span links when the LinkAttributeFilter is enabled, see
+// WithLinkAttributeFilter.
+func GetTraceLinkAttributeFilter() attribute.TraceAttributeFilter {
+	return global.TraceLinkAttributeFilter()
+}
+
+// GetScopedTraceAttributeFilter returns the TraceAttributeFilter scoped to
+// table, creating it if needed. It replaces the global TraceAttributeFilter
+// for the spans of the service (resource attribute service.name), or else of
+// the instrumentation scope, named table.
+func GetScopedTraceAttributeFilter(table string) attribute.TraceAttributeFilter {
+	return global.ScopedTraceAttributeFilter(table)
+}
+
+// ClearScopedTraceAttributeFilters removes all the scoped
+// TraceAttributeFilters.
+func ClearScopedTraceAttributeFilters() {
+	global.ClearScopedTraceAttributeFilters()
+}
+
+// FilterControlHandler returns an http.Handler to list and change the rules
+// of the global and the scoped TraceAttributeFilters at run time. GET lists
+// the rules, PUT replaces them, PATCH adds to them and DELETE removes them,
+// the table query parameter selecting a scoped filter. The bodies have the
+// form of the update requests of HandleRequest, e.g.
+//
+//	{"filters": [{"key": "http.method", "type": "string", "values": ["GET"]}]}
+//
+// The query parameter selects a named query instead: GET lists the named
+// queries, PUT installs a query in the JSON form of the "query" operation of
+// HandleRequest under that name, bounded by the start, ttl and max_spans
+// query parameters, and DELETE removes it.
+//
+// Responses carry the version of the rules in their ETag header, a change
+// with an If-Match header not matching the current version fails with 412
+// Precondition Failed.
+func FilterControlHandler() http.Handler {
+	return global.FilterControlHandler()
+}
+
+// EventFilterControlHandler returns an http.Handler to list and change the
+// event names of the TraceEventFilter at run time, with the same methods and
+// bodies as the FilterControlHandler. The event query parameter selects the
+// filter of the attributes of the events with that name instead, and the
+// links query parameter the filter of the attributes of the links.
+func EventFilterControlHandler() http.Handler {
+	return global.EventFilterControlHandler()
+}
+
+// WithAttributeFilter enables the projection of the attributes of the spans
+// by their TraceAttributeFilter, see GetTraceAttributeFilter and
+// GetScopedTraceAttributeFilter. Without it, all the attributes are exported.
 func WithAttributeFilter() global.FilterConfigFlag {
 	return global.AttributeFilter
 }
 
+// WithAttributeNotMatchFullTraceFilter enables the dropping of the spans not
+// satisfying the condition of their TraceAttributeFilter. The condition is
+// evaluated span by span, use NewTraceBufferSpanProcessor of
+// go.opentelemetry.io/otel/sdk/trace to keep or drop whole traces.
 func WithAttributeNotMatchFullTraceFilter() global.FilterConfigFlag {
 	return global.AttributeNotMatchFullTraceFilter
 }
 
+// WithStructuralTraceFilter enables the dropping of the spans not taking part
+// in a call chain matching one of the structural patterns, see
+// SetTraceStructuralPatterns. The chains spanning several services are
+// carried by the QueryPropagator of go.opentelemetry.io/otel/sdk/trace.
 func WithStructuralTraceFilter() global.FilterConfigFlag {
 	return global.StructuralTraceFilter
 }
 
+// WithEventFilter enables the filtering of the span events by the
+// TraceEventFilter, see GetTraceEventFilter. Without it, all the events are
+// exported.
+func WithEventFilter() global.FilterConfigFlag {
+	return global.EventFilter
+}
+
+// WithEventAttributeFilter enables the projection of the attributes of the
+// span events, see GetTraceEventAttributeFilter.
+func WithEventAttributeFilter() global.FilterConfigFlag {
+	return global.EventAttributeFilter
+}
+
+// WithLinkAttributeFilter enables the projection of the attributes of the
+// span links, see GetTraceLinkAttributeFilter.
+func WithLinkAttributeFilter() global.FilterConfigFlag {
+	return global.LinkAttributeFilter
+}
+
+// WithPropagatedSelectionFilter enables the selection of the spans by the
+// upstream services: the spans of a request selected by the active query
+// upstream are kept, and the ones of a request it did not select are
+// dropped. The selection is carried by the QueryPropagator of
+// go.opentelemetry.io/otel/sdk/trace.
+func WithPropagatedSelectionFilter() global.FilterConfigFlag {
+	return global.PropagatedSelectionFilter
+}
+
+// GetQueryID returns the identity of the active query, empty if none is set.
+func GetQueryID() string {
+	return global.QueryID()
+}
+
+// SetQueryID sets the identity of the active query. The services installing
+// the same query must use the same identity for their selections to be
+// shared, e.g. the ID of a parsed query.
+func SetQueryID(id string) {
+	global.SetQueryID(id)
+}
+
+// GetTraceStructuralPatterns returns the caller->callee service chains used
+// when the StructuralTraceFilter is enabled.
+func GetTraceStructuralPatterns() [][]string {
+	return global.TraceStructuralPatterns()
+}
+
+// SetTraceStructuralPatterns sets the caller->callee service chains used when
+// the StructuralTraceFilter is enabled. Each pattern lists service names
+// (resource attribute service.name) in call order, e.g. {"app1", "app2",
+// "app3"} for app1 -> app2 -> app3. A span is exported only if it takes part
+// in a call chain matching one of the patterns. Setting no patterns disables
+// the structural matching.
+func SetTraceStructuralPatterns(patterns ...[]string) {
+	global.SetTraceStructuralPatterns(patterns)
+}
+
+// SetAttributeFilterConfig enables the query filters of flags, disabling the
+// others. They apply to the spans passed through FilterSpans of
+// go.opentelemetry.io/otel/sdk/trace: the ones of the OTLP trace exporter and
+// of any exporter wrapped with NewQueryFilterExporter of that package.
 func SetAttributeFilterConfig(flags ...global.FilterConfigFlag) {
 	var flag global.FilterConfigFlag = 0
 	for _, f := range flags {
@@ -0,0 +1,40 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attribute // import "go.opentelemetry.io/otel/internal/attribute"
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// errorHandler handles the errors of the attribute filters, it holds a
+// func(error).
+var errorHandler atomic.Value
+
+// SetErrorHandler sets the handler of the errors of the attribute filters,
+// e.g. the global ErrorHandler.
+func SetErrorHandler(h func(error)) {
+	errorHandler.Store(h)
+}
+
+// HandleError passes err to the handler set with SetErrorHandler, it is
+// logged if none is set.
+func HandleError(err error) {
+	if h, ok := errorHandler.Load().(func(error)); ok {
+		h(err)
+		return
+	}
+	log.Print(err)
+}
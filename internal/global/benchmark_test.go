@@ -16,7 +16,11 @@ package global
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func BenchmarkStartEndSpanNoSDK(b *testing.B) {
@@ -31,3 +35,122 @@ func BenchmarkStartEndSpanNoSDK(b *testing.B) {
 		span.End()
 	}
 }
+
+// rwMutexTraceAttributeFilter is the previous traceAttributeFilter, reading
+// and changing a single filter under a read-write lock, the baseline of the
+// benchmarks of the published filter.
+type rwMutexTraceAttributeFilter struct {
+	rwx sync.RWMutex
+	taf attribute.TraceAttributeFilter
+}
+
+func (t *rwMutexTraceAttributeFilter) AddEqualityMatch(key attribute.Key, value attribute.Value) {
+	t.rwx.Lock()
+	defer t.rwx.Unlock()
+	t.taf.AddEqualityMatch(key, value)
+}
+
+func (t *rwMutexTraceAttributeFilter) Match(key attribute.Key, value attribute.Value) bool {
+	t.rwx.RLock()
+	defer t.rwx.RUnlock()
+	return t.taf.Match(key, value)
+}
+
+func (t *rwMutexTraceAttributeFilter) BatchNotMatch(attrs []attribute.KeyValue, callback func() error) {
+	t.rwx.RLock()
+	defer t.rwx.RUnlock()
+	t.taf.BatchNotMatch(attrs, callback)
+}
+
+// benchmarkFilter is the part of a TraceAttributeFilter the benchmarks use.
+type benchmarkFilter interface {
+	AddEqualityMatch(key attribute.Key, value attribute.Value)
+	Match(key attribute.Key, value attribute.Value) bool
+	BatchNotMatch(attrs []attribute.KeyValue, callback func() error)
+}
+
+// benchmarkFilters returns the filters to compare, with the same rules.
+func benchmarkFilters() map[string]func() benchmarkFilter {
+	setup := func(f benchmarkFilter) benchmarkFilter {
+		for i := 0; i < 8; i++ {
+			f.AddEqualityMatch(attribute.Key(fmt.Sprintf("key%d", i)), attribute.IntValue(i))
+		}
+		return f
+	}
+	return map[string]func() benchmarkFilter{
+		"RWMutex": func() benchmarkFilter {
+			return setup(&rwMutexTraceAttributeFilter{taf: attribute.NewMapTraceAttributeFilter()})
+		},
+		"CopyOnWrite": func() benchmarkFilter {
+			return setup(newFilter(attribute.NewMapTraceAttributeFilter()))
+		},
+	}
+}
+
+func BenchmarkTraceAttributeFilterMatch(b *testing.B) {
+	attrs := make([]attribute.KeyValue, 8)
+	for i := range attrs {
+		attrs[i] = attribute.Int(fmt.Sprintf("key%d", i), i)
+	}
+	for name, newFilter := range benchmarkFilters() {
+		for _, writes := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/writes=%t", name, writes), func(b *testing.B) {
+				f := newFilter()
+				done := make(chan struct{})
+				var wg sync.WaitGroup
+				if writes {
+					// A writer changing the rules while the spans are
+					// filtered, as a control request does.
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; ; i++ {
+							select {
+							case <-done:
+								return
+							default:
+								f.AddEqualityMatch("key0", attribute.IntValue(i%8))
+							}
+						}
+					}()
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						for _, kv := range attrs {
+							f.Match(kv.Key, kv.Value)
+						}
+						f.BatchNotMatch(attrs, func() error { return nil })
+					}
+				})
+				b.StopTimer()
+				close(done)
+				wg.Wait()
+			})
+		}
+	}
+}
+
+func BenchmarkCurrentFilterState(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			st := CurrentFilterState()
+			_ = st.FilterFor("app1", "lib")
+		}
+	})
+}
+
+func BenchmarkBatchedEqualityMatches(b *testing.B) {
+	f := newTraceAttributeFilter()
+	for i := 0; i < b.N; i++ {
+		_ = batchFilterState(func() error {
+			f.Clear()
+			for j := 0; j < 1000; j++ {
+				f.AddEqualityMatch("tenant", attribute.IntValue(j))
+			}
+			return nil
+		})
+	}
+}
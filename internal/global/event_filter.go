@@ -0,0 +1,65 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global // import "go.opentelemetry.io/otel/internal/global"
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// globalEventAttributeFilters holds the TraceAttributeFilters projecting the
+// attributes of the span events, by event name.
+var globalEventAttributeFilters = newScopedTraceAttributeFilters()
+
+// TraceEventAttributeFilter returns the TraceAttributeFilter projecting the
+// attributes of the span events named name, creating an empty one if it does
+// not exist. The filter of the attribute.WildcardKey name applies to the
+// events without a filter of their own.
+func TraceEventAttributeFilter(name string) attribute.TraceAttributeFilter {
+	return globalEventAttributeFilters.get(name)
+}
+
+// TraceEventAttributeFilterNames returns the sorted event names having a
+// TraceEventAttributeFilter.
+func TraceEventAttributeFilterNames() []string {
+	return globalEventAttributeFilters.names()
+}
+
+// RemoveTraceEventAttributeFilter removes the TraceEventAttributeFilter of
+// the events named name.
+func RemoveTraceEventAttributeFilter(name string) {
+	globalEventAttributeFilters.remove(name)
+}
+
+// ClearTraceEventAttributeFilters removes all the
+// TraceEventAttributeFilters.
+func ClearTraceEventAttributeFilters() {
+	globalEventAttributeFilters.clear()
+}
+
+// TraceEventAttributeFilterFor returns the TraceAttributeFilter projecting
+// the attributes of the span events named name: the filter of name if any,
+// else the one of the attribute.WildcardKey name if any. It returns false if
+// there is none, the events then keep no attribute.
+func TraceEventAttributeFilterFor(name string) (attribute.TraceAttributeFilter, bool) {
+	filters := globalEventAttributeFilters.all()
+	f, ok := filters[name]
+	if !ok {
+		f, ok = filters[string(attribute.WildcardKey)]
+	}
+	if !ok {
+		return nil, false
+	}
+	return f, true
+}
@@ -2,19 +2,34 @@
 
 package global // import "go.opentelemetry.io/otel/internal/global"
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"go.opentelemetry.io/otel/attribute"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type updateFilterRequests struct {
-	Filters []struct {
-		Key    attribute.Key `json:"key"`
-		Type   string        `json:"type"`
-		Values []any         `json:"values"`
-	} `json:"filters"`
+	Filters []filterRequest `json:"filters"`
+	// Where is the condition spans must satisfy, it is left unchanged if
+	// not set.
+	Where *attribute.Condition `json:"where"`
+}
+
+// filterRequest is a filter of an update request.
+type filterRequest struct {
+	Key  attribute.Key `json:"key"`
+	Type string        `json:"type"`
+	// Op is attribute.FilterOpIn or attribute.FilterOpRange, see
+	// attribute.FilterRules for the default.
+	Op     string `json:"op,omitempty"`
+	Values []any  `json:"values"`
 }
 
 type removeFilterRequests struct {
@@ -22,154 +37,584 @@ type removeFilterRequests struct {
 }
 
 // traceAttributeFilter is a default (currently unique) TraceAttributeFilter
-// that maintains a Read-Write lock to protect the filter from concurrent
-// access, while maintaining efficiency for read-only access (which is much
-// more frequent in production).
+// that publishes an immutable copy of its rules, replaced on every change, so
+// that the reads (which are much more frequent in production) never wait on
+// the writes nor on each other, and a read sees the rules of a single
+// version of the filter.
 // This object is written to follow OpenTelemetry's design pattern for global
 // states.
+//
+// The changes made within a batched change of the configuration, see
+// batchFilterState, are made to a draft of the filter published once the
+// batch ends, rather than to a copy of the filter each, so that building
+// many rules one at a time stays linear.
 type traceAttributeFilter struct {
-	rwx sync.RWMutex
-	taf attribute.TraceAttributeFilter
+	// mu serializes the changes of the filter.
+	mu sync.Mutex
+	// current holds the published filter, it must not be changed.
+	current atomic.Pointer[attribute.TraceAttributeFilter]
+	// draft is the filter being changed within a batch, nil if there is
+	// none. It is guarded by mu.
+	draft attribute.TraceAttributeFilter
+	// routes is set on the global TraceAttributeFilter, which also handles
+	// the requests on the scoped filters.
+	routes bool
+}
+
+// queryFilterRequests are the update requests of the scoped filters of the
+// tables of a query.
+type queryFilterRequests map[string]queryTableRequests
+
+// queryTableRequests are the update requests of the scoped filter of a table
+// of a query, and the names of the span events the table selects.
+type queryTableRequests struct {
+	updateFilterRequests
+	Events []string `json:"events,omitempty"`
 }
 
 var _ attribute.TraceAttributeFilter = (*traceAttributeFilter)(nil)
 
+// configVersion is incremented on every change of the global or the scoped
+// TraceAttributeFilters, it versions the rules served by the
+// FilterControlHandler.
+var configVersion atomic.Uint64
+
 func newTraceEventFilter() *traceAttributeFilter {
-	return &traceAttributeFilter{
-		taf: attribute.NewMapTraceAttributeFilter(),
-	}
+	return newFilter(attribute.NewMapTraceAttributeFilter())
+}
+
+func newTraceLinkFilter() *traceAttributeFilter {
+	return newFilter(attribute.NewMapTraceAttributeFilter())
 }
 
 func newTraceAttributeFilter() *traceAttributeFilter {
-	return &traceAttributeFilter{
-		taf: attribute.NewMapTraceAttributeFilter(),
+	t := newFilter(attribute.NewMapTraceAttributeFilter())
+	t.routes = true
+	return t
+}
+
+// newFilter returns a traceAttributeFilter publishing taf, which must not be
+// changed afterwards.
+func newFilter(taf attribute.TraceAttributeFilter) *traceAttributeFilter {
+	t := &traceAttributeFilter{}
+	t.current.Store(&taf)
+	return t
+}
+
+// filter returns the published filter, it must not be changed.
+func (t *traceAttributeFilter) filter() attribute.TraceAttributeFilter {
+	return *t.current.Load()
+}
+
+// update applies change to a copy of the filter, publishes the copy and
+// returns the error of change. Within a batch, the copy is the draft of the
+// filter, published when the batch ends.
+func (t *traceAttributeFilter) update(change func(attribute.TraceAttributeFilter) error) error {
+	t.mu.Lock()
+	if t.draft == nil {
+		t.draft = attribute.NewMapTraceAttributeFilter()
+		t.draft.Restore(t.filter().Snapshot())
 	}
+	err := change(t.draft)
+	configVersion.Add(1)
+	batched := deferFilterPublication(t)
+	if !batched {
+		t.publishDraft()
+	}
+	t.mu.Unlock()
+	if !batched {
+		publishFilterState()
+	}
+	return err
+}
+
+// publishDraft publishes the draft of the filter, if any. t.mu must be held.
+func (t *traceAttributeFilter) publishDraft() {
+	if t.draft == nil {
+		return
+	}
+	next := t.draft
+	t.current.Store(&next)
+	t.draft = nil
+}
+
+// flush publishes the draft of the filter left by a batch, if any.
+func (t *traceAttributeFilter) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.publishDraft()
+}
+
+// latest returns the draft of the filter if there is one, the published
+// filter otherwise, so that a batch sees its own changes. t.mu must be held.
+func (t *traceAttributeFilter) latest() attribute.TraceAttributeFilter {
+	if t.draft != nil {
+		return t.draft
+	}
+	return t.filter()
 }
 
+// AddRangeMatch adds a range match, an illegal one is reported to the
+// ErrorHandler and skipped.
 func (t *traceAttributeFilter) AddRangeMatch(key attribute.Key, lb attribute.Value, ub attribute.Value) {
-	t.rwx.Lock()
-	defer t.rwx.Unlock()
-	t.taf.AddRangeMatch(key, lb, ub)
+	if err := attribute.RangeRule(key, lb, ub).Validate(); err != nil {
+		Handle(fmt.Errorf("trace attribute filter: range match on %q: %w", key, err))
+		return
+	}
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.AddRangeMatch(key, lb, ub)
+		return nil
+	})
 }
 
+// AddEqualityMatch adds an equality match, an illegal one is reported to the
+// ErrorHandler and skipped.
 func (t *traceAttributeFilter) AddEqualityMatch(key attribute.Key, value attribute.Value) {
-	t.rwx.Lock()
-	defer t.rwx.Unlock()
-	t.taf.AddEqualityMatch(key, value)
+	if err := attribute.EqualityRule(key, value).Validate(); err != nil {
+		Handle(fmt.Errorf("trace attribute filter: equality match on %q: %w", key, err))
+		return
+	}
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.AddEqualityMatch(key, value)
+		return nil
+	})
 }
 
 func (t *traceAttributeFilter) AddKeyMatch(key attribute.Key) {
-	t.rwx.Lock()
-	defer t.rwx.Unlock()
-	t.taf.AddKeyMatch(key)
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.AddKeyMatch(key)
+		return nil
+	})
+}
+
+func (t *traceAttributeFilter) AddPatternMatch(key attribute.Key, flag attribute.MatchValueFlag, pattern string) error {
+	return t.update(func(f attribute.TraceAttributeFilter) error {
+		return f.AddPatternMatch(key, flag, pattern)
+	})
+}
+
+func (t *traceAttributeFilter) AddContainsMatch(key attribute.Key, flag attribute.MatchValueFlag, values ...attribute.Value) error {
+	return t.update(func(f attribute.TraceAttributeFilter) error {
+		return f.AddContainsMatch(key, flag, values...)
+	})
+}
+
+// AddLengthMatch adds a length match, an illegal one is reported to the
+// ErrorHandler and skipped.
+func (t *traceAttributeFilter) AddLengthMatch(key attribute.Key, lb, ub int64) {
+	if err := attribute.LengthRule(key, lb, ub).Validate(); err != nil {
+		Handle(fmt.Errorf("trace attribute filter: length match on %q: %w", key, err))
+		return
+	}
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.AddLengthMatch(key, lb, ub)
+		return nil
+	})
 }
 
 func (t *traceAttributeFilter) RemoveMatch(key attribute.Key) {
-	t.rwx.Lock()
-	defer t.rwx.Unlock()
-	t.taf.RemoveMatch(key)
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.RemoveMatch(key)
+		return nil
+	})
+}
+
+func (t *traceAttributeFilter) SetCondition(cond attribute.Condition) {
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.SetCondition(cond)
+		return nil
+	})
+}
+
+// Rules returns the rules of the filter, including the changes of a batch in
+// progress.
+func (t *traceAttributeFilter) Rules() []attribute.Rule {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latest().Rules()
+}
+
+// Snapshot returns a copy of the rules and the condition of the filter,
+// including the changes of a batch in progress.
+func (t *traceAttributeFilter) Snapshot() attribute.Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latest().Snapshot()
+}
+
+func (t *traceAttributeFilter) Restore(s attribute.Snapshot) {
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.Restore(s)
+		return nil
+	})
 }
 
 func (t *traceAttributeFilter) Match(key attribute.Key, value attribute.Value) bool {
-	t.rwx.RLock()
-	defer t.rwx.RUnlock()
-	return t.taf.Match(key, value)
+	return t.filter().Match(key, value)
 }
 
 func (t *traceAttributeFilter) BatchMatch(attrs []attribute.KeyValue, callback func(attribute.KeyValue) error) {
-	t.rwx.RLock()
-	defer t.rwx.RUnlock()
-	t.taf.BatchMatch(attrs, callback)
+	t.filter().BatchMatch(attrs, callback)
 }
 
 func (t *traceAttributeFilter) BatchNotMatch(attrs []attribute.KeyValue, callback func() error) {
-	t.rwx.RLock()
-	defer t.rwx.RUnlock()
-	t.taf.BatchNotMatch(attrs, callback)
+	t.filter().BatchNotMatch(attrs, callback)
+}
+
+func (t *traceAttributeFilter) BatchMayMatch(attrs []attribute.KeyValue) bool {
+	return t.filter().BatchMayMatch(attrs)
 }
 
 func (t *traceAttributeFilter) updateFilter(ufrs updateFilterRequests) error {
-	t.rwx.Lock()
-	defer t.rwx.Unlock()
-	for _, filter := range ufrs.Filters {
-		if len(filter.Values) == 0 {
-			t.taf.AddKeyMatch(filter.Key)
-		} else {
-			switch filter.Type {
-			case "string": // for string type filter, only the first value is used
-				t.taf.AddEqualityMatch(filter.Key, attribute.StringValue(filter.Values[0].(string)))
-			case "bool": // for bool type filter, only the first value is used
-				t.taf.AddEqualityMatch(filter.Key, attribute.BoolValue(filter.Values[0].(bool)))
-			case "int64":
-				// for int64 type filter, depending on the number of values, either equality or range match is used
-				if len(filter.Values) == 1 {
-					t.taf.AddEqualityMatch(filter.Key,
-						attribute.Int64Value(int64(filter.Values[0].(float64))))
-				} else {
-					t.taf.AddRangeMatch(filter.Key,
-						attribute.Int64Value(int64(filter.Values[0].(float64))),
-						attribute.Int64Value(int64(filter.Values[1].(float64))))
-				}
-			case "float64":
-				// for float64 type filter, depending on the number of values, either equality or range match is used
-				if len(filter.Values) == 1 {
-					t.taf.AddEqualityMatch(filter.Key,
-						attribute.Float64Value(filter.Values[0].(float64)))
-				} else {
-					t.taf.AddRangeMatch(filter.Key,
-						attribute.Float64Value(filter.Values[0].(float64)),
-						attribute.Float64Value(filter.Values[1].(float64)))
-				}
-			default:
-				return errors.New("Unsupported type: " + filter.Type)
-			}
+	// Validate the whole request first, not to apply it partially.
+	rules, err := ufrs.validate()
+	if err != nil {
+		return err
+	}
+	return t.update(func(f attribute.TraceAttributeFilter) error {
+		ufrs.apply(f, rules)
+		return nil
+	})
+}
+
+// replaceFilter replaces all the matches and the condition of the filter by
+// the ones of ufrs.
+func (t *traceAttributeFilter) replaceFilter(ufrs updateFilterRequests) error {
+	rules, err := ufrs.validate()
+	if err != nil {
+		return err
+	}
+	scratch := attribute.NewMapTraceAttributeFilter()
+	ufrs.apply(scratch, rules)
+	t.Restore(scratch.Snapshot())
+	return nil
+}
+
+// validate returns the rules of the filters of ufrs, or an
+// *attribute.FilterRequestError listing all its invalid filters.
+func (ufrs updateFilterRequests) validate() ([]attribute.Rule, error) {
+	rules, errs := ufrs.rules("")
+	if len(errs) > 0 {
+		return nil, &attribute.FilterRequestError{Rules: errs}
+	}
+	return rules, nil
+}
+
+// rules returns the rules of the valid filters of ufrs and the errors of the
+// invalid ones, table is the table of ufrs in a query request.
+func (ufrs updateFilterRequests) rules(table string) ([]attribute.Rule, []attribute.RuleError) {
+	var rules []attribute.Rule
+	var errs []attribute.RuleError
+	for i, filter := range ufrs.Filters {
+		rs, err := filter.rules()
+		if err != nil {
+			errs = append(errs, attribute.RuleError{Table: table, Index: i, Key: filter.Key, Err: err})
+			continue
+		}
+		rules = append(rules, rs...)
+	}
+	return rules, errs
+}
+
+// apply sets the condition of ufrs and adds rules, the rules of its filters,
+// to taf.
+func (ufrs updateFilterRequests) apply(taf attribute.TraceAttributeFilter, rules []attribute.Rule) {
+	if ufrs.Where != nil {
+		taf.SetCondition(*ufrs.Where)
+	}
+	for _, rule := range rules {
+		rule.AddTo(taf)
+	}
+}
+
+// rules returns the rules of the filter, or the reason it is invalid.
+func (filter filterRequest) rules() ([]attribute.Rule, error) {
+	if filter.Key == "" {
+		return nil, errors.New("empty key")
+	}
+	values := make([]attribute.Value, len(filter.Values))
+	for i, v := range filter.Values {
+		value, err := filterValue(filter.Type, v)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	rules, err := attribute.FilterRules(filter.Key, filter.Op, values...)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// filterValue returns the attribute value of the JSON value v of a filter of
+// type typ.
+func filterValue(typ string, v any) (attribute.Value, error) {
+	var ok bool
+	var value attribute.Value
+	switch typ {
+	case "string":
+		var s string
+		s, ok = v.(string)
+		value = attribute.StringValue(s)
+	case "bool":
+		var b bool
+		b, ok = v.(bool)
+		value = attribute.BoolValue(b)
+	case "int64":
+		var f float64
+		f, ok = v.(float64)
+		if ok && (f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64) {
+			return attribute.Value{}, fmt.Errorf("%v is not an int64", v)
 		}
+		value = attribute.Int64Value(int64(f))
+	case "float64":
+		var f float64
+		f, ok = v.(float64)
+		value = attribute.Float64Value(f)
+	default:
+		return attribute.Value{}, fmt.Errorf("unsupported type %q", typ)
+	}
+	if !ok {
+		return attribute.Value{}, fmt.Errorf("%v is not a %s", v, typ)
+	}
+	return value, nil
+}
+
+// validate returns an *attribute.FilterRequestError listing the empty keys
+// of rfrs, nil if there are none.
+func (rfrs removeFilterRequests) validate() error {
+	var errs []attribute.RuleError
+	for i, key := range rfrs.Filters {
+		if key == "" {
+			errs = append(errs, attribute.RuleError{Index: i, Key: key, Err: errors.New("empty key")})
+		}
+	}
+	if len(errs) > 0 {
+		return &attribute.FilterRequestError{Rules: errs}
 	}
 	return nil
 }
 
 func (t *traceAttributeFilter) removeFilter(rfrs removeFilterRequests) error {
-	t.rwx.Lock()
-	defer t.rwx.Unlock()
-	for _, filter := range rfrs.Filters {
-		t.taf.RemoveMatch(filter)
+	if err := rfrs.validate(); err != nil {
+		return err
 	}
-	return nil
+	return t.update(func(f attribute.TraceAttributeFilter) error {
+		for _, filter := range rfrs.Filters {
+			f.RemoveMatch(filter)
+		}
+		return nil
+	})
 }
 
 func (t *traceAttributeFilter) Clear() {
-	t.rwx.Lock()
-	defer t.rwx.Unlock()
-	t.taf.Clear()
+	_ = t.update(func(f attribute.TraceAttributeFilter) error {
+		f.Clear()
+		return nil
+	})
+}
+
+// MarshalJSON returns the JSON form of the Snapshot of the filter.
+func (t *traceAttributeFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Snapshot())
+}
+
+// install replaces the scoped TraceAttributeFilters by the ones of the tables
+// of qfrs, and the names of the TraceEventFilter by the events they select.
+// Nothing is changed if any table of qfrs is invalid, see validate.
+func (qfrs queryFilterRequests) install() error {
+	if err := qfrs.validate(); err != nil {
+		return err
+	}
+	ClearScopedTraceAttributeFilters()
+	events := TraceEventFilter()
+	events.Clear()
+	for table, qtrs := range qfrs {
+		if err := globalScopedFilters.get(table).updateFilter(qtrs.updateFilterRequests); err != nil {
+			return err
+		}
+		for _, name := range qtrs.Events {
+			events.AddKeyMatch(attribute.Key(name))
+		}
+	}
+	return nil
+}
+
+// validate returns an *attribute.FilterRequestError listing the invalid
+// filters of all the tables of qfrs, nil if there are none.
+func (qfrs queryFilterRequests) validate() error {
+	tables := make([]string, 0, len(qfrs))
+	for table := range qfrs {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	var errs []attribute.RuleError
+	for _, table := range tables {
+		_, tableErrs := qfrs[table].rules(table)
+		errs = append(errs, tableErrs...)
+	}
+	if len(errs) > 0 {
+		return &attribute.FilterRequestError{Rules: errs}
+	}
+	return nil
+}
+
+// flags returns the filter flags the tables of qfrs need to be enforced: the
+// AttributeFilter if a table selects attributes and the EventFilter if a
+// table selects events.
+func (qfrs queryFilterRequests) flags() FilterConfigFlag {
+	var flag FilterConfigFlag
+	for _, qtrs := range qfrs {
+		if len(qtrs.Filters) > 0 {
+			flag |= AttributeFilter
+		}
+		if len(qtrs.Events) > 0 {
+			flag |= EventFilter
+		}
+	}
+	return flag
+}
+
+// namedQuery returns the named query called name of the tables of qfrs,
+// which must be valid, see validate. As for a query installed by
+// InstallQueryJSON, the spans of the services outside of the tables are not
+// selected.
+func (qfrs queryFilterRequests) namedQuery(name string) *NamedQuery {
+	q := &NamedQuery{
+		Name:   name,
+		Flags:  AttributeNotMatchFullTraceFilter | qfrs.flags(),
+		Filter: attribute.NewMapTraceAttributeFilter(),
+		Scoped: make(map[string]attribute.TraceAttributeFilter, len(qfrs)),
+		Events: attribute.NewMapTraceAttributeFilter(),
+	}
+	q.Filter.SetCondition(attribute.Or())
+	for table, qtrs := range qfrs {
+		rules, _ := qtrs.rules(table)
+		f := attribute.NewMapTraceAttributeFilter()
+		qtrs.apply(f, rules)
+		q.Scoped[table] = f
+		for _, event := range qtrs.Events {
+			q.Events.AddKeyMatch(attribute.Key(event))
+		}
+	}
+	return q
+}
+
+// InstallQueryJSON installs data, the JSON form of a query handled by the
+// "query" operation of HandleRequest, with InstallQuery: the scoped
+// TraceAttributeFilters and the TraceEventFilter are replaced by the ones of
+// data, then complete is called with the filter flags data needs to complete
+// the installation, and the configuration is published once installed.
+// Nothing is changed if data is invalid, see HandleRequest.
+func InstallQueryJSON(data []byte, complete func(FilterConfigFlag)) error {
+	var qfrs queryFilterRequests
+	if err := decodeBody(bytes.NewReader(data), &qfrs); err != nil {
+		return err
+	}
+	if err := qfrs.validate(); err != nil {
+		return err
+	}
+	return InstallQuery(QueryBounds{}, func() error {
+		if err := qfrs.install(); err != nil {
+			return err
+		}
+		complete(qfrs.flags())
+		return nil
+	})
 }
 
+// InstallNamedQueryJSON installs data, the JSON form of a query handled by
+// the "query" operation of HandleRequest, as the named query called name,
+// see InstallNamedQuery. Nothing is changed if data is invalid.
+func InstallNamedQueryJSON(name string, data []byte, bounds QueryBounds) error {
+	if name == "" {
+		return errors.New("empty query name")
+	}
+	var qfrs queryFilterRequests
+	if err := decodeBody(bytes.NewReader(data), &qfrs); err != nil {
+		return err
+	}
+	if err := qfrs.validate(); err != nil {
+		return err
+	}
+	return InstallNamedQuery(qfrs.namedQuery(name), bounds)
+}
+
+// HandleRequest executes the filter operation of r. The global filter also
+// handles the requests on the scoped filter of a table, given by the table
+// parameter, and the "query" operation replacing all the scoped filters.
+//
+// The body of r is validated before anything is changed: unknown fields are
+// rejected, and a request with invalid filters fails with an
+// *attribute.FilterRequestError listing all of them.
+//
+// The update and query operations accept the bounds of their change, see
+// QueryBounds, in the start (an RFC 3339 time), ttl (a duration such as 10m)
+// and max_spans parameters, e.g. op=update&ttl=10m&max_spans=1000.
 func (t *traceAttributeFilter) HandleRequest(r *http.Request) error {
-	reqOp := r.URL.Query().Get("op")
-	println("opCode: " + reqOp)
+	controlMu.Lock()
+	defer controlMu.Unlock()
+
+	params := r.URL.Query()
+	reqOp := params.Get("op")
+	Debug("handling trace filter request", "op", reqOp)
+	bounds, err := requestBounds(params)
+	if err != nil {
+		return err
+	}
+	// target is looked up when the request is applied, which may be at the
+	// start of its bounds.
+	target := func() *traceAttributeFilter { return t }
+	if t.routes {
+		if table := params.Get("table"); table != "" {
+			target = func() *traceAttributeFilter { return globalScopedFilters.get(table) }
+		} else if reqOp == "query" {
+			var qfrs queryFilterRequests
+			if err := decodeBody(r.Body, &qfrs); err != nil {
+				return err
+			}
+			if err := qfrs.validate(); err != nil {
+				return err
+			}
+			return installBounded(bounds, qfrs.install)
+		}
+	}
 	switch reqOp {
 	case "update":
-		println("Start updating filter")
 		var ufrs updateFilterRequests
-		if err := json.NewDecoder(r.Body).Decode(&ufrs); err != nil {
+		if err := decodeBody(r.Body, &ufrs); err != nil {
 			return err
 		}
-		if err := t.updateFilter(ufrs); err != nil {
+		if _, err := ufrs.validate(); err != nil {
 			return err
 		}
-		return nil
+		update := func() error { return target().updateFilter(ufrs) }
+		if bounds.IsZero() {
+			return update()
+		}
+		return installBounded(bounds, update)
 	case "remove":
-		var rfrs removeFilterRequests
-		if err := json.NewDecoder(r.Body).Decode(&rfrs); err != nil {
-			return err
+		if !bounds.IsZero() {
+			return errBoundedRequest
 		}
-		if err := t.removeFilter(rfrs); err != nil {
+		var rfrs removeFilterRequests
+		if err := decodeBody(r.Body, &rfrs); err != nil {
 			return err
 		}
-		return nil
+		return target().removeFilter(rfrs)
 	case "clear":
-		t.Clear()
+		if !bounds.IsZero() {
+			return errBoundedRequest
+		}
+		target().Clear()
 		return nil
 	default:
-		return errors.New("Unsupported opCode: " + reqOp)
+		return fmt.Errorf("unsupported operation %q", reqOp)
 	}
 }
@@ -0,0 +1,435 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global // import "go.opentelemetry.io/otel/internal/global"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// controlMu serializes the writers of the filter configuration: the requests
+// of the FilterControlHandlers and of HandleRequest, the installations of
+// InstallQuery and the expiry of the bounded queries, and the changes of the
+// named queries. The version checked by a conditional request is thus still
+// the current one when the request is applied.
+var controlMu sync.Mutex
+
+// filterControlResponse is the body of the responses of the
+// FilterControlHandler and of the EventFilterControlHandler.
+type filterControlResponse struct {
+	// Version is the version of the rules, it is incremented on every change.
+	Version uint64 `json:"version"`
+	// Table is the table of the scoped filter, empty for the global filter.
+	Table string `json:"table,omitempty"`
+	// Event is the event name of the TraceEventAttributeFilter, empty for
+	// the TraceEventFilter.
+	Event string `json:"event,omitempty"`
+	// Filter holds the rules of the filter, in the form of an update
+	// request.
+	Filter *traceAttributeFilter `json:"filter"`
+	// Tables holds the rules of every scoped filter, it is only set when
+	// listing the global filter.
+	Tables map[string]*traceAttributeFilter `json:"tables,omitempty"`
+	// Events holds the rules of every TraceEventAttributeFilter, and Links
+	// the ones of the TraceLinkAttributeFilter, they are only set when
+	// listing the TraceEventFilter.
+	Events map[string]*traceAttributeFilter `json:"events,omitempty"`
+	Links  *traceAttributeFilter            `json:"links,omitempty"`
+}
+
+// namedQueriesResponse is the body of the responses of the
+// FilterControlHandler on the named queries.
+type namedQueriesResponse struct {
+	Version uint64               `json:"version"`
+	Queries []namedQueryResponse `json:"queries"`
+}
+
+// namedQueryResponse is the form of a named query in the responses of the
+// FilterControlHandler.
+type namedQueryResponse struct {
+	Name string `json:"name"`
+	// Tables holds the rules of the filters of the query by table, and
+	// Events the names of the span events it selects.
+	Tables map[string]attribute.TraceAttributeFilter `json:"tables"`
+	Events attribute.TraceAttributeFilter            `json:"events"`
+	// Patterns are the caller->callee service chains of the query.
+	Patterns [][]string `json:"patterns,omitempty"`
+	// Remaining is the number of spans the query may still export, if it
+	// has a span budget.
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+type filterControlError struct {
+	Error string `json:"error"`
+}
+
+// filterControlHandler controls the TraceAttributeFilters, or the
+// TraceEventFilter and the TraceEventAttributeFilters if events is set.
+type filterControlHandler struct {
+	events bool
+}
+
+// FilterControlHandler returns an http.Handler controlling the global and the
+// scoped TraceAttributeFilters. The table query parameter selects the filter
+// scoped to a table, the global filter is used without it.
+//
+//   - GET returns the rules of the filter, or of all the filters for the
+//     global filter, and their version.
+//   - PUT replaces the rules of the filter by the ones of the body, in the
+//     form of an update request.
+//   - PATCH adds the rules of the body to the filter.
+//   - PUT and PATCH accept the bounds of their change in the start, ttl and
+//     max_spans query parameters, see HandleRequest.
+//   - DELETE removes the rules on the keys given by the key query parameters,
+//     or all the rules if there are none. A scoped filter is removed.
+//
+// The query parameter selects a named query instead, see SetNamedQuery: GET
+// lists the named queries, or returns the one selected if not empty, PUT
+// installs the JSON form of a query, as handled by the "query" operation of
+// HandleRequest, as the named query selected, within the bounds of the
+// start, ttl and max_spans query parameters, see InstallNamedQuery, and
+// DELETE removes it.
+//
+// Every response has an ETag header holding the version of the rules. A
+// request with an If-Match header fails with 412 Precondition Failed if the
+// rules changed since that version.
+func FilterControlHandler() http.Handler {
+	return filterControlHandler{}
+}
+
+// EventFilterControlHandler returns an http.Handler controlling the
+// TraceEventFilter, with the same methods as the FilterControlHandler. The
+// rules are key matches on the event names, e.g.
+//
+//	{"filters": [{"key": "exception", "type": "", "values": []}]}
+//
+// The event query parameter selects the TraceEventAttributeFilter of an
+// event name instead, and the links query parameter the
+// TraceLinkAttributeFilter. The rules share their version with the ones of
+// the FilterControlHandler.
+func EventFilterControlHandler() http.Handler {
+	return filterControlHandler{events: true}
+}
+
+func (h filterControlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+
+	if !h.events && r.URL.Query().Has("query") {
+		if r.URL.Query().Has("table") || r.URL.Query().Has("event") {
+			writeControlError(w, http.StatusBadRequest, errors.New("conflicting filter selection"))
+			return
+		}
+		serveNamedQueries(w, r, r.URL.Query().Get("query"))
+		return
+	}
+	param := "table"
+	if h.events {
+		param = "event"
+	}
+	name := r.URL.Query().Get(param)
+	links := h.events && r.URL.Query().Has("links")
+	if !h.events && r.URL.Query().Has("event") || h.events && r.URL.Query().Has("table") || links && name != "" {
+		writeControlError(w, http.StatusBadRequest, errors.New("conflicting filter selection"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveRules(w, name, links, http.StatusOK)
+	case http.MethodPut, http.MethodPatch:
+		if !checkVersion(w, r) {
+			return
+		}
+		var ufrs updateFilterRequests
+		if err := decodeBody(r.Body, &ufrs); err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		bounds, err := requestBounds(r.URL.Query())
+		if err == nil {
+			_, err = ufrs.validate()
+		}
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		// The filter is looked up when the change is applied, which may be
+		// at the start of its bounds.
+		put := r.Method == http.MethodPut
+		update := func() error {
+			f := h.filter(links)
+			if name != "" {
+				f = h.scoped().get(name)
+			}
+			if put {
+				return f.replaceFilter(ufrs)
+			}
+			return f.updateFilter(ufrs)
+		}
+		if bounds.IsZero() {
+			err = update()
+		} else {
+			err = installBounded(bounds, update)
+		}
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		if time.Now().Before(bounds.Start) {
+			// The change is scheduled.
+			setETag(w)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		h.serveRules(w, name, links, http.StatusOK)
+	case http.MethodDelete:
+		if !checkVersion(w, r) {
+			return
+		}
+		f := h.filter(links)
+		if name != "" {
+			var ok bool
+			if f, ok = h.scoped().lookup(name); !ok {
+				writeControlError(w, http.StatusNotFound, fmt.Errorf("no filter for %s %q", param, name))
+				return
+			}
+		}
+		switch keys := r.URL.Query()["key"]; {
+		case len(keys) > 0:
+			var rfrs removeFilterRequests
+			for _, key := range keys {
+				rfrs.Filters = append(rfrs.Filters, attribute.Key(key))
+			}
+			_ = f.removeFilter(rfrs)
+		case name != "":
+			h.scoped().remove(name)
+		default:
+			f.Clear()
+		}
+		setETag(w)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, PATCH, DELETE")
+		writeControlError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+	}
+}
+
+// filter returns the filter controlled by h when no scoped filter is
+// selected, the TraceLinkAttributeFilter if links is set.
+func (h filterControlHandler) filter(links bool) *traceAttributeFilter {
+	switch {
+	case links:
+		return TraceLinkAttributeFilter().(*traceAttributeFilter)
+	case h.events:
+		return TraceEventFilter().(*traceAttributeFilter)
+	default:
+		return TraceAttributeFilter().(*traceAttributeFilter)
+	}
+}
+
+// scoped returns the filters selected by name: the scoped
+// TraceAttributeFilters, or the TraceEventAttributeFilters.
+func (h filterControlHandler) scoped() *scopedTraceAttributeFilters {
+	if h.events {
+		return globalEventAttributeFilters
+	}
+	return globalScopedFilters
+}
+
+// serveRules writes the rules of the filter selected by name or links, or of
+// all the filters of h if none is selected.
+func (h filterControlHandler) serveRules(w http.ResponseWriter, name string, links bool, code int) {
+	resp := filterControlResponse{Version: configVersion.Load()}
+	switch {
+	case links:
+		resp.Filter = h.filter(links)
+	case name == "":
+		resp.Filter = h.filter(false)
+		if h.events {
+			resp.Events = h.scoped().all()
+			resp.Links = h.filter(true)
+		} else {
+			resp.Tables = h.scoped().all()
+		}
+	default:
+		var ok bool
+		if resp.Filter, ok = h.scoped().lookup(name); !ok {
+			writeControlError(w, http.StatusNotFound, fmt.Errorf("no filter for %q", name))
+			return
+		}
+		if h.events {
+			resp.Event = name
+		} else {
+			resp.Table = name
+		}
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		writeControlError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("ETag", etag(resp.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+// serveNamedQueries handles r on the named query called name, or on all the
+// named queries if name is empty, see FilterControlHandler.
+func serveNamedQueries(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeControlError(w, http.StatusBadRequest, errors.New("empty query name"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		serveNamedQuery(w, name, http.StatusOK)
+	case http.MethodPut:
+		if !checkVersion(w, r) {
+			return
+		}
+		var qfrs queryFilterRequests
+		if err := decodeBody(r.Body, &qfrs); err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		bounds, err := requestBounds(r.URL.Query())
+		if err == nil {
+			err = qfrs.validate()
+		}
+		if err == nil {
+			err = installNamedQuery(qfrs.namedQuery(name), bounds)
+		}
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		if time.Now().Before(bounds.Start) {
+			// The query is scheduled.
+			setETag(w)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		serveNamedQuery(w, name, http.StatusOK)
+	case http.MethodDelete:
+		if !checkVersion(w, r) {
+			return
+		}
+		if _, ok := LookupNamedQuery(name); !ok {
+			writeControlError(w, http.StatusNotFound, fmt.Errorf("no named query %q", name))
+			return
+		}
+		unregisterNamedQuery(name)
+		setETag(w)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeControlError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s on a named query", r.Method))
+	}
+}
+
+// serveNamedQuery writes the named query called name, or all the named
+// queries if name is empty.
+func serveNamedQuery(w http.ResponseWriter, name string, code int) {
+	resp := namedQueriesResponse{Version: configVersion.Load(), Queries: []namedQueryResponse{}}
+	for _, q := range NamedQueries() {
+		if name != "" && q.Name != name {
+			continue
+		}
+		qr := namedQueryResponse{Name: q.Name, Tables: q.Scoped, Events: q.Events, Patterns: q.Patterns}
+		if q.remaining != nil {
+			remaining := q.remaining.Load()
+			if remaining < 0 {
+				remaining = 0
+			}
+			qr.Remaining = &remaining
+		}
+		resp.Queries = append(resp.Queries, qr)
+	}
+	if name != "" && len(resp.Queries) == 0 {
+		writeControlError(w, http.StatusNotFound, fmt.Errorf("no named query %q", name))
+		return
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		writeControlError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("ETag", etag(resp.Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+// checkVersion reports whether the If-Match header of r, if any, matches the
+// current version of the rules. It writes the 412 Precondition Failed
+// response if not.
+func checkVersion(w http.ResponseWriter, r *http.Request) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" || match == "*" {
+		return true
+	}
+	current := etag(configVersion.Load())
+	for _, tag := range strings.Split(match, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == current {
+			return true
+		}
+	}
+	setETag(w)
+	writeControlError(w, http.StatusPreconditionFailed,
+		fmt.Errorf("rules changed: version is %s, not %s", current, match))
+	return false
+}
+
+// decodeBody decodes the JSON body of a request into v, rejecting unknown
+// fields and trailing data.
+func decodeBody(body io.Reader, v any) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("empty request body")
+		}
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	if dec.More() {
+		return errors.New("invalid request body: trailing data")
+	}
+	return nil
+}
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+func setETag(w http.ResponseWriter) {
+	w.Header().Set("ETag", etag(configVersion.Load()))
+}
+
+func writeControlError(w http.ResponseWriter, code int, err error) {
+	body, _ := json.Marshal(filterControlError{Error: err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
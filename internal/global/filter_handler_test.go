@@ -0,0 +1,314 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func serveControl(t *testing.T, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	FilterControlHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func resetFilters(t *testing.T) {
+	t.Cleanup(func() {
+		TraceAttributeFilter().Clear()
+		TraceEventFilter().Clear()
+		TraceLinkAttributeFilter().Clear()
+		ClearScopedTraceAttributeFilters()
+		ClearTraceEventAttributeFilters()
+	})
+}
+
+func TestFilterControlHandlerRules(t *testing.T) {
+	resetFilters(t)
+
+	rec := serveControl(t, http.MethodPut, "/", `{
+		"filters": [
+			{"key": "method", "type": "string", "values": ["GET"]},
+			{"key": "code", "type": "int64", "values": [500, 599]}
+		],
+		"where": {"key": "user", "type": "", "values": []}
+	}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.True(t, TraceAttributeFilter().Match("code", attribute.Int64Value(503)))
+
+	rec = serveControl(t, http.MethodPatch, "/?table=app1", `{"filters": [{"key": "a", "type": "", "values": []}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{
+		"version": `+strings.Trim(rec.Header().Get("ETag"), `"`)+`,
+		"table": "app1",
+		"filter": {"filters": [{"key": "a", "type": "", "values": []}]}
+	}`, rec.Body.String())
+
+	rec = serveControl(t, http.MethodGet, "/", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var resp map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.JSONEq(t, `{
+		"filters": [
+			{"key": "code", "type": "int64", "values": [500, 599]},
+			{"key": "method", "type": "string", "values": ["GET"]}
+		],
+		"where": {"key": "user"}
+	}`, string(resp["filter"]))
+	assert.JSONEq(t, `{"app1": {"filters": [{"key": "a", "type": "", "values": []}]}}`, string(resp["tables"]))
+
+	// PUT replaces the rules, the listed rules can be put back as is.
+	rec = serveControl(t, http.MethodPut, "/", `{"filters": [{"key": "other", "type": "", "values": []}]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, TraceAttributeFilter().Match("code", attribute.Int64Value(503)))
+	rec = serveControl(t, http.MethodPut, "/", string(resp["filter"]))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, TraceAttributeFilter().Match("code", attribute.Int64Value(503)))
+	assert.False(t, TraceAttributeFilter().Match("other", attribute.StringValue("")))
+
+	rec = serveControl(t, http.MethodDelete, "/?key=code&key=method", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.False(t, TraceAttributeFilter().Match("code", attribute.Int64Value(503)))
+
+	rec = serveControl(t, http.MethodDelete, "/?table=app1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, ScopedTraceAttributeFilterTables())
+	assert.Equal(t, http.StatusNotFound, serveControl(t, http.MethodGet, "/?table=app1", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveControl(t, http.MethodDelete, "/?table=app1", "").Code)
+}
+
+func TestFilterControlHandlerVersion(t *testing.T) {
+	resetFilters(t)
+
+	tag := serveControl(t, http.MethodGet, "/", "").Header().Get("ETag")
+	require.NotEmpty(t, tag)
+
+	body := `{"filters": [{"key": "a", "type": "", "values": []}]}`
+	rec := serveControl(t, http.MethodPatch, "/", body, "If-Match", tag)
+	require.Equal(t, http.StatusOK, rec.Code)
+	next := rec.Header().Get("ETag")
+	assert.NotEqual(t, tag, next, "a change should increment the version")
+
+	// A concurrent operator still holding the previous version.
+	rec = serveControl(t, http.MethodPut, "/", `{"filters": []}`, "If-Match", tag)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	assert.Equal(t, next, rec.Header().Get("ETag"))
+	assert.True(t, TraceAttributeFilter().Match("a", attribute.StringValue("")))
+
+	// Changes out of the handler are versioned too.
+	TraceAttributeFilter().AddKeyMatch("b")
+	rec = serveControl(t, http.MethodDelete, "/", "", "If-Match", next)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	next = serveControl(t, http.MethodGet, "/", "").Header().Get("ETag")
+	TraceAttributeFilter().Restore(TraceAttributeFilter().Snapshot())
+	assert.NotEqual(t, next, serveControl(t, http.MethodGet, "/", "").Header().Get("ETag"))
+
+	rec = serveControl(t, http.MethodDelete, "/", "", "If-Match", "*")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.False(t, TraceAttributeFilter().Match("a", attribute.StringValue("")))
+}
+
+func TestFilterControlWritersSerialized(t *testing.T) {
+	resetFilters(t)
+	flags := FilterConfigFlags()
+	t.Cleanup(func() { SetFilterConfigFlags(flags) })
+
+	// A conditional request between its version check and its change.
+	controlMu.Lock()
+	version := configVersion.Load()
+	installed := make(chan struct{})
+	go func() {
+		defer close(installed)
+		_ = InstallQuery(QueryBounds{}, func() error {
+			TraceAttributeFilter().AddKeyMatch("a")
+			return nil
+		})
+	}()
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/?op=update", strings.NewReader(`{"filters": [{"key": "b", "type": "", "values": []}]}`))
+		_ = TraceAttributeFilter().HandleRequest(req)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, version, configVersion.Load(), "the writers should wait for the conditional request")
+	controlMu.Unlock()
+
+	<-installed
+	assert.Eventually(t, func() bool {
+		return TraceAttributeFilter().Match("b", attribute.InvalidValue())
+	}, time.Second, time.Millisecond)
+}
+
+func TestFilterControlHandlerNamedQueries(t *testing.T) {
+	t.Cleanup(ClearNamedQueries)
+
+	rec := serveControl(t, http.MethodPut, "/?query=q1&max_spans=10", `{
+		"app1": {"filters": [{"key": "code", "type": "int64", "values": [500]}], "events": ["exception"]}
+	}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{
+		"version": `+strings.Trim(rec.Header().Get("ETag"), `"`)+`,
+		"queries": [{
+			"name": "q1",
+			"tables": {"app1": {"filters": [{"key": "code", "type": "int64", "values": [500]}]}},
+			"events": {"filters": [{"key": "exception", "type": "", "values": []}]},
+			"remaining": 10
+		}]
+	}`, rec.Body.String())
+	q, ok := LookupNamedQuery("q1")
+	require.True(t, ok)
+	assert.Equal(t, FilterConfigFlag(AttributeNotMatchFullTraceFilter|AttributeFilter|EventFilter), q.Flags)
+	assert.True(t, q.FilterFor("app1", "lib").Match("code", attribute.Int64Value(500)))
+	assert.False(t, q.Filter.BatchMayMatch(nil), "the spans of other services should not be selected")
+
+	rec = serveControl(t, http.MethodPut, "/?query=q2&start="+time.Now().Add(time.Hour).Format(time.RFC3339), `{"app2": {}}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	rec = serveControl(t, http.MethodPut, "/?query=q3&ttl=1h", `{"app3": {}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = serveControl(t, http.MethodGet, "/?query=", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		Queries []struct{ Name string }
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	var names []string
+	for _, q := range resp.Queries {
+		names = append(names, q.Name)
+	}
+	assert.Equal(t, []string{"q1", "q3"}, names, "a query waiting for its start should not be listed")
+
+	rec = serveControl(t, http.MethodDelete, "/?query=q1", "", "If-Match", `"0"`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = serveControl(t, http.MethodDelete, "/?query=q1", "", "If-Match", rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	_, ok = LookupNamedQuery("q1")
+	assert.False(t, ok)
+
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodGet, "/?query=q1", "", http.StatusNotFound},
+		{http.MethodDelete, "/?query=q1", "", http.StatusNotFound},
+		{http.MethodPut, "/?query=", `{}`, http.StatusBadRequest},
+		{http.MethodPut, "/?query=q4&table=app1", `{}`, http.StatusBadRequest},
+		{http.MethodPut, "/?query=q4", `{"app1": {"filters": [{"key": "a", "type": "map", "values": [1]}]}}`, http.StatusBadRequest},
+		{http.MethodPut, "/?query=q4&ttl=0s", `{}`, http.StatusBadRequest},
+		{http.MethodPatch, "/?query=q4", `{}`, http.StatusMethodNotAllowed},
+	} {
+		rec := serveControl(t, tc.method, tc.target, tc.body)
+		assert.Equal(t, tc.code, rec.Code, "%s %s: %s", tc.method, tc.target, rec.Body.String())
+	}
+	_, ok = LookupNamedQuery("q4")
+	assert.False(t, ok, "invalid requests should not be applied")
+}
+
+func TestFilterControlHandlerErrors(t *testing.T) {
+	resetFilters(t)
+	TraceAttributeFilter().AddKeyMatch("kept")
+
+	for _, body := range []string{
+		``,
+		`{"filters": [`,
+		`{"filter": []}`,
+		`{"filters": []} {}`,
+		`{"filters": [{"key": "a", "type": "map", "values": [1]}]}`,
+		`{"filters": [{"key": "a", "type": "int64", "values": ["1"]}]}`,
+		`{"filters": [{"key": "a", "type": "", "values": []}, {"key": "b", "type": "bool", "values": [1]}]}`,
+		`{"where": {"key": "a", "type": "map", "values": [1]}}`,
+	} {
+		rec := serveControl(t, http.MethodPut, "/", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), `"error"`, body)
+	}
+	assert.True(t, TraceAttributeFilter().Match("kept", attribute.StringValue("")), "invalid requests should not be applied")
+	assert.False(t, TraceAttributeFilter().Match("a", attribute.StringValue("")), "invalid requests should not be applied")
+
+	rec := serveControl(t, http.MethodPost, "/", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Contains(t, rec.Header().Get("Allow"), "PATCH")
+}
+
+func TestEventFilterControlHandler(t *testing.T) {
+	resetFilters(t)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		EventFilterControlHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPut, "/", `{"filters": [{"key": "exception", "type": "", "values": []}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.True(t, TraceEventFilter().Match("exception", attribute.InvalidValue()))
+	assert.False(t, TraceAttributeFilter().Match("exception", attribute.InvalidValue()), "the attribute filter should be unchanged")
+
+	rec = serve(http.MethodPatch, "/", `{"filters": [{"key": "retry", "type": "", "values": []}]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"version": `+strings.Trim(rec.Header().Get("ETag"), `"`)+`,
+		"filter": {"filters": [
+			{"key": "exception", "type": "", "values": []},
+			{"key": "retry", "type": "", "values": []}
+		]},
+		"links": {"filters": []}
+	}`, serve(http.MethodGet, "/", "").Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/?table=app1", "").Code)
+
+	rec = serve(http.MethodDelete, "/?key=exception", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.False(t, TraceEventFilter().Match("exception", attribute.InvalidValue()))
+	assert.True(t, TraceEventFilter().Match("retry", attribute.InvalidValue()))
+
+	// The attributes of the events and of the links.
+	rec = serve(http.MethodPut, "/?event=exception", `{"filters": [{"key": "exception.type", "type": "", "values": []}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"event":"exception"`)
+	rec = serve(http.MethodPatch, "/?links", `{"filters": [{"key": "peer", "type": "", "values": []}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	f, ok := TraceEventAttributeFilterFor("exception")
+	require.True(t, ok)
+	assert.True(t, f.Match("exception.type", attribute.StringValue("")))
+	assert.True(t, TraceLinkAttributeFilter().Match("peer", attribute.StringValue("")))
+
+	var resp map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(serve(http.MethodGet, "/", "").Body.Bytes(), &resp))
+	assert.JSONEq(t, `{"exception": {"filters": [{"key": "exception.type", "type": "", "values": []}]}}`, string(resp["events"]))
+	assert.JSONEq(t, `{"filters": [{"key": "peer", "type": "", "values": []}]}`, string(resp["links"]))
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/?event=exception&links", "").Code)
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/?event=exception", "").Code)
+	assert.Empty(t, TraceEventAttributeFilterNames())
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/?event=exception", "").Code)
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/?links", "").Code)
+	assert.False(t, TraceLinkAttributeFilter().Match("peer", attribute.StringValue("")))
+}
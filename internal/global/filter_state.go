@@ -0,0 +1,210 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global // import "go.opentelemetry.io/otel/internal/global"
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FilterState is a consistent view of the whole filter configuration: the
+// filter flags, the rules of the global and scoped filters, the structural
+// patterns and the named queries. A new FilterState is published on every
+// change of any of them, so that a filtering pass loading it once applies a
+// single configuration without locking, whatever the changes made while it
+// runs.
+//
+// A FilterState and its filters must not be changed.
+type FilterState struct {
+	// Flags are the filters enabled, see FilterConfigFlags.
+	Flags FilterConfigFlag
+	// QueryID is the identity of the active query, see QueryID.
+	QueryID string
+	// Patterns are the caller->callee service chains of the
+	// StructuralTraceFilter, see TraceStructuralPatterns.
+	Patterns [][]string
+	// Attributes is the global TraceAttributeFilter and Scoped are the
+	// scoped ones by table, see TraceAttributeFilterFor.
+	Attributes attribute.TraceAttributeFilter
+	Scoped     map[string]attribute.TraceAttributeFilter
+	// Events is the TraceEventFilter and EventAttributes are the
+	// TraceEventAttributeFilters by event name.
+	Events          attribute.TraceAttributeFilter
+	EventAttributes map[string]attribute.TraceAttributeFilter
+	// Links is the TraceLinkAttributeFilter.
+	Links attribute.TraceAttributeFilter
+	// NamedQueries are the named queries sorted by name, see NamedQueries.
+	NamedQueries []*NamedQuery
+
+	// budget is the installed bounded query with a span budget, nil if
+	// there is none.
+	budget *boundedQuery
+}
+
+var (
+	// filterStateMu serializes the publications of the FilterState.
+	filterStateMu sync.Mutex
+	// batches is the number of batched changes in progress, see
+	// batchFilterState, and drafts the filters changed by them, published
+	// when the last one ends. They are guarded by filterStateMu.
+	batches int
+	drafts  []*traceAttributeFilter
+	// filterState holds the current FilterState, nil until the first one
+	// is published.
+	filterState atomic.Pointer[FilterState]
+)
+
+// CurrentFilterState returns the current FilterState.
+func CurrentFilterState() *FilterState {
+	if s := filterState.Load(); s != nil {
+		return s
+	}
+	publishFilterState()
+	return filterState.Load()
+}
+
+// publishFilterState replaces the FilterState by one of the current
+// configuration. It is called after every change of the configuration: the
+// last publication follows all the changes, whichever order the changes
+// racing each other publish in. Nothing is published while a batched change
+// is in progress, the last one ending publishes.
+func publishFilterState() {
+	filterStateMu.Lock()
+	defer filterStateMu.Unlock()
+	if batches > 0 && filterState.Load() != nil {
+		return
+	}
+	storeFilterState()
+}
+
+// batchFilterState runs change, a change of the configuration made of several
+// steps, and publishes the FilterState once it is done rather than after each
+// step, so that no FilterState holds a part of the change. The filters
+// changed by change are changed in drafts, published with the FilterState.
+func batchFilterState(change func() error) error {
+	filterStateMu.Lock()
+	batches++
+	filterStateMu.Unlock()
+	defer func() {
+		for {
+			filterStateMu.Lock()
+			if batches > 1 || len(drafts) == 0 {
+				if batches--; batches == 0 {
+					storeFilterState()
+				}
+				filterStateMu.Unlock()
+				return
+			}
+			changed := drafts
+			drafts = nil
+			filterStateMu.Unlock()
+			// The lock of a filter is taken before filterStateMu. The
+			// batch stays in progress while its drafts are published, so
+			// that no FilterState holds a part of them.
+			for _, t := range changed {
+				t.flush()
+			}
+		}
+	}()
+	return change()
+}
+
+// deferFilterPublication reports whether a batch is in progress, in which
+// case t, whose draft was changed, is published when the last batch ends.
+func deferFilterPublication(t *traceAttributeFilter) bool {
+	filterStateMu.Lock()
+	defer filterStateMu.Unlock()
+	if batches == 0 {
+		return false
+	}
+	for _, d := range drafts {
+		if d == t {
+			return true
+		}
+	}
+	drafts = append(drafts, t)
+	return true
+}
+
+// storeFilterState stores a FilterState of the current configuration.
+// filterStateMu must be held.
+func storeFilterState() {
+	filterState.Store(&FilterState{
+		Flags:           FilterConfigFlags(),
+		QueryID:         QueryID(),
+		Patterns:        TraceStructuralPatterns(),
+		Attributes:      publishedFilter(TraceAttributeFilter()),
+		Scoped:          globalScopedFilters.published(),
+		Events:          publishedFilter(TraceEventFilter()),
+		EventAttributes: globalEventAttributeFilters.published(),
+		Links:           publishedFilter(TraceLinkAttributeFilter()),
+		NamedQueries:    NamedQueries(),
+		budget:          budgeted.Load(),
+	})
+}
+
+// publishedFilter returns the filter published by f, f itself if it does not
+// publish its rules.
+func publishedFilter(f attribute.TraceAttributeFilter) attribute.TraceAttributeFilter {
+	if t, ok := f.(*traceAttributeFilter); ok {
+		return t.filter()
+	}
+	return f
+}
+
+// FilterFor returns the TraceAttributeFilter to apply to the spans of
+// service, with the instrumentation scope named scope, as
+// TraceAttributeFilterFor does.
+func (s *FilterState) FilterFor(service, scope string) attribute.TraceAttributeFilter {
+	if f, ok := s.Scoped[service]; ok {
+		return f
+	}
+	if f, ok := s.Scoped[scope]; ok {
+		return f
+	}
+	return s.Attributes
+}
+
+// Filters returns Attributes followed by the Scoped filters, all the filters
+// that FilterFor may return.
+func (s *FilterState) Filters() []attribute.TraceAttributeFilter {
+	filters := make([]attribute.TraceAttributeFilter, 0, len(s.Scoped)+1)
+	filters = append(filters, s.Attributes)
+	for _, f := range s.Scoped {
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// EventAttributeFilterFor returns the TraceAttributeFilter projecting the
+// attributes of the span events named name, as TraceEventAttributeFilterFor
+// does.
+func (s *FilterState) EventAttributeFilterFor(name string) (attribute.TraceAttributeFilter, bool) {
+	f, ok := s.EventAttributes[name]
+	if !ok {
+		f, ok = s.EventAttributes[string(attribute.WildcardKey)]
+	}
+	return f, ok
+}
+
+// TakeQuerySpan reports whether a span selected by the query of s may be
+// exported, as TakeQuerySpan does. The spans are taken off the budget of the
+// query s was published with, so that a filtering pass does not export more
+// spans than the budget if the query expires while it runs.
+func (s *FilterState) TakeQuerySpan() bool {
+	return s.budget.take()
+}
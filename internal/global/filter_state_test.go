@@ -0,0 +1,155 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestFilterState(t *testing.T) {
+	flags, id := FilterConfigFlags(), QueryID()
+	t.Cleanup(func() {
+		TraceAttributeFilter().Clear()
+		ClearScopedTraceAttributeFilters()
+		ClearTraceEventAttributeFilters()
+		ClearNamedQueries()
+		SetTraceStructuralPatterns(nil)
+		SetQueryID(id)
+		SetFilterConfigFlags(flags)
+	})
+
+	TraceAttributeFilter().AddKeyMatch("a")
+	SetFilterConfigFlags(AttributeFilter)
+	before := CurrentFilterState()
+	assert.Equal(t, FilterConfigFlag(AttributeFilter), before.Flags)
+	assert.True(t, before.Attributes.Match("a", attribute.InvalidValue()))
+
+	TraceAttributeFilter().RemoveMatch("a")
+	ScopedTraceAttributeFilter("app1").AddKeyMatch("b")
+	TraceEventAttributeFilter(string(attribute.WildcardKey)).AddKeyMatch("c")
+	SetTraceStructuralPatterns([][]string{{"app1", "app2"}})
+	SetQueryID("q")
+	SetNamedQuery(&NamedQuery{Name: "named"})
+	SetFilterConfigFlags(AttributeFilter | StructuralTraceFilter)
+
+	st := CurrentFilterState()
+	assert.Equal(t, FilterConfigFlag(AttributeFilter|StructuralTraceFilter), st.Flags)
+	assert.Equal(t, "q", st.QueryID)
+	assert.Equal(t, [][]string{{"app1", "app2"}}, st.Patterns)
+	assert.Len(t, st.NamedQueries, 1)
+	assert.False(t, st.Attributes.Match("a", attribute.InvalidValue()))
+	assert.True(t, st.FilterFor("app2", "app1").Match("b", attribute.InvalidValue()))
+	assert.Same(t, st.Attributes, st.FilterFor("app2", "lib"))
+	assert.Len(t, st.Filters(), 2)
+	f, ok := st.EventAttributeFilterFor("exception")
+	assert.True(t, ok)
+	assert.True(t, f.Match("c", attribute.InvalidValue()))
+
+	assert.Equal(t, FilterConfigFlag(AttributeFilter), before.Flags, "a published state should not change")
+	assert.True(t, before.Attributes.Match("a", attribute.InvalidValue()), "a published state should not change")
+	assert.Empty(t, before.Scoped)
+}
+
+func TestFilterStateBatch(t *testing.T) {
+	flags, id := FilterConfigFlags(), QueryID()
+	t.Cleanup(func() {
+		TraceAttributeFilter().Clear()
+		SetQueryID(id)
+		SetFilterConfigFlags(flags)
+	})
+
+	before := CurrentFilterState()
+	assert.NoError(t, InstallQuery(QueryBounds{}, func() error {
+		SetFilterConfigFlags(0)
+		TraceAttributeFilter().AddKeyMatch("a")
+		SetQueryID("q")
+		SetFilterConfigFlags(AttributeFilter)
+		assert.Same(t, before, CurrentFilterState(), "the steps of an installation should not be published")
+		return nil
+	}))
+	st := CurrentFilterState()
+	assert.Equal(t, FilterConfigFlag(AttributeFilter), st.Flags)
+	assert.Equal(t, "q", st.QueryID)
+	assert.True(t, st.Attributes.Match("a", attribute.InvalidValue()))
+}
+
+func TestFilterStateBatchDrafts(t *testing.T) {
+	t.Cleanup(TraceAttributeFilter().Clear)
+
+	f := TraceAttributeFilter().(*traceAttributeFilter)
+	published := f.filter()
+	assert.NoError(t, InstallQuery(QueryBounds{}, func() error {
+		for i := 0; i < 1000; i++ {
+			f.AddEqualityMatch("tenant", attribute.IntValue(i))
+		}
+		assert.Same(t, published, f.filter(), "the changes of a batch should not be published before it ends")
+		assert.Len(t, f.Rules(), 1000, "a batch should see its own changes")
+		return nil
+	}))
+	assert.NotSame(t, published, f.filter())
+	assert.Len(t, f.filter().Rules(), 1000)
+	assert.True(t, CurrentFilterState().Attributes.Match("tenant", attribute.IntValue(999)))
+
+	f.RemoveMatch("tenant")
+	assert.Empty(t, CurrentFilterState().Attributes.Rules(), "a change outside of a batch should be published at once")
+}
+
+func TestFilterStateBudget(t *testing.T) {
+	flags := FilterConfigFlags()
+	t.Cleanup(func() { SetFilterConfigFlags(flags) })
+
+	assert.NoError(t, InstallQuery(QueryBounds{MaxSpans: 1}, func() error {
+		SetFilterConfigFlags(AttributeFilter)
+		return nil
+	}))
+	st := CurrentFilterState()
+	assert.True(t, st.TakeQuerySpan())
+	assert.Eventually(t, func() bool { return FilterConfigFlags() == flags }, time.Second, time.Millisecond, "the query should expire with its budget")
+	assert.False(t, st.TakeQuerySpan(), "the budget of the state should stay spent")
+	assert.True(t, CurrentFilterState().TakeQuerySpan())
+}
+
+func TestTraceAttributeFilterConcurrentAccess(t *testing.T) {
+	f := newTraceAttributeFilter()
+	attrs := []attribute.KeyValue{attribute.Int("a", 1), attribute.String("b", "x")}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			f.AddEqualityMatch("a", attribute.IntValue(i))
+			f.SetCondition(attribute.Equal("b", attribute.StringValue("x")))
+			f.RemoveMatch("a")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			f.Match("a", attribute.IntValue(1))
+			f.BatchMatch(attrs, func(attribute.KeyValue) error { return nil })
+			f.BatchNotMatch(attrs, func() error { return nil })
+			_ = f.Rules()
+		}
+	}()
+	wg.Wait()
+	assert.Empty(t, f.Rules())
+}
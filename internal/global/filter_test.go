@@ -0,0 +1,193 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func notMatched(f attribute.TraceAttributeFilter, attrs ...attribute.KeyValue) bool {
+	var called bool
+	f.BatchNotMatch(attrs, func() error {
+		called = true
+		return nil
+	})
+	return called
+}
+
+func TestHandleRequestUpdateWhere(t *testing.T) {
+	f := newTraceAttributeFilter()
+	req := httptest.NewRequest("POST", "/?op=update", strings.NewReader(`{
+		"filters": [{"key": "method", "type": "", "values": []}],
+		"where": {"or": [
+			{"key": "code", "type": "int64", "values": [500, 599]},
+			{"not": {"key": "method", "type": "string", "values": ["GET"]}}
+		]}
+	}`))
+	require.NoError(t, f.HandleRequest(req))
+
+	assert.True(t, f.Match("method", attribute.StringValue("GET")))
+	assert.False(t, notMatched(f, attribute.Int("code", 503), attribute.String("method", "GET")))
+	assert.False(t, notMatched(f, attribute.Int("code", 200), attribute.String("method", "POST")))
+	assert.True(t, notMatched(f, attribute.Int("code", 200), attribute.String("method", "GET")))
+
+	req = httptest.NewRequest("POST", "/?op=clear", nil)
+	require.NoError(t, f.HandleRequest(req))
+	assert.False(t, notMatched(f, attribute.Int("code", 200), attribute.String("method", "GET")))
+}
+
+func TestHandleRequestInvalidWhere(t *testing.T) {
+	f := newTraceAttributeFilter()
+	req := httptest.NewRequest("POST", "/?op=update", strings.NewReader(`{"where": {"key": "a", "type": "map", "values": [1]}}`))
+	assert.Error(t, f.HandleRequest(req))
+}
+
+func TestHandleRequestUpdateMultipleValues(t *testing.T) {
+	f := newTraceAttributeFilter()
+	req := httptest.NewRequest("POST", "/?op=update", strings.NewReader(`{"filters": [
+		{"key": "code", "type": "int64", "op": "in", "values": [500, 502, 503]},
+		{"key": "code", "type": "int64", "op": "range", "values": [510, 520, 530, 540]},
+		{"key": "method", "type": "string", "values": ["GET", "HEAD"]}
+	]}`))
+	require.NoError(t, f.HandleRequest(req))
+
+	for code, want := range map[int64]bool{500: true, 501: false, 503: true, 515: true, 525: false, 540: true} {
+		assert.Equal(t, want, f.Match("code", attribute.Int64Value(code)), code)
+	}
+	assert.True(t, f.Match("method", attribute.StringValue("HEAD")))
+	assert.False(t, f.Match("method", attribute.StringValue("POST")))
+
+	req = httptest.NewRequest("POST", "/?op=update", strings.NewReader(`{"filters": [
+		{"key": "code", "type": "int64", "op": "range", "values": [1, 2, 3]}
+	]}`))
+	assert.Error(t, f.HandleRequest(req))
+}
+
+func TestHandleRequestUpdateArrays(t *testing.T) {
+	f := newTraceAttributeFilter()
+	req := httptest.NewRequest("POST", "/?op=update", strings.NewReader(`{"filters": [
+		{"key": "tags", "type": "string", "op": "contains_any", "values": ["a", "b"]},
+		{"key": "codes", "type": "int64", "op": "contains_all", "values": [500, 502]},
+		{"key": "codes", "type": "int64", "op": "length", "values": [4]}
+	], "where": {"key": "tags", "type": "int64", "op": "length", "values": [1, 10]}}`))
+	require.NoError(t, f.HandleRequest(req))
+
+	assert.True(t, f.Match("tags", attribute.StringSliceValue([]string{"c", "b"})))
+	assert.False(t, f.Match("tags", attribute.StringSliceValue([]string{"c"})))
+	assert.True(t, f.Match("codes", attribute.Int64SliceValue([]int64{502, 200, 500})))
+	assert.True(t, f.Match("codes", attribute.Int64SliceValue([]int64{1, 2, 3, 4})))
+	assert.False(t, f.Match("codes", attribute.Int64SliceValue([]int64{502})))
+	assert.Equal(t, "LENGTH(tags) IN [1, 10]", f.Snapshot().Condition().String())
+
+	req = httptest.NewRequest("POST", "/?op=update", strings.NewReader(`{"filters": [
+		{"key": "tags", "type": "string", "op": "length", "values": ["1"]}
+	]}`))
+	assert.Error(t, f.HandleRequest(req))
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	f := newTraceAttributeFilter()
+	f.AddKeyMatch("kept")
+	for _, body := range []string{
+		`{"filters": [{"key": "code", "type": "int64", "values": "500"}]}`,
+		`{"filters": [{"key": 1, "type": "int64", "values": [500]}]}`,
+		`{"filters": [{"key": "code", "type": "int64", "values": [500]}], "unknown": true}`,
+		`{"filters": []} {}`,
+		``,
+	} {
+		req := httptest.NewRequest("POST", "/?op=update", strings.NewReader(body))
+		assert.Error(t, f.HandleRequest(req), body)
+	}
+
+	req := httptest.NewRequest("POST", "/?op=update", strings.NewReader(`{"filters": [
+		{"key": "method", "type": "string", "values": ["GET"]},
+		{"key": "code", "type": "int64", "values": [1.5]},
+		{"key": "", "type": "string", "values": ["x"]},
+		{"key": "ratio", "type": "float64", "values": ["x"]},
+		{"key": "path", "type": "map", "values": [1]},
+		{"key": "name", "type": "string", "op": "regex", "values": ["("]}
+	]}`))
+	err := f.HandleRequest(req)
+	var reqErr *attribute.FilterRequestError
+	require.ErrorAs(t, err, &reqErr)
+	indexes := make([]int, len(reqErr.Rules))
+	for i, r := range reqErr.Rules {
+		indexes[i] = r.Index
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, indexes, "every invalid filter should be listed")
+	assert.Equal(t, []attribute.Rule{attribute.KeyRule("kept")}, f.Rules(), "an invalid request should not be applied")
+
+	req = httptest.NewRequest("POST", "/?op=remove", strings.NewReader(`{"filters": ["kept", ""]}`))
+	require.ErrorAs(t, f.HandleRequest(req), &reqErr)
+	assert.Equal(t, []attribute.Rule{attribute.KeyRule("kept")}, f.Rules())
+
+	req = httptest.NewRequest("POST", "/?op=unknown", nil)
+	assert.Error(t, f.HandleRequest(req))
+}
+
+func TestHandleRequestQueryValidation(t *testing.T) {
+	t.Cleanup(ClearScopedTraceAttributeFilters)
+	ScopedTraceAttributeFilter("kept").AddKeyMatch("a")
+
+	req := httptest.NewRequest("POST", "/?op=query", strings.NewReader(`{
+		"app1": {"filters": [{"key": "a", "type": "", "values": []}]},
+		"app2": {"filters": [{"key": "b", "type": "bool", "values": [1]}]},
+		"app3": {"filters": [{"key": "", "type": "", "values": []}]}
+	}`))
+	var reqErr *attribute.FilterRequestError
+	require.ErrorAs(t, newTraceAttributeFilter().HandleRequest(req), &reqErr)
+	require.Len(t, reqErr.Rules, 2)
+	assert.Equal(t, "app2", reqErr.Rules[0].Table)
+	assert.Equal(t, "app3", reqErr.Rules[1].Table)
+	assert.Equal(t, []string{"kept"}, ScopedTraceAttributeFilterTables(), "an invalid query should change nothing")
+}
+
+func TestInstallQueryJSON(t *testing.T) {
+	flags := FilterConfigFlags()
+	t.Cleanup(func() {
+		ClearScopedTraceAttributeFilters()
+		TraceEventFilter().Clear()
+		SetFilterConfigFlags(flags)
+	})
+	ScopedTraceAttributeFilter("stale").AddKeyMatch("a")
+
+	before := CurrentFilterState()
+	require.NoError(t, InstallQueryJSON([]byte(`{
+		"app1": {"filters": [{"key": "a", "type": "", "values": []}], "events": ["exception"]},
+		"app2": {"filters": [{"key": "b", "type": "", "values": []}]}
+	}`), func(flag FilterConfigFlag) {
+		assert.False(t, controlMu.TryLock(), "the query should be installed under the control lock")
+		assert.Same(t, before, CurrentFilterState(), "a part of the query should not be published")
+		assert.Equal(t, FilterConfigFlag(AttributeFilter|EventFilter), flag)
+		SetFilterConfigFlags(flag)
+	}))
+	st := CurrentFilterState()
+	assert.Equal(t, FilterConfigFlag(AttributeFilter|EventFilter), st.Flags)
+	assert.Len(t, st.Scoped, 2)
+	assert.True(t, st.FilterFor("app2", "").Match("b", attribute.InvalidValue()))
+	assert.True(t, st.Events.Match("exception", attribute.InvalidValue()))
+
+	assert.Error(t, InstallQueryJSON([]byte(`{"app3": {"filters": [{"key": "", "type": "", "values": []}]}}`), func(FilterConfigFlag) {
+		t.Error("an invalid query should not be installed")
+	}))
+	assert.Equal(t, []string{"app1", "app2"}, ScopedTraceAttributeFilterTables())
+}
@@ -19,8 +19,15 @@ import (
 	"os"
 	"sync/atomic"
 	"unsafe"
+
+	iattribute "go.opentelemetry.io/otel/internal/attribute"
 )
 
+func init() {
+	// The attribute filters report their errors to the global ErrorHandler.
+	iattribute.SetErrorHandler(Handle)
+}
+
 var (
 	// GlobalErrorHandler provides an ErrorHandler that can be used
 	// throughout an OpenTelemetry instrumented project. When a user
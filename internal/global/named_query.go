@@ -0,0 +1,310 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global // import "go.opentelemetry.io/otel/internal/global"
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NamedQuery is a query installed next to the global filter configuration,
+// with filters of its own, so that several queries run at the same time. A
+// span is exported if the global filter configuration or any named query
+// selects it.
+//
+// A NamedQuery must not be changed once registered with SetNamedQuery.
+type NamedQuery struct {
+	// Name identifies the query among the named queries.
+	Name string
+	// Flags are the filters the query enables, among the
+	// AttributeNotMatchFullTraceFilter, the AttributeFilter, the
+	// StructuralTraceFilter and the EventFilter.
+	Flags FilterConfigFlag
+	// Filter is the TraceAttributeFilter of the spans of the services and
+	// instrumentation scopes without a filter in Scoped.
+	Filter attribute.TraceAttributeFilter
+	// Scoped are the TraceAttributeFilters by table, see
+	// ScopedTraceAttributeFilter.
+	Scoped map[string]attribute.TraceAttributeFilter
+	// Events has a key match on the names of the span events exported when
+	// the EventFilter is enabled.
+	Events attribute.TraceAttributeFilter
+	// Patterns are the caller->callee service chains of the
+	// StructuralTraceFilter.
+	Patterns [][]string
+
+	// remaining is the number of spans the query may still export, nil
+	// without span budget, see InstallNamedQuery.
+	remaining *atomic.Int64
+}
+
+// namedQueryFlags are the filters a NamedQuery may enable.
+const namedQueryFlags = AttributeNotMatchFullTraceFilter | AttributeFilter | StructuralTraceFilter | EventFilter
+
+// FilterFor returns the TraceAttributeFilter of q to apply to the spans of
+// service, with the instrumentation scope named scope, as
+// TraceAttributeFilterFor does for the global filters.
+func (q *NamedQuery) FilterFor(service, scope string) attribute.TraceAttributeFilter {
+	if f, ok := q.Scoped[service]; ok {
+		return f
+	}
+	if f, ok := q.Scoped[scope]; ok {
+		return f
+	}
+	return q.Filter
+}
+
+// Filters returns Filter followed by the scoped filters of q, all the
+// filters that FilterFor may return.
+func (q *NamedQuery) Filters() []attribute.TraceAttributeFilter {
+	filters := make([]attribute.TraceAttributeFilter, 0, len(q.Scoped)+1)
+	filters = append(filters, q.Filter)
+	for _, f := range q.Scoped {
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+var (
+	// namedQueriesMu serializes the changes of the named queries. It is
+	// taken after controlMu.
+	namedQueriesMu sync.Mutex
+	// namedQueries holds the named queries sorted by name, it is replaced on
+	// every change.
+	namedQueries atomic.Pointer[[]*NamedQuery]
+	// namedTimers holds the timers of the bounded named queries by name: the
+	// timer registering a query at its start, then the one removing it at its
+	// expiry. It is guarded by namedQueriesMu.
+	namedTimers = make(map[string]*time.Timer)
+)
+
+// NamedQueries returns the named queries sorted by name. The returned slice
+// must not be modified.
+func NamedQueries() []*NamedQuery {
+	if qs := namedQueries.Load(); qs != nil {
+		return *qs
+	}
+	return nil
+}
+
+// LookupNamedQuery returns the named query called name, if any.
+func LookupNamedQuery(name string) (*NamedQuery, bool) {
+	qs := NamedQueries()
+	i := sort.Search(len(qs), func(i int) bool { return qs[i].Name >= name })
+	if i < len(qs) && qs[i].Name == name {
+		return qs[i], true
+	}
+	return nil, false
+}
+
+// SetNamedQuery registers q, replacing the named query with the same name if
+// any. The flags q may not enable are ignored, and the structural patterns
+// with less than two services, as by SetTraceStructuralPatterns.
+func SetNamedQuery(q *NamedQuery) {
+	_ = InstallNamedQuery(q, QueryBounds{})
+}
+
+// InstallNamedQuery registers q within bounds, as SetNamedQuery does. With
+// zero bounds, q is registered at once and for good. Otherwise q is only
+// registered at the start of bounds, and removed when its TTL or its span
+// budget runs out, see TakeSpan.
+//
+// q replaces the named query with the same name, including one waiting for
+// its start, whose bounds no longer apply.
+func InstallNamedQuery(q *NamedQuery, bounds QueryBounds) error {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	return installNamedQuery(q, bounds)
+}
+
+// installNamedQuery registers q within bounds, see InstallNamedQuery.
+// controlMu must be held.
+func installNamedQuery(q *NamedQuery, bounds QueryBounds) error {
+	if bounds.TTL > 0 && !bounds.Start.IsZero() && !time.Now().Before(bounds.Start.Add(bounds.TTL)) {
+		return errors.New("query expired before its installation")
+	}
+	cp := *q
+	cp.Flags &= namedQueryFlags
+	cp.Patterns = nil
+	for _, p := range q.Patterns {
+		if len(p) >= 2 {
+			cp.Patterns = append(cp.Patterns, append([]string(nil), p...))
+		}
+	}
+	if cp.Filter == nil {
+		cp.Filter = attribute.NewMapTraceAttributeFilter()
+	}
+	if cp.Events == nil {
+		cp.Events = attribute.NewMapTraceAttributeFilter()
+	}
+	cp.remaining = nil
+	if bounds.MaxSpans > 0 {
+		cp.remaining = new(atomic.Int64)
+		cp.remaining.Store(bounds.MaxSpans)
+	}
+
+	namedQueriesMu.Lock()
+	defer namedQueriesMu.Unlock()
+	stopNamedTimer(cp.Name)
+	if wait := time.Until(bounds.Start); wait > 0 {
+		// Nothing is registered until the start.
+		storeNamedQueries(removeNamedQuery(copyNamedQueries(), cp.Name))
+		var timer *time.Timer
+		timer = time.AfterFunc(wait, func() {
+			controlMu.Lock()
+			defer controlMu.Unlock()
+			namedQueriesMu.Lock()
+			defer namedQueriesMu.Unlock()
+			if namedTimers[cp.Name] != timer {
+				return
+			}
+			delete(namedTimers, cp.Name)
+			registerNamedQuery(&cp, bounds)
+		})
+		namedTimers[cp.Name] = timer
+		return nil
+	}
+	registerNamedQuery(&cp, bounds)
+	return nil
+}
+
+// registerNamedQuery registers q, replacing the named query with the same
+// name, and arms the TTL of bounds, which runs from the start of bounds if
+// set. namedQueriesMu must be held.
+func registerNamedQuery(q *NamedQuery, bounds QueryBounds) {
+	qs := removeNamedQuery(copyNamedQueries(), q.Name)
+	i := sort.Search(len(qs), func(i int) bool { return qs[i].Name >= q.Name })
+	qs = append(qs, nil)
+	copy(qs[i+1:], qs[i:])
+	qs[i] = q
+	storeNamedQueries(qs)
+	if bounds.TTL > 0 {
+		ttl := bounds.TTL
+		if !bounds.Start.IsZero() {
+			ttl = time.Until(bounds.Start.Add(bounds.TTL))
+		}
+		var timer *time.Timer
+		timer = time.AfterFunc(ttl, func() {
+			controlMu.Lock()
+			defer controlMu.Unlock()
+			namedQueriesMu.Lock()
+			defer namedQueriesMu.Unlock()
+			if namedTimers[q.Name] != timer {
+				return
+			}
+			delete(namedTimers, q.Name)
+			Debug("named query expired", "query", q.Name)
+			storeNamedQueries(removeNamedQuery(copyNamedQueries(), q.Name))
+		})
+		namedTimers[q.Name] = timer
+	}
+}
+
+// TakeSpan reports whether a span selected by q may be exported: q has no
+// span budget or its budget is not spent. q is removed in the background
+// when the last span of its budget is taken.
+func (q *NamedQuery) TakeSpan() bool {
+	if q.remaining == nil {
+		return true
+	}
+	left := q.remaining.Add(-1)
+	if left == 0 {
+		// The export path does not wait for the query to be removed, the
+		// spent budget already stops the spans.
+		go q.expire()
+	}
+	return left >= 0
+}
+
+// expire removes q if it is still registered.
+func (q *NamedQuery) expire() {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	namedQueriesMu.Lock()
+	defer namedQueriesMu.Unlock()
+	if current, ok := LookupNamedQuery(q.Name); !ok || current != q {
+		return
+	}
+	stopNamedTimer(q.Name)
+	Debug("named query spent its span budget", "query", q.Name)
+	storeNamedQueries(removeNamedQuery(copyNamedQueries(), q.Name))
+}
+
+// RemoveNamedQuery removes the named query called name, if any, or the one
+// waiting for its start.
+func RemoveNamedQuery(name string) {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	unregisterNamedQuery(name)
+}
+
+// unregisterNamedQuery removes the named query called name, see
+// RemoveNamedQuery. controlMu must be held.
+func unregisterNamedQuery(name string) {
+	namedQueriesMu.Lock()
+	defer namedQueriesMu.Unlock()
+	stopNamedTimer(name)
+	storeNamedQueries(removeNamedQuery(copyNamedQueries(), name))
+}
+
+// ClearNamedQueries removes all the named queries, and the ones waiting for
+// their start.
+func ClearNamedQueries() {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	namedQueriesMu.Lock()
+	defer namedQueriesMu.Unlock()
+	for name := range namedTimers {
+		stopNamedTimer(name)
+	}
+	storeNamedQueries(nil)
+}
+
+// stopNamedTimer stops and forgets the timer of the named query called name,
+// if any. namedQueriesMu must be held.
+func stopNamedTimer(name string) {
+	if timer, ok := namedTimers[name]; ok {
+		timer.Stop()
+		delete(namedTimers, name)
+	}
+}
+
+// copyNamedQueries returns a copy of the named queries to change.
+func copyNamedQueries() []*NamedQuery {
+	return append([]*NamedQuery(nil), NamedQueries()...)
+}
+
+// storeNamedQueries replaces the named queries by qs and publishes them.
+// namedQueriesMu must be held.
+func storeNamedQueries(qs []*NamedQuery) {
+	namedQueries.Store(&qs)
+	configVersion.Add(1)
+	publishFilterState()
+}
+
+// removeNamedQuery returns qs without the query called name.
+func removeNamedQuery(qs []*NamedQuery, name string) []*NamedQuery {
+	for i, q := range qs {
+		if q.Name == name {
+			return append(qs[:i], qs[i+1:]...)
+		}
+	}
+	return qs
+}
@@ -0,0 +1,104 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestNamedQueries(t *testing.T) {
+	t.Cleanup(ClearNamedQueries)
+
+	names := func() []string {
+		var out []string
+		for _, q := range NamedQueries() {
+			out = append(out, q.Name)
+		}
+		return out
+	}
+	app1 := attribute.NewMapTraceAttributeFilter()
+	SetNamedQuery(&NamedQuery{
+		Name:     "q2",
+		Flags:    AttributeFilter | LinkAttributeFilter,
+		Scoped:   map[string]attribute.TraceAttributeFilter{"app1": app1},
+		Patterns: [][]string{{"app1"}, {"app1", "app2"}},
+	})
+	SetNamedQuery(&NamedQuery{Name: "q1"})
+	SetNamedQuery(&NamedQuery{Name: "q3"})
+	assert.Equal(t, []string{"q1", "q2", "q3"}, names())
+
+	q, ok := LookupNamedQuery("q2")
+	require.True(t, ok)
+	assert.Equal(t, FilterConfigFlag(AttributeFilter), q.Flags, "unsupported flags should be ignored")
+	assert.Equal(t, [][]string{{"app1", "app2"}}, q.Patterns)
+	assert.Same(t, app1, q.FilterFor("app1", "lib"))
+	assert.Same(t, q.Filter, q.FilterFor("app2", "lib"))
+	assert.NotNil(t, q.Events)
+	assert.Len(t, q.Filters(), 2)
+
+	before := NamedQueries()
+	SetNamedQuery(&NamedQuery{Name: "q2", Flags: EventFilter})
+	q, _ = LookupNamedQuery("q2")
+	assert.Equal(t, FilterConfigFlag(EventFilter), q.Flags, "a query should replace the one with the same name")
+	assert.Equal(t, FilterConfigFlag(AttributeFilter), before[1].Flags, "the queries returned should not change")
+
+	RemoveNamedQuery("q1")
+	RemoveNamedQuery("missing")
+	assert.Equal(t, []string{"q2", "q3"}, names())
+	_, ok = LookupNamedQuery("q1")
+	assert.False(t, ok)
+}
+
+func TestInstallNamedQueryBounds(t *testing.T) {
+	t.Cleanup(ClearNamedQueries)
+
+	registered := func(name string) func() bool {
+		return func() bool {
+			_, ok := LookupNamedQuery(name)
+			return ok
+		}
+	}
+	require.NoError(t, InstallNamedQuery(&NamedQuery{Name: "ttl"}, QueryBounds{TTL: 10 * time.Millisecond}))
+	assert.True(t, registered("ttl")())
+	assert.Eventually(t, func() bool { return !registered("ttl")() }, time.Second, time.Millisecond, "the query should expire with its TTL")
+
+	require.NoError(t, InstallNamedQuery(&NamedQuery{Name: "start"}, QueryBounds{Start: time.Now().Add(20 * time.Millisecond)}))
+	assert.False(t, registered("start")(), "the query should wait for its start")
+	assert.Eventually(t, registered("start"), time.Second, time.Millisecond, "the query should be registered at its start")
+
+	require.NoError(t, InstallNamedQuery(&NamedQuery{Name: "budget"}, QueryBounds{MaxSpans: 2}))
+	q, ok := LookupNamedQuery("budget")
+	require.True(t, ok)
+	assert.True(t, q.TakeSpan())
+	assert.True(t, q.TakeSpan())
+	assert.False(t, q.TakeSpan(), "the budget should be spent")
+	assert.Eventually(t, func() bool { return !registered("budget")() }, time.Second, time.Millisecond, "the query should be removed with its budget")
+
+	require.NoError(t, InstallNamedQuery(&NamedQuery{Name: "replaced"}, QueryBounds{TTL: 10 * time.Millisecond}))
+	SetNamedQuery(&NamedQuery{Name: "replaced"})
+	require.NoError(t, InstallNamedQuery(&NamedQuery{Name: "removed"}, QueryBounds{Start: time.Now().Add(10 * time.Millisecond)}))
+	RemoveNamedQuery("removed")
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, registered("replaced")(), "the bounds of a replaced query should no longer apply")
+	assert.False(t, registered("removed")(), "a removed query should not be registered at its start")
+
+	assert.Error(t, InstallNamedQuery(&NamedQuery{Name: "past"}, QueryBounds{Start: time.Now().Add(-time.Hour), TTL: time.Minute}))
+}
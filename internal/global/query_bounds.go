@@ -0,0 +1,314 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global // import "go.opentelemetry.io/otel/internal/global"
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// QueryBounds bound the lifetime of a query. When the TTL or the span budget
+// of a query runs out, the configuration it replaced is restored.
+type QueryBounds struct {
+	// Start is the time the query is installed at, it is installed at once
+	// if Start is zero or past.
+	Start time.Time
+	// TTL is the duration the query stays installed, unbounded if zero.
+	TTL time.Duration
+	// MaxSpans is the number of spans the query exports before it is
+	// removed, unbounded if zero.
+	MaxSpans int64
+}
+
+// IsZero reports whether b bounds nothing.
+func (b QueryBounds) IsZero() bool {
+	return b.Start.IsZero() && b.TTL == 0 && b.MaxSpans == 0
+}
+
+// Query parameters of the bounds of a filter request.
+const (
+	startParam    = "start"
+	ttlParam      = "ttl"
+	maxSpansParam = "max_spans"
+)
+
+// requestBounds returns the bounds of the query parameters of a filter
+// request: start, an RFC 3339 time, ttl, a duration such as 10m, and
+// max_spans.
+func requestBounds(params url.Values) (QueryBounds, error) {
+	var b QueryBounds
+	var err error
+	if s := params.Get(startParam); s != "" {
+		if b.Start, err = time.Parse(time.RFC3339, s); err != nil {
+			return b, fmt.Errorf("invalid %s: %w", startParam, err)
+		}
+	}
+	if s := params.Get(ttlParam); s != "" {
+		if b.TTL, err = time.ParseDuration(s); err != nil {
+			return b, fmt.Errorf("invalid %s: %w", ttlParam, err)
+		}
+		if b.TTL <= 0 {
+			return b, fmt.Errorf("invalid %s: %s is not positive", ttlParam, s)
+		}
+	}
+	if s := params.Get(maxSpansParam); s != "" {
+		if b.MaxSpans, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return b, fmt.Errorf("invalid %s: %w", maxSpansParam, err)
+		}
+		if b.MaxSpans <= 0 {
+			return b, fmt.Errorf("invalid %s: %s is not positive", maxSpansParam, s)
+		}
+	}
+	return b, nil
+}
+
+// filterConfig is a copy of the whole filter configuration.
+type filterConfig struct {
+	flags    FilterConfigFlag
+	patterns [][]string
+	queryID  string
+
+	filter     attribute.Snapshot
+	scoped     map[string]attribute.Snapshot
+	events     attribute.Snapshot
+	eventAttrs map[string]attribute.Snapshot
+	links      attribute.Snapshot
+}
+
+// currentFilterConfig returns a copy of the current filter configuration.
+func currentFilterConfig() filterConfig {
+	return filterConfig{
+		flags:      FilterConfigFlags(),
+		patterns:   TraceStructuralPatterns(),
+		queryID:    QueryID(),
+		filter:     TraceAttributeFilter().Snapshot(),
+		scoped:     globalScopedFilters.snapshots(),
+		events:     TraceEventFilter().Snapshot(),
+		eventAttrs: globalEventAttributeFilters.snapshots(),
+		links:      TraceLinkAttributeFilter().Snapshot(),
+	}
+}
+
+// restore replaces the filter configuration by c, published at once.
+func (c filterConfig) restore() {
+	_ = batchFilterState(func() error {
+		TraceAttributeFilter().Restore(c.filter)
+		globalScopedFilters.restore(c.scoped)
+		TraceEventFilter().Restore(c.events)
+		globalEventAttributeFilters.restore(c.eventAttrs)
+		TraceLinkAttributeFilter().Restore(c.links)
+		SetTraceStructuralPatterns(c.patterns)
+		SetQueryID(c.queryID)
+		SetFilterConfigFlags(c.flags)
+		return nil
+	})
+}
+
+// boundedQuery is a query installed within bounds.
+type boundedQuery struct {
+	// previous is the configuration restored when the query is removed.
+	previous filterConfig
+	// timer installs the query at its start, then removes it at its expiry,
+	// it is nil without start nor TTL.
+	timer *time.Timer
+	// remaining is the number of spans the query may still export, if it
+	// has a span budget.
+	remaining atomic.Int64
+}
+
+var (
+	// boundedMu serializes the installation and the removal of the bounded
+	// queries. It is taken after controlMu.
+	boundedMu sync.Mutex
+	// bounded is the bounded query installed or waiting for its start, nil
+	// if there is none.
+	bounded *boundedQuery
+	// budgeted is the installed bounded query with a span budget, nil if
+	// there is none.
+	budgeted atomic.Pointer[boundedQuery]
+)
+
+// InstallQuery installs a query with install within bounds. With zero
+// bounds, the query is installed at once and for good. Otherwise the
+// configuration in place is restored when the TTL or the span budget of the
+// query runs out, and the query is only installed at the start of bounds.
+//
+// A query replaces the bounded query installed or waiting for its start, if
+// any. A bounded query replacing another one restores the configuration that
+// other one replaced, and the changes made to the filters while a bounded
+// query is installed are reverted with it. Nothing is replaced if install
+// fails, an install failing at the start of bounds is reported to the
+// ErrorHandler.
+func InstallQuery(bounds QueryBounds, install func() error) error {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	return installBounded(bounds, install)
+}
+
+// installBounded installs a query with install within bounds, see
+// InstallQuery. controlMu must be held.
+func installBounded(bounds QueryBounds, install func() error) error {
+	boundedMu.Lock()
+	defer boundedMu.Unlock()
+	// The FilterState is published once the query is installed, see
+	// batchFilterState.
+	return batchFilterState(func() error {
+		return installQuery(bounds, install)
+	})
+}
+
+// installQuery installs a query with install within bounds, see
+// InstallQuery. controlMu and boundedMu must be held.
+func installQuery(bounds QueryBounds, install func() error) error {
+	previous := currentFilterConfig()
+	if bounded != nil {
+		previous = bounded.previous
+	}
+	if bounds.IsZero() {
+		if err := install(); err != nil {
+			return err
+		}
+		cancelBounded()
+		return nil
+	}
+
+	q := &boundedQuery{previous: previous}
+	if wait := time.Until(bounds.Start); wait > 0 {
+		if bounded != nil {
+			// Nothing is installed until the start.
+			cancelBounded()
+			previous.restore()
+		}
+		bounded = q
+		q.timer = time.AfterFunc(wait, func() {
+			controlMu.Lock()
+			defer controlMu.Unlock()
+			boundedMu.Lock()
+			defer boundedMu.Unlock()
+			if bounded != q {
+				return
+			}
+			bounded = nil
+			_ = batchFilterState(func() error {
+				if err := install(); err != nil {
+					q.previous.restore()
+					Handle(fmt.Errorf("bounded query: %w", err))
+					return nil
+				}
+				q.arm(bounds)
+				return nil
+			})
+		})
+		return nil
+	}
+	if bounds.TTL > 0 && !bounds.Start.IsZero() && !time.Now().Before(bounds.Start.Add(bounds.TTL)) {
+		return errors.New("query expired before its installation")
+	}
+	if err := install(); err != nil {
+		return err
+	}
+	cancelBounded()
+	q.arm(bounds)
+	return nil
+}
+
+// cancelBounded forgets the bounded query, which will not be removed nor
+// installed. boundedMu must be held.
+func cancelBounded() {
+	if bounded == nil {
+		return
+	}
+	bounded.stop()
+	bounded = nil
+	budgeted.Store(nil)
+	publishFilterState()
+}
+
+// arm makes q the installed bounded query and arms its bounds. The TTL runs
+// from the start of bounds if set. boundedMu must be held.
+func (q *boundedQuery) arm(bounds QueryBounds) {
+	bounded = q
+	q.timer = nil
+	if bounds.TTL > 0 {
+		ttl := bounds.TTL
+		if !bounds.Start.IsZero() {
+			ttl = time.Until(bounds.Start.Add(bounds.TTL))
+		}
+		q.timer = time.AfterFunc(ttl, q.expire)
+	}
+	if bounds.MaxSpans > 0 {
+		q.remaining.Store(bounds.MaxSpans)
+		budgeted.Store(q)
+		publishFilterState()
+	}
+}
+
+// stop stops the start or the expiry timer of q.
+func (q *boundedQuery) stop() {
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+}
+
+// expire removes q if it is still installed, restoring the configuration it
+// replaced.
+func (q *boundedQuery) expire() {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	boundedMu.Lock()
+	defer boundedMu.Unlock()
+	if bounded != q {
+		return
+	}
+	q.stop()
+	bounded = nil
+	budgeted.CompareAndSwap(q, nil)
+	Debug("bounded query expired, restoring the previous filter configuration")
+	q.previous.restore()
+}
+
+// TakeQuerySpan reports whether a span selected by the query installed may
+// be exported: the query has no span budget or its budget is not spent. The
+// query is removed in the background when the last span of its budget is
+// taken.
+func TakeQuerySpan() bool {
+	return budgeted.Load().take()
+}
+
+// take reports whether a span selected by q may be exported, see
+// TakeQuerySpan. A nil q has no span budget.
+func (q *boundedQuery) take() bool {
+	if q == nil {
+		return true
+	}
+	left := q.remaining.Add(-1)
+	if left == 0 {
+		// The export path does not wait for the configuration to be
+		// restored, the spent budget already stops the spans.
+		go q.expire()
+	}
+	return left >= 0
+}
+
+// errBoundedRequest is returned for the bounds of a request not replacing a
+// configuration.
+var errBoundedRequest = errors.New("bounds are only supported by update and query requests")
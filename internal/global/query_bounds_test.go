@@ -0,0 +1,162 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// resetFilterConfig restores the filter configuration at the end of t.
+func resetFilterConfig(t *testing.T) {
+	config := currentFilterConfig()
+	t.Cleanup(func() {
+		boundedMu.Lock()
+		cancelBounded()
+		boundedMu.Unlock()
+		config.restore()
+	})
+}
+
+// installApp installs a query on the app table with key a.
+func installApp(app string, flags FilterConfigFlag) func() error {
+	return func() error {
+		ClearScopedTraceAttributeFilters()
+		ScopedTraceAttributeFilter(app).AddKeyMatch("a")
+		SetQueryID(app)
+		SetFilterConfigFlags(flags)
+		return nil
+	}
+}
+
+func TestInstallQueryTTL(t *testing.T) {
+	resetFilterConfig(t)
+	require.NoError(t, InstallQuery(QueryBounds{}, installApp("app1", AttributeFilter)))
+	TraceEventFilter().AddKeyMatch("exception")
+
+	require.NoError(t, InstallQuery(QueryBounds{TTL: 20 * time.Millisecond}, installApp("app2", AttributeFilter|EventFilter)))
+	assert.Equal(t, "app2", QueryID())
+	TraceEventFilter().Clear()
+	assert.Eventually(t, func() bool { return QueryID() == "app1" }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"app1"}, ScopedTraceAttributeFilterTables())
+	assert.True(t, ScopedTraceAttributeFilter("app1").Match("a", attribute.StringValue("")))
+	assert.True(t, TraceEventFilter().Match("exception", attribute.InvalidValue()), "changes made during the query should be reverted")
+	assert.Equal(t, FilterConfigFlag(AttributeFilter), FilterConfigFlags())
+}
+
+func TestInstallQueryReplaced(t *testing.T) {
+	resetFilterConfig(t)
+	require.NoError(t, InstallQuery(QueryBounds{}, installApp("app1", AttributeFilter)))
+
+	require.NoError(t, InstallQuery(QueryBounds{MaxSpans: 1}, installApp("app2", AttributeFilter)))
+	require.NoError(t, InstallQuery(QueryBounds{MaxSpans: 2}, installApp("app3", AttributeFilter)))
+	assert.Error(t, InstallQuery(QueryBounds{MaxSpans: 1}, func() error { return errors.New("invalid") }))
+	assert.Equal(t, "app3", QueryID(), "a failed install should replace nothing")
+
+	assert.True(t, TakeQuerySpan())
+	assert.Equal(t, "app3", QueryID())
+	assert.True(t, TakeQuerySpan())
+	assert.Eventually(t, func() bool { return QueryID() == "app1" }, time.Second, time.Millisecond, "the configuration before the bounded queries should be restored")
+	assert.True(t, TakeQuerySpan())
+
+	require.NoError(t, InstallQuery(QueryBounds{MaxSpans: 1}, installApp("app2", AttributeFilter)))
+	require.NoError(t, InstallQuery(QueryBounds{}, installApp("app3", AttributeFilter)))
+	assert.True(t, TakeQuerySpan())
+	assert.True(t, TakeQuerySpan(), "an unbounded query should replace the bounded one")
+	assert.Equal(t, "app3", QueryID())
+}
+
+func TestTakeQuerySpanExpiresInBackground(t *testing.T) {
+	resetFilterConfig(t)
+	require.NoError(t, InstallQuery(QueryBounds{}, installApp("app1", AttributeFilter)))
+	require.NoError(t, InstallQuery(QueryBounds{MaxSpans: 1}, installApp("app2", AttributeFilter)))
+
+	// An installation in progress holds boundedMu.
+	boundedMu.Lock()
+	taken := make(chan bool)
+	go func() { taken <- TakeQuerySpan() }()
+	select {
+	case ok := <-taken:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("taking the last span of the budget should not wait for the query removal")
+	}
+	assert.Equal(t, "app2", QueryID())
+	boundedMu.Unlock()
+	assert.Eventually(t, func() bool { return QueryID() == "app1" }, time.Second, time.Millisecond)
+}
+
+func TestInstallQueryStart(t *testing.T) {
+	resetFilterConfig(t)
+	require.NoError(t, InstallQuery(QueryBounds{}, installApp("app1", AttributeFilter)))
+
+	start := time.Now().Add(30 * time.Millisecond)
+	require.NoError(t, InstallQuery(QueryBounds{Start: start, TTL: 100 * time.Millisecond}, installApp("app2", AttributeFilter)))
+	assert.Equal(t, "app1", QueryID())
+	assert.Eventually(t, func() bool { return QueryID() == "app2" }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return QueryID() == "app1" }, time.Second, 5*time.Millisecond)
+
+	past := time.Now().Add(-time.Hour)
+	assert.Error(t, InstallQuery(QueryBounds{Start: past, TTL: time.Minute}, installApp("app2", AttributeFilter)), "an expired query should not be installed")
+	assert.Equal(t, "app1", QueryID())
+}
+
+func TestHandleRequestBounds(t *testing.T) {
+	resetFilterConfig(t)
+	require.NoError(t, InstallQuery(QueryBounds{}, installApp("app1", AttributeFilter)))
+
+	f := newTraceAttributeFilter()
+	req := httptest.NewRequest("POST", "/?op=query&max_spans=1", strings.NewReader(`{
+		"app2": {"filters": [{"key": "b", "type": "", "values": []}]}
+	}`))
+	require.NoError(t, f.HandleRequest(req))
+	assert.Equal(t, []string{"app2"}, ScopedTraceAttributeFilterTables())
+	assert.True(t, TakeQuerySpan())
+	assert.Eventually(t, func() bool {
+		tables := ScopedTraceAttributeFilterTables()
+		return len(tables) == 1 && tables[0] == "app1"
+	}, time.Second, time.Millisecond)
+
+	start := time.Now().Add(time.Hour).Format(time.RFC3339)
+	req = httptest.NewRequest("POST", "/?op=update&table=app3&start="+start, strings.NewReader(`{"filters": []}`))
+	require.NoError(t, f.HandleRequest(req))
+	assert.Equal(t, []string{"app1"}, ScopedTraceAttributeFilterTables(), "a scheduled request should change nothing")
+
+	for _, target := range []string{
+		"/?op=update&ttl=soon",
+		"/?op=update&ttl=-1m",
+		"/?op=update&max_spans=0",
+		"/?op=update&start=tomorrow",
+		"/?op=clear&ttl=1m",
+	} {
+		req = httptest.NewRequest("POST", target, strings.NewReader(`{"filters": []}`))
+		assert.Error(t, f.HandleRequest(req), target)
+	}
+
+	rec := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPatch, "/?table=app1&start="+start, strings.NewReader(`{"filters": [{"key": "c", "type": "", "values": []}]}`))
+	FilterControlHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.False(t, ScopedTraceAttributeFilter("app1").Match("c", attribute.StringValue("")))
+}
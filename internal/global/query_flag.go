@@ -6,6 +6,19 @@ const (
 	AttributeNotMatchFullTraceFilter FilterConfigFlag = 1 << iota
 	AttributeFilter                                   = 1 << 1
 	StructuralTraceFilter                             = 1 << 2
+	// EventFilter only exports the span events whose name matches the
+	// TraceEventFilter.
+	EventFilter = 1 << 3
+	// EventAttributeFilter only exports the attributes of the span events
+	// matching their TraceEventAttributeFilter.
+	EventAttributeFilter = 1 << 4
+	// LinkAttributeFilter only exports the attributes of the span links
+	// matching the TraceLinkAttributeFilter.
+	LinkAttributeFilter = 1 << 5
+	// PropagatedSelectionFilter keeps the spans of the requests selected by
+	// the active query in an upstream service, and drops the ones an
+	// upstream service did not select, whatever the other filters.
+	PropagatedSelectionFilter = 1 << 6
 )
 
 func (f FilterConfigFlag) WithFullTraceFilter() FilterConfigFlag {
@@ -19,3 +32,19 @@ func (f FilterConfigFlag) WithAttributeFilter() FilterConfigFlag {
 func (f FilterConfigFlag) WithStructuralTraceFilter() FilterConfigFlag {
 	return f | StructuralTraceFilter
 }
+
+func (f FilterConfigFlag) WithEventFilter() FilterConfigFlag {
+	return f | EventFilter
+}
+
+func (f FilterConfigFlag) WithEventAttributeFilter() FilterConfigFlag {
+	return f | EventAttributeFilter
+}
+
+func (f FilterConfigFlag) WithLinkAttributeFilter() FilterConfigFlag {
+	return f | LinkAttributeFilter
+}
+
+func (f FilterConfigFlag) WithPropagatedSelectionFilter() FilterConfigFlag {
+	return f | PropagatedSelectionFilter
+}
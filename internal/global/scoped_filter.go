@@ -0,0 +1,193 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global // import "go.opentelemetry.io/otel/internal/global"
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// scopedTraceAttributeFilters holds TraceAttributeFilters by name, e.g. the
+// filters scoped to a table of a query. The filter of a table applies to the
+// spans of the service (or instrumentation scope) with the same name, so that
+// a query on several services can be installed as is on all of them. The
+// filters are looked up without locking, the map is replaced on every change.
+type scopedTraceAttributeFilters struct {
+	// mu serializes the changes of the filters.
+	mu      sync.Mutex
+	filters atomic.Pointer[map[string]*traceAttributeFilter]
+}
+
+var globalScopedFilters = newScopedTraceAttributeFilters()
+
+// newScopedTraceAttributeFilters returns an empty scopedTraceAttributeFilters.
+func newScopedTraceAttributeFilters() *scopedTraceAttributeFilters {
+	s := &scopedTraceAttributeFilters{}
+	s.filters.Store(&map[string]*traceAttributeFilter{})
+	return s
+}
+
+// ScopedTraceAttributeFilter returns the TraceAttributeFilter scoped to
+// table, creating an empty one if it does not exist.
+func ScopedTraceAttributeFilter(table string) attribute.TraceAttributeFilter {
+	return globalScopedFilters.get(table)
+}
+
+// ScopedTraceAttributeFilterTables returns the sorted tables having a scoped
+// TraceAttributeFilter.
+func ScopedTraceAttributeFilterTables() []string {
+	return globalScopedFilters.names()
+}
+
+// RemoveScopedTraceAttributeFilter removes the TraceAttributeFilter scoped
+// to table.
+func RemoveScopedTraceAttributeFilter(table string) {
+	globalScopedFilters.remove(table)
+}
+
+// ClearScopedTraceAttributeFilters removes all the scoped
+// TraceAttributeFilters.
+func ClearScopedTraceAttributeFilters() {
+	globalScopedFilters.clear()
+}
+
+// TraceAttributeFilterFor returns the TraceAttributeFilter to apply to the
+// spans of service, with the instrumentation scope named scope: the filter
+// scoped to service if any, else the one scoped to scope if any, else the
+// global TraceAttributeFilter.
+func TraceAttributeFilterFor(service, scope string) attribute.TraceAttributeFilter {
+	filters := globalScopedFilters.all()
+	if f, ok := filters[service]; ok {
+		return f
+	}
+	if f, ok := filters[scope]; ok {
+		return f
+	}
+	return TraceAttributeFilter()
+}
+
+// TraceAttributeFilters returns the global TraceAttributeFilter followed by
+// the scoped ones, all the filters that TraceAttributeFilterFor may return.
+func TraceAttributeFilters() []attribute.TraceAttributeFilter {
+	scoped := globalScopedFilters.all()
+	filters := make([]attribute.TraceAttributeFilter, 0, len(scoped)+1)
+	filters = append(filters, TraceAttributeFilter())
+	for _, f := range scoped {
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// names returns the sorted names of the filters.
+func (s *scopedTraceAttributeFilters) names() []string {
+	filters := s.all()
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// all returns the filters by name, the map must not be modified.
+func (s *scopedTraceAttributeFilters) all() map[string]*traceAttributeFilter {
+	return *s.filters.Load()
+}
+
+// update replaces the filters by the ones change leaves in a copy of them.
+func (s *scopedTraceAttributeFilters) update(change func(map[string]*traceAttributeFilter)) {
+	s.mu.Lock()
+	filters := make(map[string]*traceAttributeFilter, len(s.all())+1)
+	for name, f := range s.all() {
+		filters[name] = f
+	}
+	change(filters)
+	s.filters.Store(&filters)
+	configVersion.Add(1)
+	s.mu.Unlock()
+	publishFilterState()
+}
+
+func (s *scopedTraceAttributeFilters) remove(name string) {
+	s.update(func(filters map[string]*traceAttributeFilter) {
+		delete(filters, name)
+	})
+}
+
+func (s *scopedTraceAttributeFilters) clear() {
+	s.update(func(filters map[string]*traceAttributeFilter) {
+		for name := range filters {
+			delete(filters, name)
+		}
+	})
+}
+
+// snapshots returns the snapshots of the filters by name.
+func (s *scopedTraceAttributeFilters) snapshots() map[string]attribute.Snapshot {
+	snapshots := make(map[string]attribute.Snapshot)
+	for name, f := range s.all() {
+		snapshots[name] = f.Snapshot()
+	}
+	return snapshots
+}
+
+// restore replaces the filters by ones restored from snapshots.
+func (s *scopedTraceAttributeFilters) restore(snapshots map[string]attribute.Snapshot) {
+	s.update(func(filters map[string]*traceAttributeFilter) {
+		for name := range filters {
+			delete(filters, name)
+		}
+		for name, snapshot := range snapshots {
+			taf := attribute.NewMapTraceAttributeFilter()
+			taf.Restore(snapshot)
+			filters[name] = newFilter(taf)
+		}
+	})
+}
+
+// lookup returns the filter scoped to table, if any.
+func (s *scopedTraceAttributeFilters) lookup(table string) (*traceAttributeFilter, bool) {
+	f, ok := s.all()[table]
+	return f, ok
+}
+
+func (s *scopedTraceAttributeFilters) get(table string) *traceAttributeFilter {
+	if f, ok := s.lookup(table); ok {
+		return f
+	}
+
+	var f *traceAttributeFilter
+	s.update(func(filters map[string]*traceAttributeFilter) {
+		var ok bool
+		if f, ok = filters[table]; !ok {
+			f = newFilter(attribute.NewMapTraceAttributeFilter())
+			filters[table] = f
+		}
+	})
+	return f
+}
+
+// published returns the filters published by the filters, by name.
+func (s *scopedTraceAttributeFilters) published() map[string]attribute.TraceAttributeFilter {
+	filters := s.all()
+	published := make(map[string]attribute.TraceAttributeFilter, len(filters))
+	for name, f := range filters {
+		published[name] = f.filter()
+	}
+	return published
+}
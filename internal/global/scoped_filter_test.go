@@ -0,0 +1,64 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package global
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestTraceAttributeFilterFor(t *testing.T) {
+	t.Cleanup(ClearScopedTraceAttributeFilters)
+
+	service := ScopedTraceAttributeFilter("app1")
+	scope := ScopedTraceAttributeFilter("lib")
+	assert.Same(t, service, ScopedTraceAttributeFilter("app1"))
+
+	assert.Same(t, service, TraceAttributeFilterFor("app1", "lib"))
+	assert.Same(t, scope, TraceAttributeFilterFor("app2", "lib"))
+	assert.Same(t, TraceAttributeFilter(), TraceAttributeFilterFor("app2", "other"))
+	assert.Equal(t, []string{"app1", "lib"}, ScopedTraceAttributeFilterTables())
+
+	RemoveScopedTraceAttributeFilter("app1")
+	assert.Same(t, scope, TraceAttributeFilterFor("app1", "lib"))
+}
+
+func TestHandleRequestScoped(t *testing.T) {
+	t.Cleanup(ClearScopedTraceAttributeFilters)
+
+	f := newTraceAttributeFilter()
+	req := httptest.NewRequest("POST", "/?op=update&table=app1", strings.NewReader(`{
+		"filters": [{"key": "a", "type": "", "values": []}]
+	}`))
+	require.NoError(t, f.HandleRequest(req))
+	assert.False(t, f.Match("a", attribute.StringValue("")), "global filter should not be updated")
+	assert.True(t, ScopedTraceAttributeFilter("app1").Match("a", attribute.StringValue("")))
+
+	req = httptest.NewRequest("POST", "/?op=query", strings.NewReader(`{
+		"app2": {"filters": [], "where": {"key": "b", "type": "int64", "values": [1]}},
+		"app3": {"filters": [{"key": "c", "type": "", "values": []}]}
+	}`))
+	require.NoError(t, f.HandleRequest(req))
+	assert.Equal(t, []string{"app2", "app3"}, ScopedTraceAttributeFilterTables())
+	assert.True(t, notMatched(ScopedTraceAttributeFilter("app2"), attribute.Int("b", 2)))
+	assert.False(t, notMatched(ScopedTraceAttributeFilter("app2"), attribute.Int("b", 1)))
+	assert.True(t, ScopedTraceAttributeFilter("app3").Match("c", attribute.StringValue("")))
+}
@@ -41,9 +41,21 @@ type (
 		tef attribute.TraceAttributeFilter
 	}
 
+	traceLinkFilterHolder struct {
+		tlf attribute.TraceAttributeFilter
+	}
+
 	filterConfigFlagsHolder struct {
 		filterConfigFlag FilterConfigFlag
 	}
+
+	structuralPatternsHolder struct {
+		patterns [][]string
+	}
+
+	queryIDHolder struct {
+		id string
+	}
 )
 
 var (
@@ -52,6 +64,9 @@ var (
 	globalAttributeFilter   = defaultAttributeFilterValue()
 	globalFilterConfigFlags = defaultFilterConfigFlagsValue()
 	globalEventFilter       = defaultEventFilterValue()
+	globalLinkFilter        = defaultLinkFilterValue()
+	globalStructuralPattern = defaultStructuralPatternsValue()
+	globalQueryID           = defaultQueryIDValue()
 
 	delegateTraceOnce             sync.Once
 	delegateTextMapPropagatorOnce sync.Once
@@ -124,23 +139,64 @@ func TraceAttributeFilter() attribute.TraceAttributeFilter {
 	return globalAttributeFilter.Load().(traceAttributeFilterHolder).taf
 }
 
-// TraceEventFilter is the internal implementation for global.TraceAttributeFilter, but only contains event names.
+// TraceEventFilter is the internal implementation for global.TraceEventFilter.
+// Its key matches are the names of the span events exported when the
+// EventFilter is enabled.
 func TraceEventFilter() attribute.TraceAttributeFilter {
-	return globalEventFilter.Load().(traceAttributeFilterHolder).taf
+	return globalEventFilter.Load().(traceEventFilterHolder).tef
 }
 
-// SetTraceAttributeFilter is the internal implementation for global.SetTraceAttributeFilter.
-// TODO: Currently no need to delegate the traceAttributeFilter, no implementation.
-func SetTraceAttributeFilter(taf attribute.TraceAttributeFilter) {
-	return
+// TraceLinkAttributeFilter returns the TraceAttributeFilter projecting the
+// attributes of the span links when the LinkAttributeFilter is enabled.
+func TraceLinkAttributeFilter() attribute.TraceAttributeFilter {
+	return globalLinkFilter.Load().(traceLinkFilterHolder).tlf
 }
 
+// SetTraceAttributeFilter is the internal implementation for the deprecated
+// otel.SetTraceAttributeFilter. It does nothing, the global
+// TraceAttributeFilter cannot be replaced.
+func SetTraceAttributeFilter(attribute.TraceAttributeFilter) {}
+
 func FilterConfigFlags() FilterConfigFlag {
 	return globalFilterConfigFlags.Load().(filterConfigFlagsHolder).filterConfigFlag
 }
 
 func SetFilterConfigFlags(filterConfigFlag FilterConfigFlag) {
 	globalFilterConfigFlags.Store(filterConfigFlagsHolder{filterConfigFlag: filterConfigFlag})
+	publishFilterState()
+}
+
+// TraceStructuralPatterns returns the caller->callee service chains used by
+// the StructuralTraceFilter. The returned slice must not be modified.
+func TraceStructuralPatterns() [][]string {
+	return globalStructuralPattern.Load().(structuralPatternsHolder).patterns
+}
+
+// SetTraceStructuralPatterns replaces the caller->callee service chains used
+// by the StructuralTraceFilter. Patterns with less than two services are
+// ignored, since they do not describe a call.
+func SetTraceStructuralPatterns(patterns [][]string) {
+	cp := make([][]string, 0, len(patterns))
+	for _, p := range patterns {
+		if len(p) < 2 {
+			continue
+		}
+		cp = append(cp, append([]string(nil), p...))
+	}
+	globalStructuralPattern.Store(structuralPatternsHolder{patterns: cp})
+	publishFilterState()
+}
+
+// QueryID returns the identity of the active query, empty if none is set.
+func QueryID() string {
+	return globalQueryID.Load().(queryIDHolder).id
+}
+
+// SetQueryID sets the identity of the active query, carried across services
+// with the selection of the requests by the query propagator.
+func SetQueryID(id string) {
+	globalQueryID.Store(queryIDHolder{id: id})
+	publishFilterState()
 }
 
 func defaultTracerValue() *atomic.Value {
@@ -167,8 +223,26 @@ func defaultEventFilterValue() *atomic.Value {
 	return v
 }
 
+func defaultLinkFilterValue() *atomic.Value {
+	v := &atomic.Value{}
+	v.Store(traceLinkFilterHolder{tlf: newTraceLinkFilter()})
+	return v
+}
+
 func defaultFilterConfigFlagsValue() *atomic.Value {
 	v := &atomic.Value{}
 	v.Store(filterConfigFlagsHolder{filterConfigFlag: 0})
 	return v
 }
+
+func defaultStructuralPatternsValue() *atomic.Value {
+	v := &atomic.Value{}
+	v.Store(structuralPatternsHolder{patterns: [][]string{}})
+	return v
+}
+
+func defaultQueryIDValue() *atomic.Value {
+	v := &atomic.Value{}
+	v.Store(queryIDHolder{})
+	return v
+}
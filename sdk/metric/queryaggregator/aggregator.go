@@ -0,0 +1,394 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queryaggregator computes aggregate trace queries in process, e.g.
+//
+//	SELECT COUNT(*), AVG(@duration) FROM checkout
+//	WHERE http.status_code >= 500 GROUP BY http.route
+//
+// from the spans as they end, and reports their results as metrics, so that
+// only the aggregates leave the process.
+package queryaggregator // import "go.opentelemetry.io/otel/sdk/metric/queryaggregator"
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/sdk/queryparser"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// counter counts the spans of an aggregate COUNT.
+type counter struct {
+	agg     queryparser.Aggregate
+	counter instrument.Int64Counter
+}
+
+// histogram records the values of a column aggregated by SUM, AVG, MIN or
+// MAX.
+type histogram struct {
+	table     string
+	column    string
+	histogram instrument.Float64Histogram
+}
+
+// maxPercentileSamples is the maximum number of values of a group kept
+// between two collections to compute a percentile, the values beyond it are
+// sampled.
+const maxPercentileSamples = 4096
+
+// percentile computes a PERCENTILE aggregate over the values of its column
+// recorded since the last collection, by group.
+type percentile struct {
+	table  string
+	column string
+	p      float64
+	gauge  instrument.Float64ObservableGauge
+
+	mu     sync.Mutex
+	groups map[attribute.Distinct]*samples
+}
+
+// samples are the values of a group recorded since the last collection.
+type samples struct {
+	group  []attribute.KeyValue
+	values []float64
+	// seen is the number of values recorded, len(values) until
+	// maxPercentileSamples.
+	seen int64
+}
+
+// aggregator is a SpanProcessor computing an aggregate query.
+type aggregator struct {
+	// conditions are the conditions of the tables, the zero Condition for a
+	// table without condition.
+	conditions  map[string]attribute.Condition
+	groupBy     []attribute.Key
+	counters    []counter
+	histograms  []histogram
+	percentiles []*percentile
+	reg         metric.Registration
+}
+
+var _ sdktrace.SpanProcessor = (*aggregator)(nil)
+
+// NewSpanProcessor returns a SpanProcessor computing the aggregate query q,
+// see queryparser.Query.Aggregates, from the spans as they end, and
+// reporting its results with instruments of meter named after name:
+//
+//   - A COUNT is a counter, name.count for COUNT(*) and
+//     name.count.column for COUNT(column).
+//   - The SUM, AVG, MIN and MAX of a column are read from the histogram
+//     name.column of its values, shared by the aggregates of the column.
+//   - PERCENTILE(column, p) is a gauge, name.column.pP, e.g.
+//     name.duration.p99, reporting the p-th percentile (nearest rank) of the
+//     values recorded since the previous collection. Beyond 4096 values per
+//     group between two collections, it is computed over a uniform sample of
+//     them.
+//
+// An aggregate named with AS is reported by the instrument name.alias
+// instead. The measurements carry the attributes of the GROUP BY clause the
+// span has. The spans of the services (or instrumentation scopes) of the
+// tables of q satisfying the condition of their table are aggregated,
+// columns being their attributes or their intrinsic columns, the duration
+// being reported in milliseconds, e.g. AVG(@duration) by the histogram
+// name.duration. The JOIN conditions of q are not supported.
+func NewSpanProcessor(meter metric.Meter, name string, q *queryparser.Query) (sdktrace.SpanProcessor, error) {
+	if len(q.Aggregates) == 0 {
+		return nil, fmt.Errorf("queryaggregator: query %s has no aggregate", name)
+	}
+	if len(q.Join) > 0 {
+		return nil, fmt.Errorf("queryaggregator: query %s: JOIN is not supported", name)
+	}
+	a := &aggregator{conditions: make(map[string]attribute.Condition, len(q.From))}
+	for _, table := range q.From {
+		where, err := q.TableCondition(table)
+		if err != nil {
+			return nil, err
+		}
+		var cond attribute.Condition
+		if where != nil {
+			if cond, err = where.AttributeCondition(); err != nil {
+				return nil, err
+			}
+		}
+		a.conditions[table] = cond
+	}
+	for _, key := range q.GroupBy {
+		a.groupBy = append(a.groupBy, attribute.Key(key))
+	}
+
+	histograms := make(map[string]int)
+	for _, agg := range q.Aggregates {
+		desc := fmt.Sprintf("%s of query %s", aggregateString(agg), name)
+		if agg.Func == queryparser.AggCount {
+			iname := name + ".count"
+			if agg.Column != "*" {
+				iname += "." + columnName(agg.Column)
+			}
+			if agg.Alias != "" {
+				iname = name + "." + agg.Alias
+			}
+			c, err := meter.Int64Counter(iname, instrument.WithDescription(desc), instrument.WithUnit("{span}"))
+			if err != nil {
+				return nil, err
+			}
+			a.counters = append(a.counters, counter{agg: agg, counter: c})
+			continue
+		}
+		if agg.Func == queryparser.AggPercentile {
+			iname := fmt.Sprintf("%s.%s.p%s", name, columnName(agg.Column), strings.ReplaceAll(fmt.Sprintf("%g", agg.Percentile), ".", "_"))
+			if agg.Alias != "" {
+				iname = name + "." + agg.Alias
+			}
+			opts := []instrument.Float64ObservableGaugeOption{instrument.WithDescription(desc)}
+			if attribute.Key(agg.Column) == attribute.SpanDurationKey {
+				opts = append(opts, instrument.WithUnit("ms"))
+			}
+			g, err := meter.Float64ObservableGauge(iname, opts...)
+			if err != nil {
+				return nil, err
+			}
+			a.percentiles = append(a.percentiles, &percentile{
+				table:  agg.Table,
+				column: agg.Column,
+				p:      agg.Percentile,
+				gauge:  g,
+				groups: make(map[attribute.Distinct]*samples),
+			})
+			continue
+		}
+		iname := name + "." + columnName(agg.Column)
+		if agg.Alias != "" {
+			iname = name + "." + agg.Alias
+		}
+		if i, ok := histograms[iname]; ok {
+			if h := a.histograms[i]; h.table != agg.Table || h.column != agg.Column {
+				return nil, fmt.Errorf("queryaggregator: query %s: %s is the name of several columns", name, iname)
+			}
+			continue
+		}
+		var opts []instrument.Float64HistogramOption
+		if attribute.Key(agg.Column) == attribute.SpanDurationKey {
+			opts = append(opts, instrument.WithUnit("ms"))
+		}
+		h, err := meter.Float64Histogram(iname, append(opts, instrument.WithDescription(desc))...)
+		if err != nil {
+			return nil, err
+		}
+		histograms[iname] = len(a.histograms)
+		a.histograms = append(a.histograms, histogram{table: agg.Table, column: agg.Column, histogram: h})
+	}
+	if len(a.percentiles) > 0 {
+		gauges := make([]instrument.Observable, len(a.percentiles))
+		for i, p := range a.percentiles {
+			gauges[i] = p.gauge
+		}
+		reg, err := meter.RegisterCallback(a.observe, gauges...)
+		if err != nil {
+			return nil, err
+		}
+		a.reg = reg
+	}
+	return a, nil
+}
+
+// aggregateString returns the SQL form of agg.
+func aggregateString(agg queryparser.Aggregate) string {
+	arg := agg.Column
+	if agg.Table != "" {
+		arg = agg.Table + "." + arg
+	}
+	if agg.Func == queryparser.AggPercentile {
+		arg += fmt.Sprintf(", %g", agg.Percentile)
+	}
+	return agg.Func + "(" + arg + ")"
+}
+
+// OnStart does nothing.
+func (a *aggregator) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+// OnEnd aggregates s if it is selected by the query.
+func (a *aggregator) OnEnd(s sdktrace.ReadOnlySpan) {
+	table, ok := a.table(s)
+	if !ok {
+		return
+	}
+	// The intrinsics are appended to a copy of the attributes of s.
+	attrs := append(append([]attribute.KeyValue(nil), s.Attributes()...), sdktrace.SpanIntrinsics(s)...)
+	if cond := a.conditions[table]; !cond.IsZero() && !cond.Evaluate(attrs) {
+		return
+	}
+
+	var group []attribute.KeyValue
+	for _, key := range a.groupBy {
+		if v, ok := lookup(attrs, key); ok {
+			group = append(group, attribute.KeyValue{Key: key, Value: v})
+		}
+	}
+	ctx := context.Background()
+	for _, c := range a.counters {
+		if c.agg.Table != "" && c.agg.Table != table {
+			continue
+		}
+		if hasColumn(attrs, c.agg.Column) {
+			c.counter.Add(ctx, 1, group...)
+		}
+	}
+	for _, h := range a.histograms {
+		if h.table != table {
+			continue
+		}
+		if v, ok := columnValue(attrs, h.column); ok {
+			h.histogram.Record(ctx, v, group...)
+		}
+	}
+	for _, p := range a.percentiles {
+		if p.table != table {
+			continue
+		}
+		if v, ok := columnValue(attrs, p.column); ok {
+			p.record(v, group)
+		}
+	}
+}
+
+// record records v, a value of the column of p of a span of group.
+func (p *percentile) record(v float64, group []attribute.KeyValue) {
+	set := attribute.NewSet(group...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := p.groups[set.Equivalent()]
+	if !ok {
+		g = &samples{group: group}
+		p.groups[set.Equivalent()] = g
+	}
+	g.seen++
+	if len(g.values) < maxPercentileSamples {
+		g.values = append(g.values, v)
+	} else if i := rand.Int63n(g.seen); i < maxPercentileSamples {
+		// Reservoir sampling keeps a uniform sample of the values.
+		g.values[i] = v
+	}
+}
+
+// observe reports the percentiles of the values recorded since the previous
+// collection, and forgets them.
+func (a *aggregator) observe(_ context.Context, o metric.Observer) error {
+	for _, p := range a.percentiles {
+		p.mu.Lock()
+		groups := p.groups
+		p.groups = make(map[attribute.Distinct]*samples, len(groups))
+		p.mu.Unlock()
+
+		for _, g := range groups {
+			o.ObserveFloat64(p.gauge, nearestRank(g.values, p.p), g.group...)
+		}
+	}
+	return nil
+}
+
+// nearestRank returns the p-th percentile of values, p in (0, 100], by the
+// nearest-rank method. It sorts values.
+func nearestRank(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
+
+// table returns the table of the query s belongs to: the one named after its
+// service, or else after its instrumentation scope.
+func (a *aggregator) table(s sdktrace.ReadOnlySpan) (string, bool) {
+	if r := s.Resource(); r != nil {
+		if v, ok := r.Set().Value(semconv.ServiceNameKey); ok {
+			if _, ok := a.conditions[v.AsString()]; ok {
+				return v.AsString(), true
+			}
+		}
+	}
+	scope := s.InstrumentationScope().Name
+	_, ok := a.conditions[scope]
+	return scope, ok
+}
+
+// columnName returns the name of column in the instrument names, the
+// intrinsic columns without their "@" prefix.
+func columnName(column string) string {
+	return strings.TrimPrefix(column, "@")
+}
+
+// hasColumn reports whether the span with attrs, its attributes and
+// intrinsics, has column, any span has the "*" column.
+func hasColumn(attrs []attribute.KeyValue, column string) bool {
+	if column == "*" {
+		return true
+	}
+	_, ok := lookup(attrs, attribute.Key(column))
+	return ok
+}
+
+// columnValue returns the numeric value of column in attrs, false if it has
+// no numeric value. Durations are returned in milliseconds.
+func columnValue(attrs []attribute.KeyValue, column string) (float64, bool) {
+	key := attribute.Key(column)
+	v, ok := lookup(attrs, key)
+	if !ok {
+		return 0, false
+	}
+	switch v.Type() {
+	case attribute.INT64:
+		if key == attribute.SpanDurationKey {
+			return float64(v.AsInt64()) / 1e6, true
+		}
+		return float64(v.AsInt64()), true
+	case attribute.FLOAT64:
+		return v.AsFloat64(), true
+	default:
+		return 0, false
+	}
+}
+
+// lookup returns the value of key in attrs.
+func lookup(attrs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// Shutdown unregisters the callback of the percentiles, the instruments are
+// owned by the MeterProvider.
+func (a *aggregator) Shutdown(context.Context) error {
+	if a.reg == nil {
+		return nil
+	}
+	return a.reg.Unregister()
+}
+
+// ForceFlush does nothing, the measurements are recorded as spans end.
+func (a *aggregator) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,117 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryaggregator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/queryparser"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewSpanProcessor(t *testing.T) {
+	q, err := queryparser.Parse("SELECT http.route, COUNT(*), AVG(@duration), PERCENTILE(@duration, 99), COUNT(user.id) AS users " +
+		"FROM checkout WHERE http.status_code >= 500 GROUP BY http.route")
+	require.NoError(t, err)
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	sp, err := NewSpanProcessor(meter, "errors", q)
+	require.NoError(t, err)
+
+	tracer := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("checkout"))),
+	).Tracer("test")
+	start := time.Now()
+	end := func(d time.Duration, attrs ...attribute.KeyValue) {
+		_, span := tracer.Start(context.Background(), "span", trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+		span.End(trace.WithTimestamp(start.Add(d)))
+	}
+	end(10*time.Millisecond, attribute.String("http.route", "/pay"), attribute.Int("http.status_code", 500), attribute.Int("user.id", 42))
+	end(30*time.Millisecond, attribute.String("http.route", "/pay"), attribute.Int("http.status_code", 503))
+	end(20*time.Millisecond, attribute.String("http.route", "/cart"), attribute.Int("http.status_code", 502))
+	end(40*time.Millisecond, attribute.String("http.route", "/pay"), attribute.Int("http.status_code", 200))
+	end(50*time.Millisecond, attribute.Int("http.status_code", 500))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+	require.Len(t, metrics, 4, "the aggregates of a column should share a histogram")
+
+	route := func(r string) attribute.Set {
+		if r == "" {
+			return *attribute.EmptySet()
+		}
+		return attribute.NewSet(attribute.String("http.route", r))
+	}
+	counts := func(name string) map[attribute.Set]int64 {
+		out := make(map[attribute.Set]int64)
+		for _, dp := range metrics[name].(metricdata.Sum[int64]).DataPoints {
+			out[dp.Attributes] = dp.Value
+		}
+		return out
+	}
+	assert.Equal(t, map[attribute.Set]int64{route("/pay"): 2, route("/cart"): 1, route(""): 1}, counts("errors.count"))
+	assert.Equal(t, map[attribute.Set]int64{route("/pay"): 1}, counts("errors.users"))
+
+	durations := make(map[attribute.Set]float64)
+	for _, dp := range metrics["errors.duration"].(metricdata.Histogram[float64]).DataPoints {
+		durations[dp.Attributes] = dp.Sum / float64(dp.Count)
+	}
+	assert.Equal(t, map[attribute.Set]float64{route("/pay"): 20, route("/cart"): 20, route(""): 50}, durations)
+
+	p99 := make(map[attribute.Set]float64)
+	for _, dp := range metrics["errors.duration.p99"].(metricdata.Gauge[float64]).DataPoints {
+		p99[dp.Attributes] = dp.Value
+	}
+	assert.Equal(t, map[attribute.Set]float64{route("/pay"): 30, route("/cart"): 20, route(""): 50}, p99)
+}
+
+func TestNearestRank(t *testing.T) {
+	values := []float64{15, 20, 35, 40, 50}
+	assert.Equal(t, 15.0, nearestRank(values, 5))
+	assert.Equal(t, 20.0, nearestRank(values, 30))
+	assert.Equal(t, 35.0, nearestRank(values, 50))
+	assert.Equal(t, 50.0, nearestRank(values, 100))
+}
+
+func TestNewSpanProcessorErrors(t *testing.T) {
+	meter := sdkmetric.NewMeterProvider().Meter("test")
+	for _, sql := range []string{
+		"SELECT a FROM app1",
+		"SELECT COUNT(*) FROM app1 JOIN app2 ON app1 -> app2",
+		"SELECT AVG(app1.a) AS x, AVG(app1.b) AS x FROM app1",
+	} {
+		q, err := queryparser.Parse(sql)
+		require.NoError(t, err)
+		_, err = NewSpanProcessor(meter, "q", q)
+		assert.Error(t, err, sql)
+	}
+}
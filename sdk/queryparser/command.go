@@ -0,0 +1,61 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryparser // import "go.opentelemetry.io/otel/sdk/queryparser"
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// ReadQuery reads a query from r, one line at a time, until a line only
+// holding "end" or the end of r.
+func ReadQuery(r io.Reader) (string, error) {
+	var b strings.Builder
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "end" {
+			break
+		}
+		b.WriteString(line)
+		b.WriteString(" ")
+	}
+	return b.String(), scanner.Err()
+}
+
+// GetQueryAsJSON reads a query from the standard input, terminated by "end"
+// on its own line, and prints its JSON form to the standard output. It exits
+// the program if the query is invalid.
+func GetQueryAsJSON() {
+	fmt.Println("Enter inputs (terminate with 'end' in a new line): ")
+	sql, err := ReadQuery(os.Stdin)
+	if err != nil {
+		log.Fatalln("Error reading query:", err)
+	}
+	query, err := Parse(sql)
+	if err != nil {
+		log.Fatalln("Error parsing SQL query:", err)
+	}
+	out, err := json.Marshal(query)
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	fmt.Println(string(out))
+}
@@ -0,0 +1,420 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryparser // import "go.opentelemetry.io/otel/sdk/queryparser"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+)
+
+// Compile installs q on f and returns the filter flags q needs to be
+// enforced:
+//
+//   - Selected attributes become key matches and enable the AttributeFilter,
+//     unless an attribute is selected with "*".
+//   - The WHERE condition becomes the condition of f and enables the
+//     AttributeNotMatchFullTraceFilter.
+//   - JOIN conditions enable the StructuralTraceFilter, their call chains are
+//     returned by q.CallChains.
+//
+// The selected events are not installed, see Apply. The matches of all
+// tables are merged into f, the matches already installed
+// on f are kept.
+func Compile(q *Query, f attribute.TraceAttributeFilter) (global.FilterConfigFlag, error) {
+	var flag global.FilterConfigFlag
+	if q.Where != nil {
+		cond, err := q.Where.AttributeCondition()
+		if err != nil {
+			return 0, err
+		}
+		f.SetCondition(cond)
+		flag |= global.AttributeNotMatchFullTraceFilter
+	}
+	for _, keys := range q.Select {
+		for _, key := range keys {
+			if key != "*" {
+				f.AddKeyMatch(attribute.Key(key))
+			}
+		}
+	}
+	if q.projects() {
+		flag |= global.AttributeFilter
+	}
+	if len(q.Join) > 0 {
+		flag |= global.StructuralTraceFilter
+	}
+	return flag, nil
+}
+
+// tableRules are the rules of the part of a query on a table.
+type tableRules struct {
+	keys      []attribute.Key
+	cond      attribute.Condition
+	projects  bool
+	condition bool
+}
+
+// rules returns the rules of the part of q on table: the attributes selected
+// on table or on all tables, "*" being the WildcardKey, and the condition of
+// table.
+func (q *Query) rules(table string) (tableRules, error) {
+	var r tableRules
+	for _, key := range append(q.Select[AllTables], q.Select[table]...) {
+		r.keys = append(r.keys, attribute.Key(key))
+	}
+	r.projects = len(r.keys) > 0
+	where, err := q.TableCondition(table)
+	if err != nil {
+		return r, err
+	}
+	if where != nil {
+		if r.cond, err = where.AttributeCondition(); err != nil {
+			return r, err
+		}
+		r.condition = true
+	}
+	return r, nil
+}
+
+// install installs r on f and returns the filter flags r needs.
+func (r tableRules) install(f attribute.TraceAttributeFilter) global.FilterConfigFlag {
+	var flag global.FilterConfigFlag
+	for _, key := range r.keys {
+		f.AddKeyMatch(key)
+	}
+	if r.projects {
+		flag |= global.AttributeFilter
+	}
+	if r.condition {
+		f.SetCondition(r.cond)
+		flag |= global.AttributeNotMatchFullTraceFilter
+	}
+	return flag
+}
+
+// installTable installs r on f, the filter scoped to the table of r in a
+// query installed with the AttributeNotMatchFullTraceFilter, see Apply, and
+// returns the filter flags r needs. A table without condition selects all
+// its spans: its key matches only project the attributes, rather than drop
+// the spans lacking one of the keys.
+func (r tableRules) installTable(f attribute.TraceAttributeFilter) global.FilterConfigFlag {
+	flag := r.install(f)
+	if !r.condition {
+		f.SetCondition(attribute.And())
+	}
+	return flag
+}
+
+// CompileTable installs the part of q on table on f and returns the filter
+// flags it needs to be enforced:
+//
+//   - Attributes selected on table or on all tables become key matches and
+//     enable the AttributeFilter, "*" becomes a match on the
+//     attribute.WildcardKey.
+//   - The condition of table, see TableCondition, becomes the condition of f
+//     and enables the AttributeNotMatchFullTraceFilter.
+//
+// The matches already installed on f are kept.
+func CompileTable(q *Query, table string, f attribute.TraceAttributeFilter) (global.FilterConfigFlag, error) {
+	r, err := q.rules(table)
+	if err != nil {
+		return 0, err
+	}
+	return r.install(f), nil
+}
+
+// Apply replaces the global trace filter by q. The part of q on each table
+// is installed on the TraceAttributeFilter scoped to the table, see
+// otel.GetScopedTraceAttributeFilter, so that each service only applies its
+// own part of the query, the spans of a table without condition are all
+// kept. The spans of the services outside of q are dropped by the global
+// TraceAttributeFilter. The events selected on any table become
+// the key matches of the TraceEventFilter, and enable the EventFilter. The
+// structural patterns are set to the call chains of q, the query ID to the
+// ID of q, and the filter flags to the ones q needs.
+//
+// The lifetime clauses of q bound its installation, see global.QueryBounds:
+// the configuration q replaces is restored when its duration or its span
+// budget runs out, and q is only installed at its start. Applying a query
+// replaces the bounded query installed or waiting for its start.
+//
+// The new filters are built aside and published with the filter flags as a
+// single configuration, the spans are filtered by either the previous query
+// or q. Aggregate queries are not installed, see Query.Aggregates.
+func Apply(q *Query) error {
+	if len(q.Aggregates) > 0 {
+		return errAggregateQuery
+	}
+	rules := make(map[string]tableRules, len(q.From))
+	for _, table := range q.From {
+		r, err := q.rules(table)
+		if err != nil {
+			return err
+		}
+		rules[table] = r
+	}
+	return global.InstallQuery(q.bounds(), func() error {
+		install(q, rules)
+		return nil
+	})
+}
+
+// errAggregateQuery is returned when installing an aggregate query as a
+// trace filter.
+var errAggregateQuery = errors.New("queryparser: aggregate queries are computed by an aggregator, not installed as a trace filter")
+
+// install replaces the global trace filter by q, whose tables have rules.
+// It is run by global.InstallQuery, which publishes the configuration once
+// installed.
+func install(q *Query, rules map[string]tableRules) {
+	f := attribute.NewMapTraceAttributeFilter()
+	f.SetCondition(attribute.Or())
+	flag := global.AttributeNotMatchFullTraceFilter
+	scoped := make(map[string]attribute.Snapshot, len(rules))
+	for table, r := range rules {
+		sf := attribute.NewMapTraceAttributeFilter()
+		flag |= r.installTable(sf)
+		scoped[table] = sf.Snapshot()
+	}
+	events := attribute.NewMapTraceAttributeFilter()
+	for _, names := range q.Events {
+		for _, name := range names {
+			events.AddKeyMatch(attribute.Key(name))
+		}
+		flag |= global.EventFilter
+	}
+	if len(q.Join) > 0 {
+		flag |= global.StructuralTraceFilter
+	}
+
+	otel.GetTraceAttributeFilter().Restore(f.Snapshot())
+	otel.ClearScopedTraceAttributeFilters()
+	for table, snapshot := range scoped {
+		otel.GetScopedTraceAttributeFilter(table).Restore(snapshot)
+	}
+	otel.GetTraceEventFilter().Restore(events.Snapshot())
+	otel.SetTraceStructuralPatterns(q.CallChains()...)
+	otel.SetQueryID(q.ID())
+	otel.SetAttributeFilterConfig(flag)
+}
+
+// ApplyNamed installs q as the named query called name, replacing the named
+// query with the same name if any. Named queries run next to the global
+// trace filter installed by Apply and to one another: a span is exported if
+// any of them selects it, with the attributes and events any of the queries
+// selecting it keeps, and names the queries selecting it in its
+// otel.query.ids attribute. The parts of q on its tables, its events and its
+// call chains are installed as by Apply, on filters of its own.
+//
+// The lifetime clauses of q bound the named query: it is only installed at
+// its STARTING AT time, and removed when its FOR duration or its LIMIT SPANS
+// budget runs out, see global.InstallNamedQuery.
+func ApplyNamed(name string, q *Query) error {
+	if name == "" {
+		return fmt.Errorf("queryparser: empty query name")
+	}
+	if len(q.Aggregates) > 0 {
+		return errAggregateQuery
+	}
+	nq := &global.NamedQuery{
+		Name:     name,
+		Flags:    global.AttributeNotMatchFullTraceFilter,
+		Filter:   attribute.NewMapTraceAttributeFilter(),
+		Scoped:   make(map[string]attribute.TraceAttributeFilter, len(q.From)),
+		Events:   attribute.NewMapTraceAttributeFilter(),
+		Patterns: q.CallChains(),
+	}
+	// The spans of the services outside of q are not selected.
+	nq.Filter.SetCondition(attribute.Or())
+	for _, table := range q.From {
+		r, err := q.rules(table)
+		if err != nil {
+			return err
+		}
+		f := attribute.NewMapTraceAttributeFilter()
+		nq.Flags |= r.installTable(f)
+		nq.Scoped[table] = f
+	}
+	for _, names := range q.Events {
+		for _, name := range names {
+			nq.Events.AddKeyMatch(attribute.Key(name))
+		}
+		nq.Flags |= global.EventFilter
+	}
+	if len(q.Join) > 0 {
+		nq.Flags |= global.StructuralTraceFilter
+	}
+	return global.InstallNamedQuery(nq, q.bounds())
+}
+
+// RemoveNamed removes the named query called name installed by ApplyNamed,
+// if any.
+func RemoveNamed(name string) {
+	global.RemoveNamedQuery(name)
+}
+
+// bounds returns the bounds of the lifetime clauses of q.
+func (q *Query) bounds() global.QueryBounds {
+	return global.QueryBounds{Start: q.Start, TTL: q.For, MaxSpans: q.MaxSpans}
+}
+
+// projects reports whether q restricts the exported attributes.
+func (q *Query) projects() bool {
+	if len(q.Select) == 0 {
+		return false
+	}
+	for _, keys := range q.Select {
+		for _, key := range keys {
+			if key == "*" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AttributeCondition returns the attribute.Condition evaluating c. The
+// tables of the comparisons are ignored.
+func (c *Condition) AttributeCondition() (attribute.Condition, error) {
+	if c.Op == "" {
+		return filterCondition(attribute.Key(c.Key), c.Filter)
+	}
+	operands := make([]attribute.Condition, len(c.Operands))
+	for i, operand := range c.Operands {
+		var err error
+		if operands[i], err = operand.AttributeCondition(); err != nil {
+			return attribute.Condition{}, err
+		}
+	}
+	switch c.Op {
+	case OpAnd:
+		return attribute.And(operands...), nil
+	case OpOr:
+		return attribute.Or(operands...), nil
+	case OpNot:
+		if len(operands) != 1 {
+			return attribute.Condition{}, fmt.Errorf("queryparser: NOT takes a single operand, got %d", len(operands))
+		}
+		return attribute.Not(operands[0]), nil
+	default:
+		return attribute.Condition{}, fmt.Errorf("queryparser: unsupported operator %q", c.Op)
+	}
+}
+
+// filterCondition returns the condition body on key.
+func filterCondition(key attribute.Key, body FilterBody) (attribute.Condition, error) {
+	switch body.Op {
+	case "":
+	case attribute.FilterOpLike:
+		return attribute.Pattern(key, attribute.LIKE, body.LowerBound)
+	case attribute.FilterOpRegex:
+		return attribute.Pattern(key, attribute.REGEX, body.LowerBound)
+	case attribute.FilterOpContainsAll:
+		elem, err := literalValue(body.Type, body.LowerBound)
+		if err != nil {
+			return attribute.Condition{}, fmt.Errorf("queryparser: invalid contains condition on %s: %w", key, err)
+		}
+		return attribute.Contains(key, attribute.CONTAINSALL, elem)
+	case attribute.FilterOpLength:
+		lb, ub := int64(0), int64(math.MaxInt64)
+		var err error
+		if body.LowerBound != "" {
+			if lb, err = strconv.ParseInt(body.LowerBound, 10, 64); err != nil {
+				return attribute.Condition{}, fmt.Errorf("queryparser: invalid length condition on %s: %w", key, err)
+			}
+		}
+		if body.UpperBound != "" {
+			if ub, err = strconv.ParseInt(body.UpperBound, 10, 64); err != nil {
+				return attribute.Condition{}, fmt.Errorf("queryparser: invalid length condition on %s: %w", key, err)
+			}
+		}
+		return attribute.LengthInRange(key, lb, ub), nil
+	default:
+		return attribute.Condition{}, fmt.Errorf("queryparser: unsupported condition operator %q on %s", body.Op, key)
+	}
+	switch body.Type {
+	case "string":
+		return attribute.Equal(key, attribute.StringValue(body.LowerBound)), nil
+	case "bool":
+		b, err := strconv.ParseBool(body.LowerBound)
+		if err != nil {
+			return attribute.Condition{}, fmt.Errorf("queryparser: invalid bool condition on %s: %w", key, err)
+		}
+		return attribute.Equal(key, attribute.BoolValue(b)), nil
+	case "int64":
+		lb, ub := int64(math.MinInt64), int64(math.MaxInt64)
+		var err error
+		if body.LowerBound != "" {
+			if lb, err = strconv.ParseInt(body.LowerBound, 10, 64); err != nil {
+				return attribute.Condition{}, fmt.Errorf("queryparser: invalid int64 condition on %s: %w", key, err)
+			}
+		}
+		if body.UpperBound != "" {
+			if ub, err = strconv.ParseInt(body.UpperBound, 10, 64); err != nil {
+				return attribute.Condition{}, fmt.Errorf("queryparser: invalid int64 condition on %s: %w", key, err)
+			}
+		}
+		if body.IsEquality() {
+			return attribute.Equal(key, attribute.Int64Value(lb)), nil
+		}
+		return attribute.InRange(key, attribute.Int64Value(lb), attribute.Int64Value(ub)), nil
+	case "float64":
+		// Open bounds are the extremes of float64 rather than infinities to
+		// keep the condition encodable in JSON.
+		lb, ub := -math.MaxFloat64, math.MaxFloat64
+		var err error
+		if body.LowerBound != "" {
+			if lb, err = strconv.ParseFloat(body.LowerBound, 64); err != nil {
+				return attribute.Condition{}, fmt.Errorf("queryparser: invalid float64 condition on %s: %w", key, err)
+			}
+		}
+		if body.UpperBound != "" {
+			if ub, err = strconv.ParseFloat(body.UpperBound, 64); err != nil {
+				return attribute.Condition{}, fmt.Errorf("queryparser: invalid float64 condition on %s: %w", key, err)
+			}
+		}
+		if body.IsEquality() {
+			return attribute.Equal(key, attribute.Float64Value(lb)), nil
+		}
+		return attribute.InRange(key, attribute.Float64Value(lb), attribute.Float64Value(ub)), nil
+	default:
+		return attribute.Condition{}, fmt.Errorf("queryparser: unsupported condition type %q on %s", body.Type, key)
+	}
+}
+
+// literalValue returns the value of the literal s of type typ.
+func literalValue(typ, s string) (attribute.Value, error) {
+	switch typ {
+	case "string":
+		return attribute.StringValue(s), nil
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		return attribute.BoolValue(b), err
+	case "int64":
+		i, err := strconv.ParseInt(s, 10, 64)
+		return attribute.Int64Value(i), err
+	case "float64":
+		f, err := strconv.ParseFloat(s, 64)
+		return attribute.Float64Value(f), err
+	default:
+		return attribute.Value{}, fmt.Errorf("unsupported type %q", typ)
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+)
+
+func TestCompile(t *testing.T) {
+	q, err := Parse(`SELECT app1.name, app2.size FROM app1, app2
+		WHERE app1.code >= 500 AND app1.method = 'GET' AND app2.ratio = 0.5 AND app2.ok = true`)
+	require.NoError(t, err)
+
+	f := attribute.NewMapTraceAttributeFilter()
+	flag, err := Compile(q, f)
+	require.NoError(t, err)
+	assert.Equal(t, global.AttributeFilter|global.AttributeNotMatchFullTraceFilter, flag)
+
+	// Only the selected attributes are projected.
+	assert.True(t, f.Match("name", attribute.StringValue("any")))
+	assert.True(t, f.Match("size", attribute.Int64Value(0)))
+	assert.False(t, f.Match("code", attribute.Int64Value(500)))
+
+	match := []attribute.KeyValue{
+		attribute.Int64("code", 500),
+		attribute.String("method", "GET"),
+		attribute.Float64("ratio", 0.5),
+		attribute.Bool("ok", true),
+	}
+	assert.True(t, selected(f, match))
+	for i, kv := range []attribute.KeyValue{
+		attribute.Int64("code", 499),
+		attribute.String("method", "POST"),
+		attribute.Float64("ratio", 0.25),
+		attribute.Bool("ok", false),
+	} {
+		attrs := append([]attribute.KeyValue{}, match...)
+		attrs[i] = kv
+		assert.Falsef(t, selected(f, attrs), "%s=%s", kv.Key, kv.Value.Emit())
+	}
+}
+
+func TestCompileBooleanOperators(t *testing.T) {
+	q, err := Parse(`SELECT * FROM app1 WHERE app1.a = 1 OR NOT (app1.b > 2 AND app1.b < 10)`)
+	require.NoError(t, err)
+	f := attribute.NewMapTraceAttributeFilter()
+	_, err = Compile(q, f)
+	require.NoError(t, err)
+
+	assert.True(t, selected(f, []attribute.KeyValue{attribute.Int("a", 1), attribute.Int("b", 5)}))
+	assert.True(t, selected(f, []attribute.KeyValue{attribute.Int("a", 2), attribute.Int("b", 2)}))
+	assert.False(t, selected(f, []attribute.KeyValue{attribute.Int("a", 2), attribute.Int("b", 5)}))
+}
+
+func TestCompileInBetween(t *testing.T) {
+	q, err := Parse(`SELECT * FROM app1 WHERE app1.code IN (500, 502, 503) OR app1.code BETWEEN 510 AND 520`)
+	require.NoError(t, err)
+	f := attribute.NewMapTraceAttributeFilter()
+	_, err = Compile(q, f)
+	require.NoError(t, err)
+	assert.Equal(t, "(code = 500 OR code = 502 OR code = 503 OR code IN [510, 520])", f.Snapshot().Condition().String())
+
+	for code, want := range map[int]bool{500: true, 501: false, 503: true, 509: false, 515: true, 521: false} {
+		assert.Equal(t, want, selected(f, []attribute.KeyValue{attribute.Int("code", code)}), code)
+	}
+}
+
+func TestCompileStringOperators(t *testing.T) {
+	q, err := Parse(`SELECT * FROM app1 WHERE app1.http.target LIKE '/api/%' AND app1.http.method != 'GET'
+		AND app1.db.statement NOT REGEXP '(?i)^delete'`)
+	require.NoError(t, err)
+	f := attribute.NewMapTraceAttributeFilter()
+	_, err = Compile(q, f)
+	require.NoError(t, err)
+
+	attrs := func(target, method, statement string) []attribute.KeyValue {
+		return []attribute.KeyValue{
+			attribute.String("http.target", target),
+			attribute.String("http.method", method),
+			attribute.String("db.statement", statement),
+		}
+	}
+	assert.True(t, selected(f, attrs("/api/users", "POST", "SELECT 1")))
+	assert.False(t, selected(f, attrs("/static/a.css", "POST", "SELECT 1")))
+	assert.False(t, selected(f, attrs("/api/users", "GET", "SELECT 1")))
+	assert.False(t, selected(f, attrs("/api/users", "POST", "DELETE FROM users")))
+}
+
+func TestCompileArrayOperators(t *testing.T) {
+	q, err := Parse(`SELECT * FROM app1 WHERE 'error' IN app1.tags AND 'debug' NOT IN app1.tags
+		AND contains_any(app1.codes, 500, 503) AND LENGTH(app1.tags) <= 3`)
+	require.NoError(t, err)
+	f := attribute.NewMapTraceAttributeFilter()
+	_, err = Compile(q, f)
+	require.NoError(t, err)
+
+	attrs := func(codes []int64, tags ...string) []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.StringSlice("tags", tags), attribute.Int64Slice("codes", codes)}
+	}
+	assert.True(t, selected(f, attrs([]int64{200, 503}, "error", "retry")))
+	assert.False(t, selected(f, attrs([]int64{200, 503}, "retry")))
+	assert.False(t, selected(f, attrs([]int64{200, 503}, "error", "debug")))
+	assert.False(t, selected(f, attrs([]int64{200}, "error")))
+	assert.False(t, selected(f, attrs([]int64{500}, "error", "a", "b", "c")))
+
+	cond, err := q.Where.AttributeCondition()
+	require.NoError(t, err)
+	assert.Equal(t, `(tags CONTAINS ALL ("error") AND NOT tags CONTAINS ALL ("debug") AND `+
+		`(codes CONTAINS ALL (500) OR codes CONTAINS ALL (503)) AND LENGTH(tags) IN [0, 3])`, cond.String())
+}
+
+// selected reports whether a span with attrs passes the condition of f.
+func selected(f attribute.TraceAttributeFilter, attrs []attribute.KeyValue) bool {
+	ok := true
+	f.BatchNotMatch(attrs, func() error {
+		ok = false
+		return nil
+	})
+	return ok
+}
+
+func TestCompileFlags(t *testing.T) {
+	for _, test := range []struct {
+		sql  string
+		want global.FilterConfigFlag
+	}{
+		{"SELECT * FROM app1", 0},
+		{"SELECT app1.a FROM app1", global.AttributeFilter},
+		{"SELECT * FROM app1 WHERE app1.a = 1", global.AttributeNotMatchFullTraceFilter},
+		{"SELECT app1.* FROM app1 JOIN app2 ON app1 -> app2", global.StructuralTraceFilter},
+	} {
+		q, err := Parse(test.sql)
+		require.NoError(t, err)
+		got, err := Compile(q, attribute.NewMapTraceAttributeFilter())
+		require.NoError(t, err)
+		assert.Equal(t, test.want, got, test.sql)
+	}
+}
+
+func TestCompileTable(t *testing.T) {
+	q, err := Parse(`SELECT app1.a, app2.* FROM app1, app2 WHERE app1.b = 1 AND (app2.c = 2 OR app2.d = 3)`)
+	require.NoError(t, err)
+
+	app1 := attribute.NewMapTraceAttributeFilter()
+	flag, err := CompileTable(q, "app1", app1)
+	require.NoError(t, err)
+	assert.Equal(t, global.AttributeFilter|global.AttributeNotMatchFullTraceFilter, flag)
+	assert.True(t, app1.Match("a", attribute.IntValue(0)))
+	assert.False(t, app1.Match("c", attribute.IntValue(2)))
+	assert.True(t, selected(app1, []attribute.KeyValue{attribute.Int("b", 1)}))
+	assert.False(t, selected(app1, []attribute.KeyValue{attribute.Int("c", 2)}))
+
+	app2 := attribute.NewMapTraceAttributeFilter()
+	_, err = CompileTable(q, "app2", app2)
+	require.NoError(t, err)
+	assert.True(t, app2.Match("any", attribute.IntValue(0)))
+	assert.True(t, selected(app2, []attribute.KeyValue{attribute.Int("d", 3)}))
+	assert.False(t, selected(app2, []attribute.KeyValue{attribute.Int("b", 1)}))
+
+	q, err = Parse(`SELECT * FROM app1, app2 WHERE app1.b = 1 OR app2.c = 2`)
+	require.NoError(t, err)
+	_, err = CompileTable(q, "app1", attribute.NewMapTraceAttributeFilter())
+	assert.Error(t, err, "condition mixing tables")
+}
+
+func TestApply(t *testing.T) {
+	flags, patterns := global.FilterConfigFlags(), global.TraceStructuralPatterns()
+	t.Cleanup(func() {
+		otel.GetTraceAttributeFilter().Clear()
+		otel.ClearScopedTraceAttributeFilters()
+		global.SetFilterConfigFlags(flags)
+		global.SetTraceStructuralPatterns(patterns)
+		global.SetQueryID("")
+	})
+
+	otel.GetTraceAttributeFilter().AddKeyMatch("stale")
+	otel.GetScopedTraceAttributeFilter("stale").AddKeyMatch("stale")
+	q, err := Parse("SELECT app1.a FROM app1 JOIN app2 ON app1 -> app2 WHERE app1.b = 1")
+	require.NoError(t, err)
+	require.NoError(t, Apply(q))
+
+	assert.Equal(t, []string{"app1", "app2"}, global.ScopedTraceAttributeFilterTables())
+	app1 := global.TraceAttributeFilterFor("app1", "")
+	assert.True(t, app1.Match("a", attribute.StringValue("")))
+	assert.True(t, selected(app1, []attribute.KeyValue{attribute.Int("b", 1)}))
+	assert.False(t, selected(app1, []attribute.KeyValue{attribute.Int("b", 2)}))
+
+	app2 := global.TraceAttributeFilterFor("app2", "")
+	assert.False(t, app2.Match("a", attribute.StringValue("")), "app2 selects no attribute")
+	assert.True(t, selected(app2, []attribute.KeyValue{attribute.Int("b", 2)}), "app2 has no condition")
+
+	other := global.TraceAttributeFilterFor("app3", "")
+	assert.False(t, other.Match("stale", attribute.StringValue("")), "previous matches should be cleared")
+	assert.False(t, selected(other, nil), "services outside of the query should be dropped")
+
+	assert.Equal(t, [][]string{{"app1", "app2"}}, otel.GetTraceStructuralPatterns())
+	assert.Equal(t, q.ID(), otel.GetQueryID())
+	assert.Equal(t,
+		global.AttributeFilter|global.AttributeNotMatchFullTraceFilter|global.StructuralTraceFilter,
+		global.FilterConfigFlags())
+}
+
+func TestApplyProjection(t *testing.T) {
+	resetQuery(t)
+
+	q, err := Parse("SELECT app1.a, app1.b FROM app1")
+	require.NoError(t, err)
+	require.NoError(t, Apply(q))
+
+	app1 := global.TraceAttributeFilterFor("app1", "")
+	assert.True(t, app1.Match("a", attribute.StringValue("")))
+	assert.True(t, selected(app1, nil), "a table without condition should keep the spans lacking the selected keys")
+	assert.True(t, selected(app1, []attribute.KeyValue{attribute.Int("a", 1)}))
+	assert.False(t, selected(global.TraceAttributeFilterFor("app2", ""), nil), "services outside of the query should be dropped")
+}
+
+func TestApplyNamed(t *testing.T) {
+	t.Cleanup(global.ClearNamedQueries)
+
+	q, err := Parse("SELECT app1.a, app1.events.exception FROM app1 JOIN app2 ON app1 -> app2 WHERE app1.b = 1")
+	require.NoError(t, err)
+	require.NoError(t, ApplyNamed("errors", q))
+	q, err = Parse("SELECT app3.* FROM app3")
+	require.NoError(t, err)
+	require.NoError(t, ApplyNamed("all", q))
+	assert.Empty(t, global.ScopedTraceAttributeFilterTables(), "the global trace filter should not change")
+
+	named, ok := global.LookupNamedQuery("errors")
+	require.True(t, ok)
+	assert.Equal(t,
+		global.AttributeFilter|global.AttributeNotMatchFullTraceFilter|global.StructuralTraceFilter|global.EventFilter,
+		named.Flags)
+	app1 := named.FilterFor("app1", "")
+	assert.True(t, app1.Match("a", attribute.StringValue("")))
+	assert.True(t, selected(app1, []attribute.KeyValue{attribute.Int("b", 1)}))
+	assert.False(t, selected(app1, []attribute.KeyValue{attribute.Int("b", 2)}))
+	assert.True(t, selected(named.FilterFor("app2", ""), nil))
+	assert.False(t, selected(named.FilterFor("app3", ""), nil), "services outside of the query should not be selected")
+	assert.True(t, named.Events.Match("exception", attribute.InvalidValue()))
+	assert.Equal(t, [][]string{{"app1", "app2"}}, named.Patterns)
+
+	named, ok = global.LookupNamedQuery("all")
+	require.True(t, ok)
+	assert.Equal(t, global.FilterConfigFlag(global.AttributeFilter|global.AttributeNotMatchFullTraceFilter), named.Flags)
+
+	RemoveNamed("errors")
+	require.Len(t, global.NamedQueries(), 1)
+
+	q, err = Parse("SELECT app1.a FROM app1 FOR 10 MINUTES LIMIT 1 SPANS")
+	require.NoError(t, err)
+	assert.Error(t, ApplyNamed("", q))
+	require.NoError(t, ApplyNamed("bounded", q))
+	named, ok = global.LookupNamedQuery("bounded")
+	require.True(t, ok)
+	assert.True(t, named.TakeSpan())
+	assert.False(t, named.TakeSpan(), "the lifetime clauses should bound the named query")
+	assert.Eventually(t, func() bool { return len(global.NamedQueries()) == 1 }, time.Second, time.Millisecond)
+}
+
+func TestQueryID(t *testing.T) {
+	id := func(sql string) string {
+		q, err := Parse(sql)
+		require.NoError(t, err)
+		return q.ID()
+	}
+	base := id("SELECT app1.a FROM app1 JOIN app2 ON app1 -> app2 WHERE app1.b = 1")
+	assert.Len(t, base, 16)
+	assert.Equal(t, base, id("select app1.a from app1 join app2 on app1 -> app2 where app1.b = 1"), "the ID should only depend on the parsed query")
+	assert.NotEqual(t, base, id("SELECT app1.a FROM app1 JOIN app2 ON app2 -> app1 WHERE app1.b = 1"))
+	assert.NotEqual(t, base, id("SELECT app1.a FROM app1 JOIN app2 ON app1 -> app2 WHERE app1.b = 2"))
+}
+
+func TestApplyEvents(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		otel.GetTraceEventFilter().Clear()
+		otel.ClearScopedTraceAttributeFilters()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	otel.GetTraceEventFilter().AddKeyMatch("stale")
+	q, err := Parse("SELECT app1.a, app1.events.exception FROM app1")
+	require.NoError(t, err)
+	require.NoError(t, Apply(q))
+	events := otel.GetTraceEventFilter()
+	assert.True(t, events.Match("exception", attribute.InvalidValue()))
+	assert.False(t, events.Match("stale", attribute.InvalidValue()))
+	assert.False(t, global.TraceAttributeFilterFor("app1", "").Match("events.exception", attribute.InvalidValue()))
+	assert.NotZero(t, global.FilterConfigFlags()&global.EventFilter)
+
+	q, err = Parse("SELECT app1.a FROM app1")
+	require.NoError(t, err)
+	require.NoError(t, Apply(q))
+	assert.Zero(t, global.FilterConfigFlags()&global.EventFilter, "all events should be kept")
+
+	// The query request fills the event filter.
+	q, err = Parse("SELECT app1.events.retry FROM app1")
+	require.NoError(t, err)
+	body, err := json.Marshal(q)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/?op=query", bytes.NewReader(body))
+	require.NoError(t, otel.GetTraceAttributeFilter().HandleRequest(req))
+	assert.True(t, events.Match("retry", attribute.InvalidValue()))
+}
+
+func TestApplyQueryJSON(t *testing.T) {
+	t.Cleanup(otel.ClearScopedTraceAttributeFilters)
+
+	// The JSON form of a query is installed as is on every service.
+	q, err := Parse("SELECT app1.a, app2.* FROM app1, app2 WHERE app1.b > 1 AND app2.c = 'x'")
+	require.NoError(t, err)
+	body, err := json.Marshal(q)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/?op=query", bytes.NewReader(body))
+	require.NoError(t, otel.GetTraceAttributeFilter().HandleRequest(req))
+
+	app1 := global.TraceAttributeFilterFor("", "app1")
+	assert.True(t, app1.Match("a", attribute.StringValue("")))
+	assert.True(t, selected(app1, []attribute.KeyValue{attribute.Int("b", 2)}))
+	assert.False(t, selected(app1, []attribute.KeyValue{attribute.Int("b", 1)}))
+
+	app2 := global.TraceAttributeFilterFor("app2", "app1")
+	assert.True(t, app2.Match("any", attribute.StringValue("")))
+	assert.True(t, selected(app2, []attribute.KeyValue{attribute.String("c", "x")}))
+}
+
+func TestApplyBounded(t *testing.T) {
+	resetQuery(t)
+
+	base, err := Parse("SELECT app1.a FROM app1")
+	require.NoError(t, err)
+	require.NoError(t, Apply(base))
+	baseFlags := global.FilterConfigFlags()
+
+	q, err := Parse("SELECT app2.b FROM app2 JOIN app3 ON app2 -> app3 LIMIT 2 SPANS")
+	require.NoError(t, err)
+	require.NoError(t, Apply(q))
+	assert.Equal(t, []string{"app2", "app3"}, global.ScopedTraceAttributeFilterTables())
+	assert.Equal(t, q.ID(), otel.GetQueryID())
+
+	assert.True(t, global.TakeQuerySpan())
+	assert.True(t, global.TakeQuerySpan(), "the last span of the budget should be exported")
+	assert.Eventually(t, func() bool { return otel.GetQueryID() == base.ID() }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"app1"}, global.ScopedTraceAttributeFilterTables(), "the previous query should be restored")
+	assert.Equal(t, base.ID(), otel.GetQueryID())
+	assert.Empty(t, otel.GetTraceStructuralPatterns())
+	assert.Equal(t, baseFlags, global.FilterConfigFlags())
+	assert.True(t, global.TakeQuerySpan(), "the restored query should have no budget")
+
+	q, err = Parse("SELECT app2.b FROM app2 STARTING AT '" + time.Now().Add(time.Hour).Format(time.RFC3339) + "'")
+	require.NoError(t, err)
+	require.NoError(t, Apply(q))
+	assert.Equal(t, base.ID(), otel.GetQueryID(), "a query should not be installed before its start")
+
+	require.NoError(t, Apply(base))
+	assert.Equal(t, base.ID(), otel.GetQueryID(), "the scheduled query should be replaced")
+}
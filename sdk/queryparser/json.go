@@ -0,0 +1,123 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryparser // import "go.opentelemetry.io/otel/sdk/queryparser"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// filterJSON is a single attribute filter of the JSON form of a Query.
+type filterJSON struct {
+	Key    string        `json:"key"`
+	Type   string        `json:"type"`
+	Values []interface{} `json:"values"`
+}
+
+// filtersJSON are the filters of a table, in the form of the update request
+// of the trace attribute filter.
+type filtersJSON struct {
+	Filters []filterJSON         `json:"filters"`
+	Where   *attribute.Condition `json:"where,omitempty"`
+	// Events are the names of the span events selected on the table.
+	Events []string `json:"events,omitempty"`
+}
+
+// MarshalJSON returns the filters of each table of the query. Each of them
+// can be sent as is as the update request of the trace attribute filter
+// (op=update) of the table service, e.g.
+//
+//	{"app1": {"filters": [{"key": "attr1", "type": "", "values": []}],
+//	          "where": {"key": "attr2", "type": "int64", "values": [1]},
+//	          "events": ["exception"]}}
+//
+// The attributes selected on the table or on all tables are key filters, "*"
+// matching all keys, the WHERE condition is split by table, see
+// TableCondition, and events lists the events selected on the table, which
+// an update request ignores. The whole JSON form can be sent to all the
+// services of the query as the "query" request of the trace attribute
+// filter, each of them applying its own part and filling the TraceEventFilter
+// with the selected events.
+func (q *Query) MarshalJSON() ([]byte, error) {
+	out := make(map[string]filtersJSON, len(q.From))
+	for _, table := range q.From {
+		filters := []filterJSON{}
+		for _, key := range append(q.Select[AllTables], q.Select[table]...) {
+			filters = append(filters, filterJSON{Key: key, Type: "", Values: []interface{}{}})
+		}
+		tj := filtersJSON{Filters: filters, Events: q.Events[table]}
+		where, err := q.TableCondition(table)
+		if err != nil {
+			return nil, err
+		}
+		if where != nil {
+			cond, err := where.AttributeCondition()
+			if err != nil {
+				return nil, err
+			}
+			tj.Where = &cond
+		}
+		out[table] = tj
+	}
+	return json.Marshal(out)
+}
+
+// ID returns the identity of q, derived from its parsed form: the services
+// parsing the same query get the same ID. It is the query ID installed by
+// Apply, see otel.SetQueryID.
+func (q *Query) ID() string {
+	// Encode the fields of q rather than its MarshalJSON form, which omits
+	// the JOIN conditions.
+	type query Query
+	data, _ := json.Marshal((*query)(q))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// TableCondition returns the part of the WHERE condition of q on table, nil
+// if there is none. The WHERE condition must be a conjunction of conditions
+// each referring to a single table, e.g. app1.a = 1 AND (app2.b = 2 OR
+// app2.c = 3).
+func (q *Query) TableCondition(table string) (*Condition, error) {
+	if q.Where == nil {
+		return nil, nil
+	}
+	conjuncts := []*Condition{q.Where}
+	if q.Where.Op == OpAnd {
+		conjuncts = q.Where.Operands
+	}
+	var operands []*Condition
+	for _, c := range conjuncts {
+		tables := c.Tables()
+		if len(tables) > 1 {
+			return nil, fmt.Errorf("queryparser: condition on several tables %v must be split with AND", tables)
+		}
+		if tables[0] == table {
+			operands = append(operands, c)
+		}
+	}
+	switch len(operands) {
+	case 0:
+		return nil, nil
+	case 1:
+		return operands[0], nil
+	default:
+		return &Condition{Op: OpAnd, Operands: operands}, nil
+	}
+}
@@ -1,315 +1,1259 @@
-package queryparser
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queryparser parses the SQL dialect used to query traces, e.g.
+//
+//	SELECT app1.attr1, app2.attr2
+//	FROM app1
+//	JOIN app2 ON app1 -> app2
+//	WHERE app1.attr1 = 1 AND app2.attr2 > 2
+//
+// Tables are services, columns are span attributes, or the intrinsics of the
+// spans, @name, @kind, @status, @duration and @scope, and the JOIN conditions
+// describe caller -> callee relationships between services. Lifetime clauses
+// may end a query to bound its installation, e.g.
+//
+//	SELECT app1.attr1 FROM app1 WHERE app1.attr1 = 1 FOR 10 MINUTES LIMIT 1000 SPANS
+//
+// Aggregate queries select aggregate functions, grouped by the columns of a
+// GROUP BY clause, e.g.
+//
+//	SELECT COUNT(*), AVG(@duration) FROM checkout WHERE http.status_code >= 500 GROUP BY http.route
+//
+// They are computed in process by the aggregator of
+// go.opentelemetry.io/otel/sdk/metric/queryaggregator rather than installed
+// as a trace filter.
+package queryparser // import "go.opentelemetry.io/otel/sdk/queryparser"
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"github.com/xwb1989/sqlparser"
-	"log"
-	"os"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// AllTables is the Select key used for a "SELECT *" projection.
+const AllTables = "*"
+
+// Query is a parsed trace query.
 type Query struct {
-	From   *[]string
-	Select *map[string][]string
-	Where  *map[string]map[string]FilterBody // map[tableName]map[attrName]FilterBody
-	Join   *[]string
+	// From lists the tables (services) the query reads from, including the
+	// joined ones, in the order they appear in the query.
+	From []string
+	// Select maps a table to the attributes it projects. "SELECT *" is
+	// stored as {AllTables: {"*"}}, "SELECT app1.*" as {"app1": {"*"}}.
+	Select map[string][]string
+	// Where is the WHERE condition, nil if the query has none.
+	Where *Condition
+	// Join lists the "caller > callee" conditions of the JOIN clauses.
+	Join []string
+	// Events maps a table to the names of the span events it selects, the
+	// columns of its events pseudo table: "SELECT app1.events.exception" is
+	// stored as {"app1": {"exception"}}, "SELECT app1.events.*" as
+	// {"app1": {"*"}}. It is nil if the query selects no event.
+	Events map[string][]string
+	// Aggregates lists the aggregate functions selected, in order. A query
+	// with aggregates is an aggregate query, computed in process rather than
+	// installed as a trace filter.
+	Aggregates []Aggregate `json:",omitempty"`
+	// GroupBy lists the attributes the aggregates are grouped by, the GROUP
+	// BY columns. The columns of a GROUP BY clause may be selected, they are
+	// also stored in Select.
+	GroupBy []string `json:",omitempty"`
+
+	// The lifetime clauses ending the query bound its installation by
+	// Apply, the configuration it replaced being restored when it ends.
+	// For is the duration the query stays installed, "FOR 10 MINUTES",
+	// MaxSpans the number of spans it exports, "LIMIT 1000 SPANS", and
+	// Start the time it is installed at, "STARTING AT
+	// '2024-05-01T10:00:00Z'". They are unbounded if zero, and not part of
+	// the ID of the query.
+	For      time.Duration `json:"-"`
+	MaxSpans int64         `json:"-"`
+	Start    time.Time     `json:"-"`
+}
+
+// Aggregate functions of an Aggregate.
+const (
+	AggCount      = "COUNT"
+	AggSum        = "SUM"
+	AggAvg        = "AVG"
+	AggMin        = "MIN"
+	AggMax        = "MAX"
+	AggPercentile = "PERCENTILE"
+)
+
+// Aggregate is an aggregate function selected by a query, e.g. COUNT(*),
+// AVG(app1.@duration) or PERCENTILE(app1.@duration, 99).
+type Aggregate struct {
+	// Func is the aggregate function, AggCount, AggSum, AggAvg, AggMin,
+	// AggMax or AggPercentile.
+	Func string
+	// Table is the table of Column, empty for a COUNT(*) on all the tables.
+	Table string
+	// Column is the attribute aggregated, "*" for COUNT(*). COUNT(column)
+	// counts the spans having the attribute.
+	Column string
+	// Percentile is the percentile of AggPercentile, in (0, 100].
+	Percentile float64 `json:",omitempty"`
+	// Alias is the name given to the aggregate with AS, if any.
+	Alias string `json:",omitempty"`
+}
+
+// eventsTable is the name of the pseudo table of the span events of a table,
+// its columns are event names.
+const eventsTable = "events"
+
+// Boolean operators of a Condition.
+const (
+	OpAnd = "AND"
+	OpOr  = "OR"
+	OpNot = "NOT"
+)
+
+// Condition is a node of the WHERE condition tree. Op is OpAnd, OpOr or
+// OpNot for a boolean operator applied to Operands, or empty for a
+// comparison of the Key attribute of Table with Filter.
+type Condition struct {
+	Op       string
+	Operands []*Condition
+	Table    string
+	Key      string
+	Filter   FilterBody
+}
+
+// Tables returns the tables the comparisons of c refer to.
+func (c *Condition) Tables() []string {
+	var tables []string
+	var walk func(*Condition)
+	walk = func(c *Condition) {
+		if c.Op == "" {
+			for _, t := range tables {
+				if t == c.Table {
+					return
+				}
+			}
+			tables = append(tables, c.Table)
+			return
+		}
+		for _, operand := range c.Operands {
+			walk(operand)
+		}
+	}
+	walk(c)
+	return tables
 }
 
+// FilterBody is the condition on a single attribute. Bounds are inclusive
+// and stored in their textual form, an empty bound is unbounded. An equality
+// condition has equal lower and upper bounds.
+//
+// A string pattern condition has an Op, attribute.FilterOpLike or
+// attribute.FilterOpRegex, and the pattern as both bounds. The test of an
+// array containing an element has the attribute.FilterOpContainsAll Op and
+// the element as both bounds, and the comparison of the length of an array
+// the attribute.FilterOpLength Op.
 type FilterBody struct {
 	Type       string
+	Op         string
 	UpperBound string
 	LowerBound string
 }
 
-// Get the type of an operand
-func getOperandType(operand sqlparser.Expr) string {
-	switch operand := operand.(type) {
-	case *sqlparser.SQLVal:
-		opType := operand.Type
-		if opType == sqlparser.StrVal {
-			return "string"
-		} else if opType == sqlparser.IntVal {
-			return "int64"
-		} else if opType == sqlparser.FloatVal {
-			return "float64"
-		} else {
-			log.Fatalln("Error: unsupported operand type")
+// IsEquality reports whether f only matches a single value.
+func (f FilterBody) IsEquality() bool {
+	return f.Op == "" && f.UpperBound == f.LowerBound && f.UpperBound != ""
+}
+
+// ParseError is the error returned when a query cannot be parsed.
+type ParseError struct {
+	// Pos is the byte offset in the query the error refers to, or -1 if
+	// it is unknown.
+	Pos int
+	// Near is the part of the query the error refers to, if known.
+	Near string
+	// Msg describes the error.
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+	b.WriteString("queryparser: ")
+	b.WriteString(e.Msg)
+	if e.Pos >= 0 {
+		fmt.Fprintf(&b, " at position %d", e.Pos)
+	}
+	if e.Near != "" {
+		fmt.Fprintf(&b, " near '%s'", e.Near)
+	}
+	return b.String()
+}
+
+var (
+	// arrowRe matches the "->" of a join condition. It is replaced by a
+	// same-length "> " so that positions reported by sqlparser still refer
+	// to the original query.
+	arrowRe = regexp.MustCompile(`(\s)->(\s)`)
+	// syntaxErrRe extracts the position of a sqlparser syntax error.
+	syntaxErrRe = regexp.MustCompile(`at position (\d+)(?: near '(.*)')?$`)
+	// containsRe matches the "literal [NOT] IN column" array membership
+	// tests, which sqlparser does not support.
+	containsRe = regexp.MustCompile(`(?i)('(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"|-?\d+(?:\.\d*)?(?:e[-+]?\d+)?|true|false)` +
+		`\s+(not\s+)?in\s+(` + identPattern + `(?:\.` + identPattern + `)*)`)
+)
+
+// boundsRe matches a lifetime clause ending a query.
+var boundsRe = regexp.MustCompile(`(?i)\s((for)\s+(\d+)\s+(second|minute|hour|day)s?|(limit)\s+(\d+)\s+spans|(starting)\s+at\s+'([^']*)')\s*;?\s*$`)
+
+// boundsUnits are the durations of the units of a FOR clause.
+var boundsUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// parseBounds parses the lifetime clauses ending sql into q. It returns sql
+// with the clauses blanked out, so that the positions in the query are kept.
+func parseBounds(sql string, q *Query) (string, error) {
+	seen := map[string]bool{}
+	for {
+		m := boundsRe.FindStringSubmatchIndex(sql)
+		if m == nil || insideSpan(quotedSpans(sql), m[2]) {
+			return sql, nil
 		}
-	case *sqlparser.ColName:
-		return "column"
-	default:
-		log.Fatalln("Error: unsupported operand type")
+		clause := sql[m[2]:m[3]]
+		keyword := ""
+		for i := 4; i < len(m); i += 2 {
+			if m[i] >= 0 {
+				keyword = strings.ToUpper(sql[m[i]:m[i+1]])
+				break
+			}
+		}
+		if seen[keyword] {
+			return "", &ParseError{Pos: m[2], Near: clause, Msg: "duplicate " + keyword + " clause"}
+		}
+		seen[keyword] = true
+		switch keyword {
+		case "FOR":
+			n, err := strconv.ParseInt(sql[m[6]:m[7]], 10, 64)
+			unit := boundsUnits[strings.ToLower(sql[m[8]:m[9]])]
+			if err != nil || n <= 0 || n > int64(math.MaxInt64/unit) {
+				return "", &ParseError{Pos: m[2], Near: clause, Msg: "invalid FOR duration"}
+			}
+			q.For = time.Duration(n) * unit
+		case "LIMIT":
+			n, err := strconv.ParseInt(sql[m[12]:m[13]], 10, 64)
+			if err != nil || n <= 0 {
+				return "", &ParseError{Pos: m[2], Near: clause, Msg: "invalid LIMIT span count"}
+			}
+			q.MaxSpans = n
+		case "STARTING":
+			start, err := time.Parse(time.RFC3339, sql[m[16]:m[17]])
+			if err != nil {
+				return "", &ParseError{Pos: m[2], Near: clause, Msg: "invalid STARTING AT time, want RFC 3339"}
+			}
+			q.Start = start
+		}
+		sql = sql[:m[2]] + strings.Repeat(" ", len(sql)-m[2])
 	}
-	log.Fatalln("Invalid operand type")
-	return ""
 }
 
-func extractConditions(expr sqlparser.Expr) []sqlparser.Expr {
-	var conditions []sqlparser.Expr
-	switch expr := expr.(type) {
-	case *sqlparser.AndExpr:
-		conditions = append(conditions, extractConditions(expr.Left)...)
-		conditions = append(conditions, extractConditions(expr.Right)...)
-	case *sqlparser.OrExpr:
-		conditions = append(conditions, extractConditions(expr.Left)...)
-		conditions = append(conditions, extractConditions(expr.Right)...)
-	default:
-		conditions = append(conditions, expr)
+// identPattern matches an identifier, quoted by backticks or not.
+const identPattern = "(?:`[^`]+`|[a-z_]\\w*)"
+
+// rewrite is a replacement made in a query before parsing it.
+type rewrite struct {
+	// pos is the position of the replacement in the rewritten query.
+	pos    int
+	oldLen int
+	newLen int
+}
+
+// rewrites are the replacements made in a query, in order.
+type rewrites []rewrite
+
+// origPos returns the position in the original query of the position pos in
+// the rewritten query. A position within a replacement is mapped to the start
+// of the replaced text.
+func (rs rewrites) origPos(pos int) int {
+	shift := 0
+	for _, r := range rs {
+		switch {
+		case pos < r.pos:
+			return pos - shift
+		case pos < r.pos+r.newLen:
+			return r.pos - shift
+		}
+		shift += r.newLen - r.oldLen
 	}
-	return conditions
+	return pos - shift
 }
 
-func extractJoins(expr sqlparser.TableExpr) []string {
-	var conditions []string
-	switch expr := expr.(type) {
-	case *sqlparser.JoinTableExpr:
-		conditions = append(conditions, sqlparser.String(expr.Condition.On))
-		conditions = append(conditions, extractJoins(expr.LeftExpr)...)
-	default:
+// durationRe matches an unquoted duration literal, e.g. 200ms or 1h30m.
+var durationRe = regexp.MustCompile(`\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h)(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h))*`)
+
+// durationOperandRe matches the text preceding the right operand of a
+// comparison of the @duration column: a comparison operator, BETWEEN,
+// BETWEEN and its first bound, or IN and the former items of its list.
+var durationOperandRe = regexp.MustCompile("(?i)`?@duration`?\\s*(?:[<>=!]=?|<>|\\s(?:not\\s+)?between|\\s(?:not\\s+)?between\\s+\\S+\\s+and|\\s(?:not\\s+)?in\\s*\\([^()]*)\\s*$")
+
+// rewriteDurations quotes the unquoted duration literals compared to the
+// @duration column in sql, which sqlparser does not support, e.g.
+// @duration > 200ms becomes @duration > '200ms'.
+func rewriteDurations(sql string) (string, rewrites) {
+	quoted := quotedSpans(sql)
+	var b strings.Builder
+	var rs rewrites
+	last := 0
+	for _, m := range durationRe.FindAllStringIndex(sql, -1) {
+		start, end := m[0], m[1]
+		if insideSpan(quoted, start) || start > 0 && isIdentByte(sql[start-1]) || end < len(sql) && isIdentByte(sql[end]) {
+			// Part of a string or of an identifier.
+			continue
+		}
+		if !durationOperandRe.MatchString(sql[:start]) {
+			// Not compared to the duration of the spans.
+			continue
+		}
+		repl := "'" + sql[start:end] + "'"
+		b.WriteString(sql[last:start])
+		rs = append(rs, rewrite{pos: b.Len(), oldLen: end - start, newLen: len(repl)})
+		b.WriteString(repl)
+		last = end
 	}
-	return conditions
+	if rs == nil {
+		return sql, nil
+	}
+	b.WriteString(sql[last:])
+	return b.String(), rs
 }
 
-func getRangeFromWhere(expr sqlparser.Expr, query *Query) {
-	switch expr := expr.(type) {
-	case *sqlparser.ComparisonExpr:
-		// Get the type of the left operand
-		leftOperandType := getOperandType(expr.Left)
-		attrName := expr.Left.(*sqlparser.ColName).Name.String()
-		// Get the type of the right operand
-		rightOperandType := getOperandType(expr.Right)
-		// Check if the left operand is a column
-		if leftOperandType != "column" {
-			log.Fatalln("Left operand has to be a column in WHERE clause")
-		}
-		tableName := expr.Left.(*sqlparser.ColName).Qualifier.Name.String()
-
-		typeFilter := rightOperandType
-		upperBound := ""
-		lowerBound := ""
-
-		if expr.Operator == "=" {
-			upperBound = sqlparser.String(expr.Right)
-			(*query.Where)[tableName][attrName] = FilterBody{typeFilter, upperBound, upperBound}
-		} else if rightOperandType == "int64" {
-
-			str := sqlparser.String(expr.Right)
-			num, err := strconv.Atoi(str)
-			if err != nil {
-				log.Fatalln("Error converting string to int:", err)
-			}
+// rewritePasses are the rewrites of successive passes over a query.
+type rewritePasses []rewrites
+
+// origPos returns the position in the original query of the position pos in
+// the query rewritten by all the passes.
+func (ps rewritePasses) origPos(pos int) int {
+	for i := len(ps) - 1; i >= 0; i-- {
+		pos = ps[i].origPos(pos)
+	}
+	return pos
+}
+
+// rewriteContains rewrites the "literal [NOT] IN column" tests of sql as
+// "[NOT] CONTAINS_ALL(column, literal)" calls.
+func rewriteContains(sql string) (string, rewrites) {
+	quoted := quotedSpans(sql)
+	var b strings.Builder
+	var rs rewrites
+	last := 0
+	for _, m := range containsRe.FindAllStringSubmatchIndex(sql, -1) {
+		start, end := m[0], m[1]
+		if insideSpan(quoted, start) || start > 0 && isIdentByte(sql[start-1]) ||
+			strings.HasPrefix(strings.TrimLeft(sql[end:], " \t\r\n"), "(") || end < len(sql) && isIdentByte(sql[end]) {
+			// Part of a string, of an identifier, or of a function call.
+			continue
+		}
+		repl := "CONTAINS_ALL(" + sql[m[6]:m[7]] + ", " + sql[m[2]:m[3]] + ")"
+		if m[4] >= 0 {
+			repl = "NOT " + repl
+		}
+		b.WriteString(sql[last:start])
+		rs = append(rs, rewrite{pos: b.Len(), oldLen: end - start, newLen: len(repl)})
+		b.WriteString(repl)
+		last = end
+	}
+	if rs == nil {
+		return sql, nil
+	}
+	b.WriteString(sql[last:])
+	return b.String(), rs
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c == '`' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
 
-			oldFilterBody, ok := (*query.Where)[tableName][attrName]
-			if ok {
-				if oldFilterBody.Type != typeFilter {
-					log.Fatalln("Error: Detected different types of operands for the same attribute in WHERE clause")
+// quotedSpans returns the [start, end) spans of the quoted strings and
+// identifiers of sql.
+func quotedSpans(sql string) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(sql); i++ {
+		q := sql[i]
+		if q != '\'' && q != '"' && q != '`' {
+			continue
+		}
+		start := i
+		for i++; i < len(sql); i++ {
+			if sql[i] == '\\' && q != '`' {
+				i++
+			} else if sql[i] == q {
+				if i+1 < len(sql) && sql[i+1] == q {
+					i++
+					continue
 				}
-			} else {
-				oldFilterBody = FilterBody{typeFilter, "", ""}
-			}
-			if expr.Operator == ">" {
-				lowerBound = strconv.Itoa(num - 1)
-				(*query.Where)[tableName][attrName] = FilterBody{typeFilter, oldFilterBody.UpperBound, lowerBound}
-			} else if expr.Operator == "<" {
-				upperBound = strconv.Itoa(num - 1)
-				(*query.Where)[tableName][attrName] = FilterBody{typeFilter, upperBound, oldFilterBody.LowerBound}
-			} else if expr.Operator == "=>" {
-				lowerBound = strconv.Itoa(num)
-				(*query.Where)[tableName][attrName] = FilterBody{typeFilter, oldFilterBody.UpperBound, lowerBound}
-			} else if expr.Operator == "<=" {
-				upperBound = strconv.Itoa(num)
-				(*query.Where)[tableName][attrName] = FilterBody{typeFilter, upperBound, oldFilterBody.LowerBound}
-			} else {
-				log.Fatalln("Error: unsupported operator in WHERE clause")
+				break
 			}
-		} else {
-			log.Fatalln("Error: unsupported comparison for string type in WHERE clause")
 		}
+		spans = append(spans, [2]int{start, i + 1})
+	}
+	return spans
+}
+
+// insideSpan reports whether pos is within, and not at the start of, one of
+// spans.
+func insideSpan(spans [][2]int, pos int) bool {
+	for _, s := range spans {
+		if s[0] < pos && pos < s[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses sql into a Query. The returned error is a *ParseError.
+func Parse(sql string) (*Query, error) {
+	q := &Query{
+		From:   []string{},
+		Select: map[string][]string{},
+		Join:   []string{},
+	}
+	sql, err := parseBounds(sql, q)
+	if err != nil {
+		return nil, err
+	}
+	sql = arrowRe.ReplaceAllString(sql, "$1> $2")
+	sql, durations := rewriteDurations(sql)
+	sql, contains := rewriteContains(sql)
+	rs := rewritePasses{durations, contains}
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		pe := &ParseError{Pos: -1, Msg: "syntax error"}
+		if m := syntaxErrRe.FindStringSubmatch(err.Error()); m != nil {
+			pos, _ := strconv.Atoi(m[1])
+			pe.Pos = rs.origPos(pos)
+			pe.Near = m[2]
+		}
+		return nil, pe
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, &ParseError{Pos: 0, Msg: "only SELECT statements are supported"}
+	}
+
+	p := &parser{
+		sql:      sql,
+		rewrites: rs,
+		query:    q,
+	}
+	if err := p.parseFrom(sel.From); err != nil {
+		return nil, err
+	}
+	if err := p.parseSelect(sel.SelectExprs); err != nil {
+		return nil, err
+	}
+	if sel.Where != nil {
+		where, err := p.parseWhere(sel.Where.Expr)
+		if err != nil {
+			return nil, err
+		}
+		p.query.Where = where
 	}
+	if err := p.parseGroupBy(sel.GroupBy); err != nil {
+		return nil, err
+	}
+	return p.query, nil
 }
 
-func getQueryAsJSON(query *Query) []byte {
+// parser holds the state of a single Parse call.
+type parser struct {
+	// sql is the query with its join arrows, its duration literals and its
+	// "literal IN column" tests rewritten, rewrites maps its positions to
+	// the original query.
+	sql      string
+	rewrites rewritePasses
+	query    *Query
+}
 
-	jsonString := `{`
+// errorf returns a *ParseError located at node.
+func (p *parser) errorf(node sqlparser.SQLNode, format string, args ...interface{}) error {
+	near := sqlparser.String(node)
+	pos := strings.Index(strings.ToLower(p.sql), strings.ToLower(near))
+	if pos >= 0 {
+		pos = p.rewrites.origPos(pos)
+	}
+	return &ParseError{
+		Pos:  pos,
+		Near: near,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
 
-	for _, tableName := range *query.From {
-		jsonString += `"` + tableName + `": [`
-		for k, body := range (*query.Where)[tableName] {
-			jsonString += `{"key": "` + k + `", "type": "` + body.Type + `", ` + `"values": [`
-			if body.UpperBound == body.LowerBound {
-				if body.Type == "string" {
-					jsonString += `"` + body.UpperBound
-					jsonString = jsonString[:len(jsonString)-len(body.UpperBound)] + jsonString[len(jsonString)-len(body.UpperBound)+1:]
-					jsonString = jsonString[:len(jsonString)-1]
-					jsonString += `"]},`
-				} else {
-					jsonString += body.UpperBound + `]},`
+func (p *parser) isTable(name string) bool {
+	for _, t := range p.query.From {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) addTable(name string) {
+	if !p.isTable(name) {
+		p.query.From = append(p.query.From, name)
+	}
+}
+
+func (p *parser) parseFrom(exprs sqlparser.TableExprs) error {
+	for _, expr := range exprs {
+		if err := p.parseTableExpr(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseTableExpr(expr sqlparser.TableExpr) error {
+	switch table := expr.(type) {
+	case *sqlparser.AliasedTableExpr:
+		name, ok := table.Expr.(sqlparser.TableName)
+		if !ok {
+			return p.errorf(table, "unsupported table expression")
+		}
+		p.addTable(name.Name.String())
+	case *sqlparser.JoinTableExpr:
+		if err := p.parseTableExpr(table.LeftExpr); err != nil {
+			return err
+		}
+		if err := p.parseTableExpr(table.RightExpr); err != nil {
+			return err
+		}
+		return p.parseJoin(table.Condition.On)
+	default:
+		return p.errorf(table, "unsupported table expression")
+	}
+	return nil
+}
+
+// parseJoin parses a "caller -> callee" join condition.
+func (p *parser) parseJoin(on sqlparser.Expr) error {
+	if on == nil {
+		return &ParseError{Pos: -1, Msg: "JOIN requires an ON caller -> callee condition"}
+	}
+	cmp, ok := on.(*sqlparser.ComparisonExpr)
+	if !ok || cmp.Operator != sqlparser.GreaterThanStr {
+		return p.errorf(on, "JOIN condition must be caller -> callee")
+	}
+	caller, lok := cmp.Left.(*sqlparser.ColName)
+	callee, rok := cmp.Right.(*sqlparser.ColName)
+	if !lok || !rok || !caller.Qualifier.IsEmpty() || !callee.Qualifier.IsEmpty() {
+		return p.errorf(on, "JOIN condition must be caller -> callee")
+	}
+	for _, c := range []*sqlparser.ColName{caller, callee} {
+		if !p.isTable(c.Name.String()) {
+			return p.errorf(c, "unknown table %q in JOIN condition", c.Name.String())
+		}
+	}
+	p.query.Join = append(p.query.Join, caller.Name.String()+" > "+callee.Name.String())
+	return nil
+}
+
+func (p *parser) parseSelect(exprs sqlparser.SelectExprs) error {
+	for _, expr := range exprs {
+		switch col := expr.(type) {
+		case *sqlparser.StarExpr:
+			if table, ok := p.eventsOf(col.TableName); ok {
+				p.addEvent(table, "*")
+				continue
+			}
+			table := AllTables
+			if !col.TableName.IsEmpty() {
+				table = col.TableName.Name.String()
+				if !p.isTable(table) {
+					return p.errorf(col, "unknown table %q", table)
 				}
-			} else {
-				jsonString += body.LowerBound + `, ` + body.UpperBound + `]},`
 			}
+			p.query.Select[table] = append(p.query.Select[table], "*")
+		case *sqlparser.AliasedExpr:
+			if fn, ok := col.Expr.(*sqlparser.FuncExpr); ok {
+				if err := p.parseAggregate(col, fn); err != nil {
+					return err
+				}
+				continue
+			}
+			name, ok := col.Expr.(*sqlparser.ColName)
+			if !ok {
+				return p.errorf(col, "unsupported select expression")
+			}
+			table, attr, err := p.column(name)
+			if err != nil {
+				return err
+			}
+			if event := strings.TrimPrefix(attr, eventsTable+"."); event != attr {
+				p.addEvent(table, event)
+				continue
+			}
+			p.query.Select[table] = append(p.query.Select[table], attr)
+		default:
+			return p.errorf(col, "unsupported select expression")
 		}
+	}
+	return nil
+}
 
-		for _, attrName := range (*query.Select)[tableName] {
-			if _, ok := (*query.Where)[tableName][attrName]; !ok {
-				jsonString += `{"key": "` + attrName + `", "type": "", ` + `"values": [0]},`
+// parseAggregate parses the aggregate function fn selected by expr.
+func (p *parser) parseAggregate(expr *sqlparser.AliasedExpr, fn *sqlparser.FuncExpr) error {
+	agg := Aggregate{Func: strings.ToUpper(fn.Name.String()), Alias: expr.As.String()}
+	args := 1
+	switch agg.Func {
+	case AggCount, AggSum, AggAvg, AggMin, AggMax:
+	case AggPercentile:
+		args = 2
+	default:
+		return p.errorf(fn, "unsupported aggregate function %s", fn.Name.String())
+	}
+	if fn.Distinct {
+		return p.errorf(fn, "DISTINCT aggregates are not supported")
+	}
+	if len(fn.Exprs) != args {
+		return p.errorf(fn, "%s takes %d argument(s)", agg.Func, args)
+	}
+	switch arg := fn.Exprs[0].(type) {
+	case *sqlparser.StarExpr:
+		if agg.Func != AggCount {
+			return p.errorf(fn, "only COUNT aggregates *")
+		}
+		if !arg.TableName.IsEmpty() {
+			agg.Table = arg.TableName.Name.String()
+			if !p.isTable(agg.Table) {
+				return p.errorf(arg, "unknown table %q", agg.Table)
 			}
 		}
-		jsonString = jsonString[:len(jsonString)-1]
-		jsonString += `],`
+		agg.Column = "*"
+	case *sqlparser.AliasedExpr:
+		name, ok := arg.Expr.(*sqlparser.ColName)
+		if !ok {
+			return p.errorf(fn, "%s argument must be a column", agg.Func)
+		}
+		var err error
+		if agg.Table, agg.Column, err = p.column(name); err != nil {
+			return err
+		}
+	default:
+		return p.errorf(fn, "%s argument must be a column", agg.Func)
+	}
+	if agg.Func == AggPercentile {
+		arg, ok := fn.Exprs[1].(*sqlparser.AliasedExpr)
+		if !ok {
+			return p.errorf(fn, "PERCENTILE takes a percentile literal")
+		}
+		typ, val, err := p.literal(arg.Expr)
+		if err != nil {
+			return err
+		}
+		if isNumeric(typ) {
+			agg.Percentile, _ = strconv.ParseFloat(val, 64)
+		}
+		if !isNumeric(typ) || agg.Percentile <= 0 || agg.Percentile > 100 {
+			return p.errorf(arg, "percentile must be in (0, 100]")
+		}
 	}
-	jsonString = jsonString[:len(jsonString)-1]
-	jsonString = jsonString + `}`
-	// fmt.Println(jsonString)
-	var data map[string]interface{}
-	err := json.Unmarshal([]byte(jsonString), &data)
-	if err != nil {
-		log.Fatalln("Error:", err)
+	p.query.Aggregates = append(p.query.Aggregates, agg)
+	return nil
+}
+
+// parseGroupBy parses the GROUP BY columns, and checks that the columns
+// selected next to aggregates are grouped by.
+func (p *parser) parseGroupBy(exprs sqlparser.GroupBy) error {
+	for _, expr := range exprs {
+		name, ok := expr.(*sqlparser.ColName)
+		if !ok {
+			return p.errorf(expr, "GROUP BY only supports columns")
+		}
+		_, attr, err := p.column(name)
+		if err != nil {
+			return err
+		}
+		p.query.GroupBy = append(p.query.GroupBy, attr)
 	}
+	q := p.query
+	if len(q.Aggregates) == 0 {
+		if len(q.GroupBy) > 0 {
+			return &ParseError{Pos: -1, Msg: "GROUP BY requires aggregate functions"}
+		}
+		return nil
+	}
+	if len(q.Events) > 0 {
+		return &ParseError{Pos: -1, Msg: "events cannot be selected with aggregate functions"}
+	}
+	for _, keys := range q.Select {
+		for _, key := range keys {
+			if !q.groupedBy(key) {
+				return &ParseError{Pos: -1, Msg: fmt.Sprintf("column %q must be aggregated or grouped by", key)}
+			}
+		}
+	}
+	return nil
+}
 
-	// Marshal the map back to JSON
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		log.Fatalln("Error:", err)
+// groupedBy reports whether q is grouped by the attribute key.
+func (q *Query) groupedBy(key string) bool {
+	for _, k := range q.GroupBy {
+		if k == key {
+			return true
+		}
 	}
+	return false
+}
 
-	fmt.Println(string(jsonData))
-	return jsonData
+// column resolves the table and attribute a column refers to. Attribute
+// names may contain one dot without quoting, e.g. app1.http.method, longer
+// ones have to be quoted: app1.`http.request.method`. The table can be
+// omitted if the query reads from a single table. The columns @name, @kind,
+// @status, @duration and @scope are the intrinsics of the spans, their
+// names are the intrinsic keys such as attribute.SpanDurationKey. The other
+// columns starting with "@" are reserved.
+func (p *parser) column(col *sqlparser.ColName) (table, attr string, err error) {
+	table, attr, err = p.attribute(col)
+	if err == nil && strings.HasPrefix(attr, "@") && !intrinsicColumns[attribute.Key(attr)] {
+		return "", "", p.errorf(col, "unknown intrinsic column %q, want @name, @kind, @status, @duration or @scope", attr)
+	}
+	return table, attr, err
 }
 
-func GetQueryAsJSON() {
+// intrinsicColumns are the reserved columns of the intrinsics of a span,
+// evaluated against the span itself rather than its attributes.
+var intrinsicColumns = map[attribute.Key]bool{
+	attribute.SpanNameKey:     true,
+	attribute.SpanKindKey:     true,
+	attribute.SpanStatusKey:   true,
+	attribute.SpanDurationKey: true,
+	attribute.SpanScopeKey:    true,
+}
 
-	// examples:
-	//queryInput := "" +
-	//	"SELECT app1.attr1, app1.attr2 " +
-	//	"FROM app1 " +
-	//	"JOIN app2 ON app1 -> app2 " +
-	//	"JOIN app3 ON app2 -> app3"
+// attribute resolves the table and attribute a column refers to, see column.
+func (p *parser) attribute(col *sqlparser.ColName) (table, attr string, err error) {
+	outer := col.Qualifier.Qualifier.String()
+	inner := col.Qualifier.Name.String()
+	name := col.Name.String()
+	switch {
+	case outer != "":
+		if !p.isTable(outer) {
+			return "", "", p.errorf(col, "unknown table %q", outer)
+		}
+		return outer, inner + "." + name, nil
+	case inner != "" && p.isTable(inner):
+		return inner, name, nil
+	case len(p.query.From) != 1:
+		if inner != "" {
+			return "", "", p.errorf(col, "unknown table %q", inner)
+		}
+		return "", "", p.errorf(col, "column %q must be qualified by a table", name)
+	case inner != "":
+		return p.query.From[0], inner + "." + name, nil
+	default:
+		return p.query.From[0], name, nil
+	}
+}
 
-	//queryInput := "" +
-	//	"SELECT app1.attr1, app2.attr2, app2.attr5 " +
-	//	"FROM app1, app2 " +
-	//	"WHERE app1.attr1 = 1 AND app2.attr2 > 2  AND app2.attr2 < 100 AND app1.attr4 = 'name'"
+// eventsOf returns the table of the events pseudo table name, e.g. app1 for
+// app1.events, or the only table of the query for events.
+func (p *parser) eventsOf(name sqlparser.TableName) (string, bool) {
+	if name.Name.String() != eventsTable {
+		return "", false
+	}
+	if table := name.Qualifier.String(); table != "" {
+		return table, p.isTable(table)
+	}
+	if len(p.query.From) == 1 && !p.isTable(eventsTable) {
+		return p.query.From[0], true
+	}
+	return "", false
+}
 
-	// version that Only support one line input!!!
-	//scanner := bufio.NewScanner(os.Stdin)
-	//
-	//fmt.Println("Enter your input query in one line: ")
-	//
-	//scanner.Scan()
-	//queryInput := scanner.Text()
-	//
-//	queryInput := `SELECT Ads.brand, UserInfo.id
-//FROM Ads, UserInfo
-//WHERE  UserInfo.id < 2000 AND UserInfo.id > 27 OR Ads.length = 10.5`
-	scanner := bufio.NewScanner(os.Stdin)
-	queryInput := ""
-	fmt.Println("Enter inputs (terminate with 'end' in a new line): ")
+func (p *parser) addEvent(table, event string) {
+	if p.query.Events == nil {
+		p.query.Events = map[string][]string{}
+	}
+	p.query.Events[table] = append(p.query.Events[table], event)
+}
 
-	for scanner.Scan() {
-		input := scanner.Text()
+func (p *parser) parseWhere(expr sqlparser.Expr) (*Condition, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return p.parseBoolean(OpAnd, expr.Left, expr.Right)
+	case *sqlparser.OrExpr:
+		return p.parseBoolean(OpOr, expr.Left, expr.Right)
+	case *sqlparser.NotExpr:
+		operand, err := p.parseWhere(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &Condition{Op: OpNot, Operands: []*Condition{operand}}, nil
+	case *sqlparser.ParenExpr:
+		return p.parseWhere(expr.Expr)
+	case *sqlparser.ComparisonExpr:
+		return p.parseComparison(expr)
+	case *sqlparser.RangeCond:
+		return p.parseBetween(expr)
+	case *sqlparser.FuncExpr:
+		return p.parseContains(expr)
+	default:
+		return nil, p.errorf(expr, "unsupported condition")
+	}
+}
 
-		// Trim leading/trailing whitespace
-		input = strings.TrimSpace(input)
+// parseBoolean parses the operands of the op boolean operator, flattening
+// the operands using the same operator: a AND (b AND c) is AND(a, b, c).
+func (p *parser) parseBoolean(op string, left, right sqlparser.Expr) (*Condition, error) {
+	cond := &Condition{Op: op}
+	for _, expr := range []sqlparser.Expr{left, right} {
+		operand, err := p.parseWhere(expr)
+		if err != nil {
+			return nil, err
+		}
+		if operand.Op == op {
+			cond.Operands = append(cond.Operands, operand.Operands...)
+		} else {
+			cond.Operands = append(cond.Operands, operand)
+		}
+	}
+	return cond, nil
+}
 
-		if input == "end" {
-			break
+func (p *parser) parseComparison(expr *sqlparser.ComparisonExpr) (*Condition, error) {
+	table, attr, op, err := p.leftColumn(expr, expr.Left)
+	if err != nil {
+		return nil, err
+	}
+	switch expr.Operator {
+	case sqlparser.InStr, sqlparser.NotInStr:
+		return p.parseIn(expr, table, attr, op)
+	case sqlparser.LikeStr, sqlparser.NotLikeStr, sqlparser.RegexpStr, sqlparser.NotRegexpStr:
+		if op != "" {
+			return nil, p.errorf(expr, "unsupported comparison %s of LENGTH", strings.ToUpper(expr.Operator))
 		}
+		return p.parsePattern(expr, table, attr)
+	}
+	typ, val, err := p.literal(expr.Right)
+	if op == "" {
+		typ, val, err = p.columnLiteral(attr, expr.Right)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkLength(expr.Right, op, typ); err != nil {
+		return nil, err
+	}
 
-		queryInput += input + " "
-		// Process the input
-		// fmt.Println("Received input:", input)
+	body := FilterBody{Type: typ, Op: op}
+	switch expr.Operator {
+	case sqlparser.EqualStr:
+		body.LowerBound, body.UpperBound = val, val
+	case sqlparser.NotEqualStr:
+		// a != b is NOT a = b.
+		body.LowerBound, body.UpperBound = val, val
+		return &Condition{Op: OpNot, Operands: []*Condition{{Table: table, Key: attr, Filter: body}}}, nil
+	default:
+		if !isNumeric(typ) {
+			return nil, p.errorf(expr, "unsupported comparison %s for %s type", expr.Operator, typ)
+		}
+		body.LowerBound, body.UpperBound, err = bounds(typ, expr.Operator, val)
+		if err != nil {
+			return nil, p.errorf(expr, "%v", err)
+		}
 	}
+	return &Condition{Table: table, Key: attr, Filter: body}, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error:", err)
+// checkLength returns an error if a length, op being
+// attribute.FilterOpLength, is compared to a literal of type typ other than
+// int64.
+func (p *parser) checkLength(literal sqlparser.SQLNode, op, typ string) error {
+	if op == attribute.FilterOpLength && typ != "int64" {
+		return p.errorf(literal, "LENGTH compared to %s type", typ)
 	}
+	return nil
+}
 
-	// Replace -> with >
-	re := regexp.MustCompile(` -> `)
-	queryInput = re.ReplaceAllString(queryInput, " > ")
+// parseContains parses the CONTAINS_ALL(column, literal, ...) and
+// CONTAINS_ANY(column, literal, ...) array membership tests, the And and the
+// Or of the tests of each literal.
+func (p *parser) parseContains(expr *sqlparser.FuncExpr) (*Condition, error) {
+	op := OpAnd
+	switch {
+	case !expr.Qualifier.IsEmpty():
+		return nil, p.errorf(expr, "unsupported condition")
+	case expr.Name.Lowered() == "contains_all":
+	case expr.Name.Lowered() == "contains_any":
+		op = OpOr
+	default:
+		return nil, p.errorf(expr, "unsupported condition")
+	}
+	name := strings.ToUpper(expr.Name.String())
+	if expr.Distinct || len(expr.Exprs) < 2 {
+		return nil, p.errorf(expr, "%s takes a column and literals", name)
+	}
+	args := make([]sqlparser.Expr, len(expr.Exprs))
+	for i, e := range expr.Exprs {
+		aliased, ok := e.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, p.errorf(expr, "%s takes a column and literals", name)
+		}
+		args[i] = aliased.Expr
+	}
+	col, ok := args[0].(*sqlparser.ColName)
+	if !ok {
+		return nil, p.errorf(expr, "first argument of %s must be a column", name)
+	}
+	table, attr, err := p.column(col)
+	if err != nil {
+		return nil, err
+	}
+	cond := &Condition{Op: op}
+	for _, arg := range args[1:] {
+		typ, val, err := p.literal(arg)
+		if err != nil {
+			return nil, err
+		}
+		cond.Operands = append(cond.Operands, &Condition{
+			Table: table, Key: attr,
+			Filter: FilterBody{Type: typ, Op: attribute.FilterOpContainsAll, LowerBound: val, UpperBound: val},
+		})
+	}
+	if len(cond.Operands) == 1 {
+		cond = cond.Operands[0]
+	}
+	return cond, nil
+}
 
-	queryOutput := Query{&[]string{}, &map[string][]string{}, &map[string]map[string]FilterBody{}, &[]string{}}
-	parsedQuery, err := sqlparser.Parse(queryInput)
+func (p *parser) parsePattern(expr *sqlparser.ComparisonExpr, table, attr string) (*Condition, error) {
+	if expr.Escape != nil {
+		return nil, p.errorf(expr.Escape, "ESCAPE is not supported, patterns are escaped by a backslash")
+	}
+	typ, val, err := p.literal(expr.Right)
 	if err != nil {
-		// Handle parsing error
-		log.Fatalln("Error parsing SQL query:", err)
-		return
-	}
-	switch stmt := parsedQuery.(type) {
-	case *sqlparser.Select:
-		// Get tables
-		for _, tableExpr := range stmt.From {
-			switch table := tableExpr.(type) {
-			case *sqlparser.AliasedTableExpr:
-				tableName := table.Expr.(sqlparser.TableName).Name.String()
-				// Get AS alias
-				// alias := table.As.String()
-				// fmt.Println("Table:", alias)
-				*queryOutput.From = append(*queryOutput.From, tableName)
-			case *sqlparser.JoinTableExpr:
-				// Handle JOIN tables
-				joinCondition := extractJoins(table)
-				// joinCondition := extractConditions(table.Condition.On)
-				*queryOutput.Join = joinCondition
-				fmt.Printf("Joins: %s\n", *queryOutput.Join)
-			default:
-				fmt.Println("Unknown table expression")
-			}
+		return nil, err
+	}
+	op := strings.ToUpper(strings.TrimPrefix(expr.Operator, "not "))
+	if typ != "string" {
+		return nil, p.errorf(expr.Right, "%s pattern must be a string", op)
+	}
+	body := FilterBody{Type: typ, Op: attribute.FilterOpLike, LowerBound: val, UpperBound: val}
+	if op == "REGEXP" {
+		if _, err := regexp.Compile(val); err != nil {
+			return nil, p.errorf(expr.Right, "invalid regular expression: %v", err)
 		}
-		fmt.Println("From Table:", *queryOutput.From)
-
-		// Get attributes
-		for _, expr := range stmt.SelectExprs {
-			switch columnExpr := expr.(type) {
-			case *sqlparser.StarExpr:
-				// Handle SELECT *
-				(*queryOutput.Select)["*"] = append((*queryOutput.Select)["*"], "*")
-				fmt.Println("All columns selected")
-			case *sqlparser.AliasedExpr:
-				// Handle aliased expressions
-				// Get attr
-				columnName := columnExpr.Expr.(*sqlparser.ColName).Name.String()
-				// Get table
-				tableName := columnExpr.Expr.(*sqlparser.ColName).Qualifier.Name.String()
-				// Get alias if there is, otherwise return ""
-				// alias := columnExpr.As.String()
-				(*queryOutput.Select)[tableName] = append((*queryOutput.Select)[tableName], columnName)
-				(*queryOutput.Where)[tableName] = make(map[string]FilterBody)
-			default:
-				fmt.Println("Unknown select expression")
+		body.Op = attribute.FilterOpRegex
+	}
+	cond := &Condition{Table: table, Key: attr, Filter: body}
+	if strings.HasPrefix(expr.Operator, "not ") {
+		cond = &Condition{Op: OpNot, Operands: []*Condition{cond}}
+	}
+	return cond, nil
+}
+
+// leftColumn returns the column of the left operand of expr, which is either
+// a column or its LENGTH, op being attribute.FilterOpLength for the latter.
+func (p *parser) leftColumn(expr sqlparser.SQLNode, left sqlparser.Expr) (table, attr, op string, err error) {
+	if fn, ok := left.(*sqlparser.FuncExpr); ok && fn.Qualifier.IsEmpty() && fn.Name.Lowered() == "length" {
+		if len(fn.Exprs) == 1 {
+			if aliased, ok := fn.Exprs[0].(*sqlparser.AliasedExpr); ok {
+				left, op = aliased.Expr, attribute.FilterOpLength
 			}
 		}
-		fmt.Println("Select Column:", *queryOutput.Select)
+		if op == "" || fn.Distinct {
+			return "", "", "", p.errorf(fn, "LENGTH takes a single column")
+		}
+	}
+	col, ok := left.(*sqlparser.ColName)
+	if !ok {
+		return "", "", "", p.errorf(expr, "left operand of a condition must be a column")
+	}
+	table, attr, err = p.column(col)
+	return table, attr, op, err
+}
+
+func (p *parser) parseIn(expr *sqlparser.ComparisonExpr, table, attr, op string) (*Condition, error) {
+	tuple, ok := expr.Right.(sqlparser.ValTuple)
+	if !ok {
+		return nil, p.errorf(expr, "right operand of %s must be a list of literals", strings.ToUpper(expr.Operator))
+	}
+	cond := &Condition{Op: OpOr}
+	for _, e := range tuple {
+		typ, val, err := p.literal(e)
+		if op == "" {
+			typ, val, err = p.columnLiteral(attr, e)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkLength(e, op, typ); err != nil {
+			return nil, err
+		}
+		cond.Operands = append(cond.Operands, &Condition{
+			Table: table, Key: attr,
+			Filter: FilterBody{Type: typ, Op: op, LowerBound: val, UpperBound: val},
+		})
+	}
+	if len(cond.Operands) == 1 {
+		cond = cond.Operands[0]
+	}
+	if expr.Operator == sqlparser.NotInStr {
+		cond = &Condition{Op: OpNot, Operands: []*Condition{cond}}
+	}
+	return cond, nil
+}
+
+// parseBetween parses "attr BETWEEN lb AND ub" into a range condition, and
+// "attr NOT BETWEEN lb AND ub" into its NOT. An integer bound is promoted to
+// float64 if the other one is a float64.
+func (p *parser) parseBetween(expr *sqlparser.RangeCond) (*Condition, error) {
+	table, attr, op, err := p.leftColumn(expr, expr.Left)
+	if err != nil {
+		return nil, err
+	}
+	literal := p.literal
+	if op == "" {
+		literal = func(expr sqlparser.Expr) (string, string, error) { return p.columnLiteral(attr, expr) }
+	}
+	fromTyp, from, err := literal(expr.From)
+	if err != nil {
+		return nil, err
+	}
+	toTyp, to, err := literal(expr.To)
+	if err != nil {
+		return nil, err
+	}
+	typ := fromTyp
+	if fromTyp != toTyp {
+		if !isNumeric(fromTyp) || !isNumeric(toTyp) {
+			return nil, p.errorf(expr, "bounds of %s and %s types", fromTyp, toTyp)
+		}
+		typ = "float64"
+	}
+	if !isNumeric(typ) {
+		return nil, p.errorf(expr, "unsupported comparison %s for %s type", strings.ToUpper(expr.Operator), typ)
+	}
+	if err := p.checkLength(expr, op, typ); err != nil {
+		return nil, err
+	}
+	cond := &Condition{
+		Table: table, Key: attr,
+		Filter: FilterBody{Type: typ, Op: op, LowerBound: from, UpperBound: to},
+	}
+	if expr.Operator == sqlparser.NotBetweenStr {
+		cond = &Condition{Op: OpNot, Operands: []*Condition{cond}}
+	}
+	return cond, nil
+}
 
-		// Get conditions
-		if stmt.Where != nil {
-			// Extract conditions from WHERE clause
-			conditions := extractConditions(stmt.Where.Expr)
-			fmt.Print("Condition: ")
-			for _, condition := range conditions {
-				fmt.Print(sqlparser.String(condition) + " ")
-				getRangeFromWhere(condition, &queryOutput)
+func isNumeric(typ string) bool {
+	return typ == "int64" || typ == "float64"
+}
+
+// columnLiteral returns the type and textual value of a literal operand
+// compared to the attribute attr. A duration, quoted or not, e.g. 200ms, is
+// compared to the duration of the spans in nanoseconds, a kind or a status is
+// compared in the case of the intrinsic.
+func (p *parser) columnLiteral(attr string, expr sqlparser.Expr) (typ, val string, err error) {
+	typ, val, err = p.literal(expr)
+	if err != nil {
+		return "", "", err
+	}
+	switch attribute.Key(attr) {
+	case attribute.SpanDurationKey:
+		if typ != "string" {
+			return "", "", p.errorf(expr, "duration literal must have a unit, e.g. 200ms")
+		}
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return "", "", p.errorf(expr, "invalid duration literal")
+		}
+		return "int64", strconv.FormatInt(int64(d), 10), nil
+	case attribute.SpanKindKey:
+		val = strings.ToLower(val)
+		if typ != "string" || !spanKinds[val] {
+			return "", "", p.errorf(expr, "invalid span kind, want internal, server, client, producer or consumer")
+		}
+	case attribute.SpanStatusKey:
+		val = strings.ToUpper(val)
+		if typ != "string" || val != "UNSET" && val != "OK" && val != "ERROR" {
+			return "", "", p.errorf(expr, "invalid span status, want UNSET, OK or ERROR")
+		}
+	}
+	return typ, val, nil
+}
+
+// spanKinds are the values of the kind intrinsic.
+var spanKinds = map[string]bool{
+	"internal": true,
+	"server":   true,
+	"client":   true,
+	"producer": true,
+	"consumer": true,
+}
+
+// literal returns the type and textual value of a literal operand.
+func (p *parser) literal(expr sqlparser.Expr) (typ, val string, err error) {
+	neg := false
+	if u, ok := expr.(*sqlparser.UnaryExpr); ok && u.Operator == sqlparser.UMinusStr {
+		neg, expr = true, u.Expr
+	}
+	switch v := expr.(type) {
+	case *sqlparser.SQLVal:
+		switch v.Type {
+		case sqlparser.StrVal:
+			if !neg {
+				return "string", string(v.Val), nil
 			}
+		case sqlparser.IntVal:
+			typ = "int64"
+		case sqlparser.FloatVal:
+			typ = "float64"
+		default:
+			return "", "", p.errorf(expr, "unsupported literal")
+		}
+		val = string(v.Val)
+		if neg {
+			val = "-" + val
+		}
+		if typ == "int64" {
+			_, err = strconv.ParseInt(val, 10, 64)
 		} else {
-			fmt.Println("No WHERE clause found in the query")
+			_, err = strconv.ParseFloat(val, 64)
 		}
+		if err != nil {
+			return "", "", p.errorf(expr, "invalid %s literal", typ)
+		}
+		return typ, val, nil
+	case sqlparser.BoolVal:
+		if !neg {
+			return "bool", strconv.FormatBool(bool(v)), nil
+		}
+	case *sqlparser.ColName:
+		return "", "", p.errorf(expr, "right operand of a condition must be a literal")
+	}
+	return "", "", p.errorf(expr, "unsupported literal")
+}
 
-	// Handle other parts of the SELECT statement if needed
-	//case *sqlparser.Insert:
-	// Handle INSERT statement
-	//case *sqlparser.Update:
-	// Handle UPDATE statement
-	//case *sqlparser.Delete:
-	// Handle DELETE statement
-	default:
-		log.Fatalln("Error parsing SQL query:", err)
+// bounds returns the inclusive bounds of the values matching "op val".
+func bounds(typ, op, val string) (lb, ub string, err error) {
+	if typ == "int64" {
+		n, _ := strconv.ParseInt(val, 10, 64)
+		switch op {
+		case sqlparser.GreaterThanStr:
+			if n == math.MaxInt64 {
+				return "", "", fmt.Errorf("> %d never matches", n)
+			}
+			return strconv.FormatInt(n+1, 10), "", nil
+		case sqlparser.GreaterEqualStr:
+			return val, "", nil
+		case sqlparser.LessThanStr:
+			if n == math.MinInt64 {
+				return "", "", fmt.Errorf("< %d never matches", n)
+			}
+			return "", strconv.FormatInt(n-1, 10), nil
+		case sqlparser.LessEqualStr:
+			return "", val, nil
+		}
+	} else {
+		f, _ := strconv.ParseFloat(val, 64)
+		switch op {
+		case sqlparser.GreaterThanStr:
+			return strconv.FormatFloat(math.Nextafter(f, math.Inf(1)), 'g', -1, 64), "", nil
+		case sqlparser.GreaterEqualStr:
+			return val, "", nil
+		case sqlparser.LessThanStr:
+			return "", strconv.FormatFloat(math.Nextafter(f, math.Inf(-1)), 'g', -1, 64), nil
+		case sqlparser.LessEqualStr:
+			return "", val, nil
+		}
+	}
+	return "", "", fmt.Errorf("unsupported operator %s", op)
+}
+
+// CallChains links the "JOIN ... ON caller -> callee" conditions of the query
+// into service call chains, e.g. the joins app1 -> app2 and app2 -> app3 are
+// linked into {"app1", "app2", "app3"}. Each chain is a full path from a
+// service no join calls to a service calling none, so that a caller with
+// several callees, at any hop, starts a chain for each of them, e.g. the
+// joins app1 -> app2, app2 -> app3 and app2 -> app4 are linked into
+// {"app1", "app2", "app3"} and {"app1", "app2", "app4"}. A service is not
+// visited twice in a chain, the callers of a cycle without entry start it.
+func (q *Query) CallChains() [][]string {
+	var callers []string
+	callees := make(map[string][]string)
+	called := make(map[string]bool)
+	for _, join := range q.Join {
+		caller, callee, ok := strings.Cut(join, ">")
+		if !ok {
+			continue
+		}
+		caller, callee = strings.TrimSpace(caller), strings.TrimSpace(callee)
+		if _, ok := callees[caller]; !ok {
+			callers = append(callers, caller)
+		}
+		callees[caller] = append(callees[caller], callee)
+		called[callee] = true
+	}
+
+	var chains [][]string
+	visited := make(map[string]bool)
+	var walk func(path []string)
+	walk = func(path []string) {
+		last := path[len(path)-1]
+		visited[last] = true
+		extended := false
+		for _, callee := range callees[last] {
+			if inChain(path, callee) {
+				continue
+			}
+			extended = true
+			walk(append(path[:len(path):len(path)], callee))
+		}
+		if !extended && len(path) > 1 {
+			chains = append(chains, path)
+		}
 	}
+	for _, caller := range callers {
+		if !called[caller] {
+			walk([]string{caller})
+		}
+	}
+	// The services of cycles are only reached from one of them.
+	for _, caller := range callers {
+		if !visited[caller] {
+			walk([]string{caller})
+		}
+	}
+	return chains
+}
 
-	fmt.Println("\nWhereMap:", *queryOutput.Where)
-	getQueryAsJSON(&queryOutput)
+// inChain reports whether service is in chain.
+func inChain(chain []string, service string) bool {
+	for _, s := range chain {
+		if s == service {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,573 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryparser
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		sql  string
+		want *Query
+	}{
+		{
+			name: "select where",
+			sql: `SELECT app1.attr1, app2.attr2, app2.attr5
+				FROM app1, app2
+				WHERE app1.attr1 = 1 AND app2.attr2 > 2 AND app2.attr2 < 100 AND app1.attr4 = 'name'`,
+			want: &Query{
+				From:   []string{"app1", "app2"},
+				Select: map[string][]string{"app1": {"attr1"}, "app2": {"attr2", "attr5"}},
+				Where: &Condition{Op: OpAnd, Operands: []*Condition{
+					{Table: "app1", Key: "attr1", Filter: FilterBody{Type: "int64", LowerBound: "1", UpperBound: "1"}},
+					{Table: "app2", Key: "attr2", Filter: FilterBody{Type: "int64", LowerBound: "3"}},
+					{Table: "app2", Key: "attr2", Filter: FilterBody{Type: "int64", UpperBound: "99"}},
+					{Table: "app1", Key: "attr4", Filter: FilterBody{Type: "string", LowerBound: "name", UpperBound: "name"}},
+				}},
+				Join: []string{},
+			},
+		},
+		{
+			name: "boolean operators",
+			sql:  "SELECT * FROM app1 WHERE (app1.a = 1 OR NOT app1.b = 2) AND (app1.c = 3 OR app1.d = 4 OR app1.e = 5)",
+			want: &Query{
+				From:   []string{"app1"},
+				Select: map[string][]string{AllTables: {"*"}},
+				Where: &Condition{Op: OpAnd, Operands: []*Condition{
+					{Op: OpOr, Operands: []*Condition{
+						{Table: "app1", Key: "a", Filter: FilterBody{Type: "int64", LowerBound: "1", UpperBound: "1"}},
+						{Op: OpNot, Operands: []*Condition{
+							{Table: "app1", Key: "b", Filter: FilterBody{Type: "int64", LowerBound: "2", UpperBound: "2"}},
+						}},
+					}},
+					{Op: OpOr, Operands: []*Condition{
+						{Table: "app1", Key: "c", Filter: FilterBody{Type: "int64", LowerBound: "3", UpperBound: "3"}},
+						{Table: "app1", Key: "d", Filter: FilterBody{Type: "int64", LowerBound: "4", UpperBound: "4"}},
+						{Table: "app1", Key: "e", Filter: FilterBody{Type: "int64", LowerBound: "5", UpperBound: "5"}},
+					}},
+				}},
+				Join: []string{},
+			},
+		},
+		{
+			name: "joins",
+			sql:  "SELECT app1.attr1 FROM app1 JOIN app2 ON app1 -> app2 JOIN app3 ON app2 -> app3",
+			want: &Query{
+				From:   []string{"app1", "app2", "app3"},
+				Select: map[string][]string{"app1": {"attr1"}},
+				Join:   []string{"app1 > app2", "app2 > app3"},
+			},
+		},
+		{
+			name: "star",
+			sql:  "SELECT * FROM app1 WHERE app1.ratio >= -0.5 AND app1.ok = true",
+			want: &Query{
+				From:   []string{"app1"},
+				Select: map[string][]string{AllTables: {"*"}},
+				Where: &Condition{Op: OpAnd, Operands: []*Condition{
+					{Table: "app1", Key: "ratio", Filter: FilterBody{Type: "float64", LowerBound: "-0.5"}},
+					{Table: "app1", Key: "ok", Filter: FilterBody{Type: "bool", LowerBound: "true", UpperBound: "true"}},
+				}},
+				Join: []string{},
+			},
+		},
+		{
+			name: "dotted attributes",
+			sql:  "SELECT http.method, app1.net.peer, app1.`http.request.method` FROM app1 WHERE http.status_code <= 499",
+			want: &Query{
+				From:   []string{"app1"},
+				Select: map[string][]string{"app1": {"http.method", "net.peer", "http.request.method"}},
+				Where:  &Condition{Table: "app1", Key: "http.status_code", Filter: FilterBody{Type: "int64", UpperBound: "499"}},
+				Join:   []string{},
+			},
+		},
+		{
+			name: "in and between",
+			sql: `SELECT * FROM app1 WHERE app1.code IN (500, 502, 503) AND app1.method NOT IN ('GET')
+				AND (app1.code BETWEEN 500 AND 503 OR app1.code BETWEEN 510 AND 520) AND app1.ratio NOT BETWEEN 0 AND 0.5`,
+			want: &Query{
+				From:   []string{"app1"},
+				Select: map[string][]string{AllTables: {"*"}},
+				Where: &Condition{Op: OpAnd, Operands: []*Condition{
+					{Op: OpOr, Operands: []*Condition{
+						{Table: "app1", Key: "code", Filter: FilterBody{Type: "int64", LowerBound: "500", UpperBound: "500"}},
+						{Table: "app1", Key: "code", Filter: FilterBody{Type: "int64", LowerBound: "502", UpperBound: "502"}},
+						{Table: "app1", Key: "code", Filter: FilterBody{Type: "int64", LowerBound: "503", UpperBound: "503"}},
+					}},
+					{Op: OpNot, Operands: []*Condition{
+						{Table: "app1", Key: "method", Filter: FilterBody{Type: "string", LowerBound: "GET", UpperBound: "GET"}},
+					}},
+					{Op: OpOr, Operands: []*Condition{
+						{Table: "app1", Key: "code", Filter: FilterBody{Type: "int64", LowerBound: "500", UpperBound: "503"}},
+						{Table: "app1", Key: "code", Filter: FilterBody{Type: "int64", LowerBound: "510", UpperBound: "520"}},
+					}},
+					{Op: OpNot, Operands: []*Condition{
+						{Table: "app1", Key: "ratio", Filter: FilterBody{Type: "float64", LowerBound: "0", UpperBound: "0.5"}},
+					}},
+				}},
+				Join: []string{},
+			},
+		},
+		{
+			name: "string operators",
+			sql: `SELECT * FROM app1 WHERE app1.http.target LIKE '/api/%' AND app1.db.statement REGEXP '^SELECT'
+				AND app1.http.method != 'GET' AND app1.http.route NOT LIKE '%/health' AND app1.code <> 200`,
+			want: &Query{
+				From:   []string{"app1"},
+				Select: map[string][]string{AllTables: {"*"}},
+				Where: &Condition{Op: OpAnd, Operands: []*Condition{
+					{Table: "app1", Key: "http.target", Filter: FilterBody{Type: "string", Op: "like", LowerBound: "/api/%", UpperBound: "/api/%"}},
+					{Table: "app1", Key: "db.statement", Filter: FilterBody{Type: "string", Op: "regex", LowerBound: "^SELECT", UpperBound: "^SELECT"}},
+					{Op: OpNot, Operands: []*Condition{
+						{Table: "app1", Key: "http.method", Filter: FilterBody{Type: "string", LowerBound: "GET", UpperBound: "GET"}},
+					}},
+					{Op: OpNot, Operands: []*Condition{
+						{Table: "app1", Key: "http.route", Filter: FilterBody{Type: "string", Op: "like", LowerBound: "%/health", UpperBound: "%/health"}},
+					}},
+					{Op: OpNot, Operands: []*Condition{
+						{Table: "app1", Key: "code", Filter: FilterBody{Type: "int64", LowerBound: "200", UpperBound: "200"}},
+					}},
+				}},
+				Join: []string{},
+			},
+		},
+		{
+			name: "array operators",
+			sql: `SELECT * FROM app1 WHERE 'x' IN app1.tags AND 500 not in app1.codes AND app1.c != 'a IN app1.b'
+				AND CONTAINS_ANY(app1.tags, 'a', 'b') AND length(app1.tags) > 2 AND LENGTH(app1.codes) BETWEEN 1 AND 3`,
+			want: &Query{
+				From:   []string{"app1"},
+				Select: map[string][]string{AllTables: {"*"}},
+				Where: &Condition{Op: OpAnd, Operands: []*Condition{
+					{Table: "app1", Key: "tags", Filter: FilterBody{Type: "string", Op: "contains_all", LowerBound: "x", UpperBound: "x"}},
+					{Op: OpNot, Operands: []*Condition{
+						{Table: "app1", Key: "codes", Filter: FilterBody{Type: "int64", Op: "contains_all", LowerBound: "500", UpperBound: "500"}},
+					}},
+					{Op: OpNot, Operands: []*Condition{
+						{Table: "app1", Key: "c", Filter: FilterBody{Type: "string", LowerBound: "a IN app1.b", UpperBound: "a IN app1.b"}},
+					}},
+					{Op: OpOr, Operands: []*Condition{
+						{Table: "app1", Key: "tags", Filter: FilterBody{Type: "string", Op: "contains_all", LowerBound: "a", UpperBound: "a"}},
+						{Table: "app1", Key: "tags", Filter: FilterBody{Type: "string", Op: "contains_all", LowerBound: "b", UpperBound: "b"}},
+					}},
+					{Table: "app1", Key: "tags", Filter: FilterBody{Type: "int64", Op: "length", LowerBound: "3"}},
+					{Table: "app1", Key: "codes", Filter: FilterBody{Type: "int64", Op: "length", LowerBound: "1", UpperBound: "3"}},
+				}},
+				Join: []string{},
+			},
+		},
+		{
+			name: "events",
+			sql:  "SELECT app1.a, app1.events.exception, app1.events.`db.query`, app2.events.* FROM app1, app2",
+			want: &Query{
+				From:   []string{"app1", "app2"},
+				Select: map[string][]string{"app1": {"a"}},
+				Join:   []string{},
+				Events: map[string][]string{"app1": {"exception", "db.query"}, "app2": {"*"}},
+			},
+		},
+		{
+			name: "events of the only table",
+			sql:  "SELECT events.exception, events.* FROM app1",
+			want: &Query{
+				From:   []string{"app1"},
+				Select: map[string][]string{},
+				Join:   []string{},
+				Events: map[string][]string{"app1": {"exception", "*"}},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(test.sql)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		sql  string
+		near string
+		msg  string
+	}{
+		{
+			name: "syntax",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.a >",
+			msg:  "syntax error",
+		},
+		{
+			name: "statement",
+			sql:  "DELETE FROM app1",
+			msg:  "only SELECT statements are supported",
+		},
+		{
+			name: "unknown table",
+			sql:  "SELECT app1.a, app2.b FROM app1, app3",
+			near: "app2.b",
+			msg:  `unknown table "app2"`,
+		},
+		{
+			name: "unqualified column",
+			sql:  "SELECT a FROM app1, app2",
+			near: "a",
+			msg:  `column "a" must be qualified by a table`,
+		},
+		{
+			name: "string range",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.name > 'x'",
+			near: "app1.name > 'x'",
+			msg:  "unsupported comparison > for string type",
+		},
+		{
+			name: "string between",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.name BETWEEN 'a' AND 'b'",
+			msg:  "unsupported comparison BETWEEN for string type",
+		},
+		{
+			name: "between mixed types",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.code BETWEEN 1 AND 'b'",
+			msg:  "bounds of int64 and string types",
+		},
+		{
+			name: "in subquery",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.code IN (SELECT a FROM app2)",
+			msg:  "right operand of IN must be a list of literals",
+		},
+		{
+			name: "like number",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.code LIKE 5",
+			near: "5",
+			msg:  "LIKE pattern must be a string",
+		},
+		{
+			name: "invalid regexp",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.a REGEXP 'a('",
+			near: "'a('",
+			msg:  "invalid regular expression: error parsing regexp: missing closing ): `a(`",
+		},
+		{
+			name: "like escape",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.a LIKE 'a!%' ESCAPE '!'",
+			msg:  "ESCAPE is not supported, patterns are escaped by a backslash",
+		},
+		{
+			name: "error after contains",
+			sql:  "SELECT app1.a FROM app1 WHERE 'x' IN app1.tags AND app1.a = app1.b",
+			near: "app1.b",
+			msg:  "right operand of a condition must be a literal",
+		},
+		{
+			name: "length of string",
+			sql:  "SELECT app1.a FROM app1 WHERE LENGTH(app1.tags) > 'b'",
+			near: "'b'",
+			msg:  "LENGTH compared to string type",
+		},
+		{
+			name: "length like",
+			sql:  "SELECT app1.a FROM app1 WHERE LENGTH(app1.tags) LIKE '1%'",
+			msg:  "unsupported comparison LIKE of LENGTH",
+		},
+		{
+			name: "contains without literal",
+			sql:  "SELECT app1.a FROM app1 WHERE contains_any(app1.tags)",
+			msg:  "CONTAINS_ANY takes a column and literals",
+		},
+		{
+			name: "unknown function",
+			sql:  "SELECT app1.a FROM app1 WHERE lower(app1.a)",
+			msg:  "unsupported condition",
+		},
+		{
+			name: "column operand",
+			sql:  "SELECT app1.a FROM app1 WHERE app1.a = app1.b",
+			near: "app1.b",
+			msg:  "right operand of a condition must be a literal",
+		},
+		{
+			name: "join condition",
+			sql:  "SELECT app1.a FROM app1 JOIN app2 ON app1 < app2",
+			near: "app1 < app2",
+			msg:  "JOIN condition must be caller -> callee",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.sql)
+			var pe *ParseError
+			require.ErrorAs(t, err, &pe)
+			assert.Equal(t, test.msg, pe.Msg)
+			if test.near != "" {
+				assert.Equal(t, test.near, pe.Near)
+				assert.Equal(t, strings.Index(test.sql, test.near), pe.Pos)
+			}
+		})
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := Parse("SELECT app1.a FROM app1 WHERE app1.a >")
+	assert.EqualError(t, err, "queryparser: syntax error at position 39")
+
+	// Positions after a rewritten "literal IN column" refer to the query.
+	_, err = Parse("SELECT app1.a FROM app1 WHERE 'x' IN app1.tags AND app1.a >")
+	assert.EqualError(t, err, "queryparser: syntax error at position 60")
+}
+
+func TestQueryMarshalJSON(t *testing.T) {
+	q, err := Parse(`SELECT app1.a, app1.b, app2.* FROM app1, app2
+		WHERE (app1.a > 1 OR app1.c = 'x') AND app2.d <= 2.5 AND app1.e = true`)
+	require.NoError(t, err)
+	got, err := json.Marshal(q)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"app1": {
+			"filters": [
+				{"key": "a", "type": "", "values": []},
+				{"key": "b", "type": "", "values": []}
+			],
+			"where": {"and": [
+				{"or": [
+					{"key": "a", "type": "int64", "values": [2, 9223372036854775807]},
+					{"key": "c", "type": "string", "values": ["x"]}
+				]},
+				{"key": "e", "type": "bool", "values": [true]}
+			]}
+		},
+		"app2": {
+			"filters": [{"key": "*", "type": "", "values": []}],
+			"where": {"key": "d", "type": "float64", "values": [-1.7976931348623157e+308, 2.5]}
+		}
+	}`, string(got))
+}
+
+func TestQueryMarshalJSONEvents(t *testing.T) {
+	q, err := Parse(`SELECT app1.a, app1.events.exception FROM app1, app2`)
+	require.NoError(t, err)
+	got, err := json.Marshal(q)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"app1": {"filters": [{"key": "a", "type": "", "values": []}], "events": ["exception"]},
+		"app2": {"filters": []}
+	}`, string(got))
+}
+
+func TestQueryMarshalJSONMixedTables(t *testing.T) {
+	q, err := Parse(`SELECT * FROM app1, app2 WHERE app1.a = 1 OR app2.b = 2`)
+	require.NoError(t, err)
+	_, err = json.Marshal(q)
+	assert.Error(t, err)
+}
+
+func TestReadQuery(t *testing.T) {
+	got, err := ReadQuery(strings.NewReader("SELECT app1.a\n  FROM app1\nend\nignored"))
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT app1.a FROM app1 ", got)
+}
+
+func TestCallChains(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		joins []string
+		want  [][]string
+	}{
+		{
+			name: "no join",
+		},
+		{
+			name:  "single call",
+			joins: []string{"app1 > app2"},
+			want:  [][]string{{"app1", "app2"}},
+		},
+		{
+			name:  "chain in parser order",
+			joins: []string{"app2 > app3", "app1 > app2"},
+			want:  [][]string{{"app1", "app2", "app3"}},
+		},
+		{
+			name:  "chain linked by a later join",
+			joins: []string{"app1 > app2", "app3 > app4", "app2 > app3"},
+			want:  [][]string{{"app1", "app2", "app3", "app4"}},
+		},
+		{
+			name:  "fan out",
+			joins: []string{"app1 > app2", "app1 > app3"},
+			want:  [][]string{{"app1", "app2"}, {"app1", "app3"}},
+		},
+		{
+			name:  "fan out after the first hop",
+			joins: []string{"app1 > app2", "app2 > app3", "app2 > app4"},
+			want:  [][]string{{"app1", "app2", "app3"}, {"app1", "app2", "app4"}},
+		},
+		{
+			name:  "shared callee",
+			joins: []string{"app1 > app3", "app2 > app3", "app3 > db"},
+			want:  [][]string{{"app1", "app3", "db"}, {"app2", "app3", "db"}},
+		},
+		{
+			name:  "cycle",
+			joins: []string{"app1 > app2", "app2 > app1"},
+			want:  [][]string{{"app1", "app2"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			q := Query{Join: test.joins}
+			assert.Equal(t, test.want, q.CallChains())
+		})
+	}
+}
+
+func TestParseBounds(t *testing.T) {
+	q, err := Parse("SELECT app1.a FROM app1 WHERE app1.b = 1 FOR 10 MINUTES limit 1000 spans STARTING AT '2024-05-01T10:00:00Z';")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, q.For)
+	assert.Equal(t, int64(1000), q.MaxSpans)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), q.Start.UTC())
+	unbounded, err := Parse("SELECT app1.a FROM app1 WHERE app1.b = 1")
+	require.NoError(t, err)
+	assert.Equal(t, unbounded.ID(), q.ID(), "the lifetime clauses should not be part of the ID")
+
+	q, err = Parse("SELECT app1.a FROM app1 WHERE app1.b = 'x FOR 1 HOUR'")
+	require.NoError(t, err)
+	assert.Zero(t, q.For, "clauses in strings should be kept")
+	assert.Equal(t, "x FOR 1 HOUR", q.Where.Filter.LowerBound)
+
+	for sql, msg := range map[string]string{
+		"SELECT app1.a FROM app1 FOR 1 HOUR FOR 2 HOURS":       "duplicate FOR clause",
+		"SELECT app1.a FROM app1 LIMIT 0 SPANS":                "invalid LIMIT span count",
+		"SELECT app1.a FROM app1 FOR 0 SECONDS":                "invalid FOR duration",
+		"SELECT app1.a FROM app1 STARTING AT 'tomorrow'":       "invalid STARTING AT time, want RFC 3339",
+		"SELECT app1.a FROM app1 FOR 9999999999999999 DAYS":    "invalid FOR duration",
+		"SELECT app1.a FROM app1 LIMIT 5 SPANS WHERE app1.a >": "syntax error",
+	} {
+		_, err := Parse(sql)
+		var pe *ParseError
+		require.ErrorAs(t, err, &pe, sql)
+		assert.Equal(t, msg, pe.Msg, sql)
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	q, err := Parse("SELECT http.route, COUNT(*) AS errors, AVG(@duration), PERCENTILE(@duration, 99.9) " +
+		"FROM checkout WHERE http.status_code >= 500 GROUP BY http.route")
+	require.NoError(t, err)
+	assert.Equal(t, []Aggregate{
+		{Func: AggCount, Column: "*", Alias: "errors"},
+		{Func: AggAvg, Table: "checkout", Column: "@duration"},
+		{Func: AggPercentile, Table: "checkout", Column: "@duration", Percentile: 99.9},
+	}, q.Aggregates)
+	assert.Equal(t, []string{"http.route"}, q.GroupBy)
+	assert.ErrorIs(t, Apply(q), errAggregateQuery)
+
+	plain, err := Parse("SELECT app1.a FROM app1")
+	require.NoError(t, err)
+	q, err = Parse("SELECT app1.a, COUNT(app1.*) FROM app1 GROUP BY app1.a")
+	require.NoError(t, err)
+	assert.NotEqual(t, plain.ID(), q.ID(), "the aggregates should be part of the ID")
+
+	for sql, msg := range map[string]string{
+		"SELECT FOO(a) FROM app1":                           "unsupported aggregate function FOO",
+		"SELECT AVG(*) FROM app1":                           "only COUNT aggregates *",
+		"SELECT COUNT(DISTINCT a) FROM app1":                "DISTINCT aggregates are not supported",
+		"SELECT PERCENTILE(a) FROM app1":                    "PERCENTILE takes 2 argument(s)",
+		"SELECT PERCENTILE(a, 101) FROM app1":               "percentile must be in (0, 100]",
+		"SELECT a, COUNT(*) FROM app1":                      `column "a" must be aggregated or grouped by`,
+		"SELECT a FROM app1 GROUP BY a":                     "GROUP BY requires aggregate functions",
+		"SELECT COUNT(*), app1.events.exception FROM app1":  "events cannot be selected with aggregate functions",
+		"SELECT COUNT(*) FROM app1 GROUP BY LENGTH(app1.a)": "GROUP BY only supports columns",
+		"SELECT SUM(app1.a + 1) FROM app1":                  "SUM argument must be a column",
+	} {
+		_, err := Parse(sql)
+		var pe *ParseError
+		require.ErrorAs(t, err, &pe, sql)
+		assert.Equal(t, msg, pe.Msg, sql)
+	}
+}
+
+func TestParseIntrinsics(t *testing.T) {
+	q, err := Parse("SELECT app1.a FROM app1 WHERE app1.@duration > 200ms AND app1.@status = 'error' AND app1.@kind IN ('SERVER', 'consumer')")
+	require.NoError(t, err)
+	f := attribute.NewMapTraceAttributeFilter()
+	_, err = Compile(q, f)
+	require.NoError(t, err)
+
+	span := func(d time.Duration, status, kind string) []attribute.KeyValue {
+		return []attribute.KeyValue{
+			attribute.SpanDurationKey.Int64(int64(d)),
+			attribute.SpanStatusKey.String(status),
+			attribute.SpanKindKey.String(kind),
+		}
+	}
+	assert.True(t, selected(f, span(time.Second, "ERROR", "server")))
+	assert.True(t, selected(f, span(time.Second, "ERROR", "consumer")))
+	assert.False(t, selected(f, span(100*time.Millisecond, "ERROR", "server")))
+	assert.False(t, selected(f, span(time.Second, "OK", "server")))
+	assert.False(t, selected(f, span(time.Second, "ERROR", "client")))
+	assert.False(t, selected(f, []attribute.KeyValue{attribute.String("duration", "1s")}), "attributes named after intrinsics should not match")
+
+	q, err = Parse("SELECT app1.a FROM app1 WHERE app1.duration = 'slow' AND app1.status = 404 AND app1.@duration >= 150ms AND app1.@duration IN (1s, 2s)")
+	require.NoError(t, err)
+	assert.Equal(t, "duration", q.Where.Operands[0].Key, "attributes named after intrinsics should not be rewritten")
+	assert.Equal(t, "status", q.Where.Operands[1].Key)
+	f = attribute.NewMapTraceAttributeFilter()
+	_, err = Compile(q, f)
+	require.NoError(t, err)
+	assert.True(t, selected(f, []attribute.KeyValue{
+		attribute.String("duration", "slow"),
+		attribute.Int("status", 404),
+		attribute.SpanDurationKey.Int64(int64(time.Second)),
+	}))
+
+	q, err = Parse("SELECT app1.a FROM app1 WHERE app1.@duration BETWEEN '1.5s' AND 2s AND app1.@name = 'GET /cart' AND app1.@scope = 'net/http'")
+	require.NoError(t, err)
+	assert.Equal(t, string(attribute.SpanNameKey), q.Where.Operands[1].Key)
+	f = attribute.NewMapTraceAttributeFilter()
+	_, err = Compile(q, f)
+	require.NoError(t, err)
+	assert.True(t, selected(f, []attribute.KeyValue{
+		attribute.SpanDurationKey.Int64(int64(1800 * time.Millisecond)),
+		attribute.SpanNameKey.String("GET /cart"),
+		attribute.SpanScopeKey.String("net/http"),
+	}))
+
+	q, err = Parse("SELECT app1.a FROM app1 WHERE app1.@name = 'GET 200ms' AND app1.b = 1")
+	require.NoError(t, err)
+	assert.Equal(t, "GET 200ms", q.Where.Operands[0].Filter.LowerBound, "durations in strings should be kept")
+
+	for sql, msg := range map[string]string{
+		"SELECT app1.a FROM app1 WHERE app1.@duration > 200":     "duration literal must have a unit, e.g. 200ms",
+		"SELECT app1.a FROM app1 WHERE app1.@duration > 'fast'":  "invalid duration literal",
+		"SELECT app1.a FROM app1 WHERE app1.@kind = 'remote'":    "invalid span kind, want internal, server, client, producer or consumer",
+		"SELECT app1.a FROM app1 WHERE app1.@status = 'FAILED'":  "invalid span status, want UNSET, OK or ERROR",
+		"SELECT app1.a FROM app1 WHERE app1.@duration > 200ms +": "syntax error",
+		"SELECT app1.a FROM app1 WHERE app1.ttl > 5m":            "syntax error",
+		"SELECT app1.a FROM app1 WHERE app1.@size > 1":           `unknown intrinsic column "@size", want @name, @kind, @status, @duration or @scope`,
+	} {
+		_, err := Parse(sql)
+		var pe *ParseError
+		require.ErrorAs(t, err, &pe, sql)
+		assert.Equal(t, msg, pe.Msg, sql)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryparser // import "go.opentelemetry.io/otel/sdk/queryparser"
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+)
+
+// QueryFilterFileEnv is the environment variable naming the query definition
+// file watched by WatchEnvFile.
+const QueryFilterFileEnv = "OTEL_QUERY_FILTER_FILE"
+
+// DefaultWatchInterval is the interval between two reads of the watched
+// query definition file used by WatchEnvFile.
+const DefaultWatchInterval = 10 * time.Second
+
+// ApplyFile replaces the global trace filter by the query definition of the
+// file at path, see ApplyDefinition.
+func ApplyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ApplyDefinition(data)
+}
+
+// ApplyDefinition replaces the global trace filter by the query definition
+// data. A definition starting with "{" is the JSON form of a query, see
+// Query.MarshalJSON, any other one is a query of the SQL dialect, see Parse.
+// An empty definition removes the query, disabling all the filters. The
+// definition replaces the bounded query installed, if any.
+//
+// The definition is validated before it is installed, the trace filter is
+// left unchanged if it is invalid. As with Apply, the new configuration is
+// published at once.
+func ApplyDefinition(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0:
+		return global.InstallQuery(global.QueryBounds{}, func() error {
+			otel.GetTraceAttributeFilter().Clear()
+			otel.ClearScopedTraceAttributeFilters()
+			otel.GetTraceEventFilter().Clear()
+			otel.SetTraceStructuralPatterns()
+			otel.SetQueryID("")
+			otel.SetAttributeFilterConfig()
+			return nil
+		})
+	case data[0] == '{':
+		return applyJSON(data)
+	default:
+		q, err := Parse(string(data))
+		if err != nil {
+			return err
+		}
+		return Apply(q)
+	}
+}
+
+// ApplyNamedDefinition installs the query definition data as the named query
+// called name, see ApplyNamed. As for ApplyDefinition, a definition starting
+// with "{" is the JSON form of a query and any other one a query of the SQL
+// dialect, whose lifetime clauses bound the named query. An empty definition
+// removes the named query.
+//
+// The definition is validated before it is installed, the named query is
+// left unchanged if it is invalid.
+func ApplyNamedDefinition(name string, data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case name == "":
+		return fmt.Errorf("queryparser: empty query name")
+	case len(data) == 0:
+		RemoveNamed(name)
+		return nil
+	case data[0] == '{':
+		return global.InstallNamedQueryJSON(name, data, global.QueryBounds{})
+	default:
+		q, err := Parse(string(data))
+		if err != nil {
+			return err
+		}
+		return ApplyNamed(name, q)
+	}
+}
+
+// applyJSON installs data, the JSON form of a query, as Apply installs a
+// parsed query, with global.InstallQueryJSON. The JSON form carries no call
+// chain, the structural patterns are removed. The query ID is derived from
+// data.
+func applyJSON(data []byte) error {
+	// Nothing is installed if data is invalid, the previous query still
+	// applies.
+	return global.InstallQueryJSON(data, func(flag global.FilterConfigFlag) {
+		f := attribute.NewMapTraceAttributeFilter()
+		f.SetCondition(attribute.Or())
+		otel.GetTraceAttributeFilter().Restore(f.Snapshot())
+		otel.SetTraceStructuralPatterns()
+		sum := sha256.Sum256(data)
+		otel.SetQueryID(hex.EncodeToString(sum[:8]))
+		otel.SetAttributeFilterConfig(flag, global.AttributeNotMatchFullTraceFilter)
+	})
+}
+
+// FileWatcher applies a query definition file, or the named query
+// definitions of a directory, and applies them again every time they change.
+type FileWatcher struct {
+	path     string
+	interval time.Duration
+
+	// last is the content of the file last applied or rejected, and named
+	// the ones of the named queries of a watched directory by name, nil when
+	// watching a file.
+	last  []byte
+	named map[string][]byte
+	// lastErrs are the last errors reported by file, not to report them
+	// again on every read.
+	lastErrs map[string]string
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+// WatchFile applies the query definition file at path, see ApplyDefinition,
+// then reads it every interval to apply it again when its content changes,
+// until Stop is called. The file may be replaced rather than written to, as
+// a mounted config map is.
+//
+// A file that cannot be read or applied leaves the last query applied in
+// place, the error is reported with otel.Handle.
+func WatchFile(path string, interval time.Duration) *FileWatcher {
+	return newFileWatcher(path, interval, nil)
+}
+
+// WatchDir applies the query definition files of the directory dir as named
+// queries, see ApplyNamedDefinition, then reads them every interval to apply
+// them again when their content changes, until Stop is called. A query is
+// named after its file, without the extension, e.g. errors for errors.sql.
+// The named query of a file removed from dir is removed. The files whose name
+// starts with "." and the subdirectories are ignored, so that dir may be a
+// mounted config map.
+//
+// A file that cannot be read or applied leaves the last named query applied
+// from it in place, the error is reported with otel.Handle.
+func WatchDir(dir string, interval time.Duration) *FileWatcher {
+	return newFileWatcher(dir, interval, make(map[string][]byte))
+}
+
+// newFileWatcher returns a FileWatcher of the file at path, or of the named
+// query definitions of the directory at path if named is not nil, once it
+// checked them a first time.
+func newFileWatcher(path string, interval time.Duration, named map[string][]byte) *FileWatcher {
+	w := &FileWatcher{
+		path:     path,
+		interval: interval,
+		named:    named,
+		lastErrs: make(map[string]string),
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	w.check()
+	go w.run()
+	return w
+}
+
+// WatchEnvFile watches the file named by the OTEL_QUERY_FILTER_FILE
+// environment variable every DefaultWatchInterval, see WatchFile. It returns
+// false, and watches nothing, if the variable is not set.
+func WatchEnvFile() (*FileWatcher, bool) {
+	path := os.Getenv(QueryFilterFileEnv)
+	if path == "" {
+		return nil, false
+	}
+	return WatchFile(path, DefaultWatchInterval), true
+}
+
+func (w *FileWatcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check applies the file, or the files of the directory, if their content
+// changed since the last check.
+func (w *FileWatcher) check() {
+	if w.named != nil {
+		w.checkDir()
+		return
+	}
+	data, err := os.ReadFile(w.path)
+	if err == nil {
+		if w.last != nil && bytes.Equal(data, w.last) {
+			return
+		}
+		w.last = data
+		err = ApplyDefinition(data)
+	}
+	w.report(w.path, err)
+}
+
+// checkDir applies the files of the directory whose content changed since the
+// last check, and removes the named queries of the files removed.
+func (w *FileWatcher) checkDir() {
+	entries, err := os.ReadDir(w.path)
+	w.report(w.path, err)
+	if err != nil {
+		// The named queries stay in place.
+		return
+	}
+	// seen are the names of the named queries of the files in the
+	// directory, and files the paths of these files.
+	seen := make(map[string]bool, len(entries))
+	files := map[string]bool{w.path: true}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(w.path, entry.Name())
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files[path] = true
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if seen[name] {
+			w.report(path, fmt.Errorf("another file defines the named query %s", name))
+			continue
+		}
+		seen[name] = true
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if last, ok := w.named[name]; ok && bytes.Equal(data, last) {
+				continue
+			}
+			w.named[name] = data
+			err = ApplyNamedDefinition(name, data)
+		}
+		w.report(path, err)
+	}
+	for name := range w.named {
+		if !seen[name] {
+			delete(w.named, name)
+			RemoveNamed(name)
+		}
+	}
+	for path := range w.lastErrs {
+		if !files[path] {
+			delete(w.lastErrs, path)
+		}
+	}
+}
+
+// report reports err, the error of the file at path, with otel.Handle unless
+// it was the last one reported for that file. A nil err clears it.
+func (w *FileWatcher) report(path string, err error) {
+	if err == nil {
+		delete(w.lastErrs, path)
+		return
+	}
+	if err.Error() != w.lastErrs[path] {
+		w.lastErrs[path] = err.Error()
+		otel.Handle(fmt.Errorf("queryparser: query filter file %s: %w", path, err))
+	}
+}
+
+// Stop stops watching the file or the directory, the last queries applied
+// stay in place.
+func (w *FileWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+	<-w.done
+}
@@ -0,0 +1,252 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryparser
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+)
+
+// resetQuery restores the global trace filter at the end of t.
+func resetQuery(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		otel.GetTraceAttributeFilter().Clear()
+		otel.ClearScopedTraceAttributeFilters()
+		otel.GetTraceEventFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+		global.SetTraceStructuralPatterns(nil)
+		global.SetQueryID("")
+	})
+}
+
+// errorRecorder is an ErrorHandler recording the errors handled.
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *errorRecorder) Handle(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *errorRecorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.errs)
+}
+
+func recordErrors(t *testing.T) *errorRecorder {
+	r := &errorRecorder{}
+	otel.SetErrorHandler(r)
+	t.Cleanup(func() {
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) { log.Print(err) }))
+	})
+	return r
+}
+
+func TestApplyDefinition(t *testing.T) {
+	resetQuery(t)
+
+	require.NoError(t, ApplyDefinition([]byte("SELECT app1.a FROM app1 WHERE app1.b = 1\n")))
+	assert.Equal(t, []string{"app1"}, global.ScopedTraceAttributeFilterTables())
+	id := otel.GetQueryID()
+	assert.NotEmpty(t, id)
+
+	q, err := Parse("SELECT app2.*, app2.events.exception FROM app2 WHERE app2.c = 'x'")
+	require.NoError(t, err)
+	data, err := json.Marshal(q)
+	require.NoError(t, err)
+	require.NoError(t, ApplyDefinition(data))
+	assert.Equal(t, []string{"app2"}, global.ScopedTraceAttributeFilterTables())
+	app2 := global.TraceAttributeFilterFor("app2", "")
+	assert.True(t, selected(app2, []attribute.KeyValue{attribute.String("c", "x")}))
+	assert.False(t, selected(global.TraceAttributeFilterFor("app1", ""), nil), "services outside of the query should be dropped")
+	assert.True(t, otel.GetTraceEventFilter().Match("exception", attribute.InvalidValue()))
+	assert.Equal(t, global.AttributeFilter|global.AttributeNotMatchFullTraceFilter|global.EventFilter, global.FilterConfigFlags())
+	assert.NotEqual(t, id, otel.GetQueryID())
+
+	flags := global.FilterConfigFlags()
+	assert.Error(t, ApplyDefinition([]byte("SELECT FROM")))
+	assert.Error(t, ApplyDefinition([]byte(`{"app3": {"filters": [{"key": "a", "type": "int64", "values": ["x"]}]}}`)))
+	assert.Equal(t, []string{"app2"}, global.ScopedTraceAttributeFilterTables(), "an invalid definition should change nothing")
+	assert.Equal(t, flags, global.FilterConfigFlags())
+
+	require.NoError(t, ApplyDefinition([]byte(" \n")))
+	assert.Empty(t, global.ScopedTraceAttributeFilterTables())
+	assert.Zero(t, global.FilterConfigFlags(), "an empty definition should remove the query")
+	assert.Empty(t, otel.GetQueryID())
+}
+
+func TestWatchFile(t *testing.T) {
+	resetQuery(t)
+	errs := recordErrors(t)
+
+	path := filepath.Join(t.TempDir(), "query.sql")
+	write := func(content string) {
+		// Replace the file as a config map update does.
+		tmp := path + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, []byte(content), 0o600))
+		require.NoError(t, os.Rename(tmp, path))
+	}
+	tables := func() []string { return global.ScopedTraceAttributeFilterTables() }
+
+	w := WatchFile(path, 5*time.Millisecond)
+	defer w.Stop()
+	assert.Equal(t, 1, errs.len(), "a missing file should be reported")
+
+	write("SELECT app1.a FROM app1")
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"app1"}, tables()) }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, errs.len())
+
+	write("SELECT FROM app2")
+	assert.Eventually(t, func() bool { return errs.len() == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, errs.len(), "an error should be reported once")
+	assert.Equal(t, []string{"app1"}, tables(), "the last good query should be kept")
+	assert.NotZero(t, global.FilterConfigFlags())
+
+	write(`{"app2": {"filters": [{"key": "b", "type": "", "values": []}]}}`)
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"app2"}, tables()) }, time.Second, 5*time.Millisecond)
+
+	w.Stop()
+	write("SELECT app3.a FROM app3")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"app2"}, tables(), "a stopped watcher should not apply changes")
+}
+
+func TestApplyNamedDefinition(t *testing.T) {
+	t.Cleanup(global.ClearNamedQueries)
+
+	require.NoError(t, ApplyNamedDefinition("sql", []byte("SELECT app1.a FROM app1")))
+	require.NoError(t, ApplyNamedDefinition("json", []byte(`{"app2": {"filters": [{"key": "b", "type": "", "values": []}]}}`)))
+	assert.Empty(t, global.ScopedTraceAttributeFilterTables(), "the global trace filter should not change")
+	q, ok := global.LookupNamedQuery("json")
+	require.True(t, ok)
+	assert.True(t, q.FilterFor("app2", "").Match("b", attribute.InvalidValue()))
+	assert.False(t, q.Filter.BatchMayMatch(nil), "services outside of the query should not be selected")
+
+	assert.Error(t, ApplyNamedDefinition("json", []byte(`{"app2": {"filters": [{"key": "b", "type": "map", "values": [1]}]}}`)))
+	assert.Error(t, ApplyNamedDefinition("sql", []byte("SELECT FROM")))
+	assert.Error(t, ApplyNamedDefinition("", []byte("SELECT app1.a FROM app1")))
+	assert.Len(t, global.NamedQueries(), 2, "invalid definitions should not be applied")
+
+	require.NoError(t, ApplyNamedDefinition("sql", nil))
+	_, ok = global.LookupNamedQuery("sql")
+	assert.False(t, ok, "an empty definition should remove the named query")
+}
+
+func TestWatchDir(t *testing.T) {
+	t.Cleanup(global.ClearNamedQueries)
+	errs := recordErrors(t)
+
+	dir := t.TempDir()
+	write := func(file, content string) {
+		tmp := filepath.Join(dir, ".tmp")
+		require.NoError(t, os.WriteFile(tmp, []byte(content), 0o600))
+		require.NoError(t, os.Rename(tmp, filepath.Join(dir, file)))
+	}
+	names := func() []string {
+		var out []string
+		for _, q := range global.NamedQueries() {
+			out = append(out, q.Name)
+		}
+		return out
+	}
+	write("errors.sql", "SELECT app1.a FROM app1")
+	write(".hidden", "SELECT app2.a FROM app2")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+
+	w := WatchDir(dir, 5*time.Millisecond)
+	defer w.Stop()
+	assert.Equal(t, []string{"errors"}, names(), "the files should be applied at once")
+	assert.Zero(t, errs.len())
+
+	write("slow.json", `{"app2": {}}`)
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"errors", "slow"}, names()) }, time.Second, 5*time.Millisecond)
+
+	write("errors.sql", "SELECT FROM app1")
+	assert.Eventually(t, func() bool { return errs.len() == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, errs.len(), "an error should be reported once")
+	assert.Equal(t, []string{"errors", "slow"}, names(), "the last good query should be kept")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "errors.sql")))
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"slow"}, names()) }, time.Second, 5*time.Millisecond)
+
+	w.Stop()
+	write("late.sql", "SELECT app3.a FROM app3")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"slow"}, names(), "a stopped watcher should not apply changes")
+}
+
+func TestWatchEnvFile(t *testing.T) {
+	resetQuery(t)
+
+	t.Setenv(QueryFilterFileEnv, "")
+	_, ok := WatchEnvFile()
+	assert.False(t, ok)
+
+	path := filepath.Join(t.TempDir(), "query.sql")
+	require.NoError(t, os.WriteFile(path, []byte("SELECT app1.a FROM app1"), 0o600))
+	t.Setenv(QueryFilterFileEnv, path)
+	w, ok := WatchEnvFile()
+	require.True(t, ok)
+	defer w.Stop()
+	assert.Equal(t, []string{"app1"}, global.ScopedTraceAttributeFilterTables(), "the file should be applied at once")
+}
+
+func TestApplyDefinitionConsistent(t *testing.T) {
+	resetQuery(t)
+	definitions := [][]byte{
+		[]byte("SELECT app1.a FROM app1 WHERE app1.b = 1"),
+		[]byte(`{"app2": {"filters": [{"key": "c", "type": "string", "values": ["x"]}]}}`),
+	}
+	require.NoError(t, ApplyDefinition(definitions[0]))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = ApplyDefinition(definitions[i%2])
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		st := global.CurrentFilterState()
+		require.NotZero(t, st.Flags&global.AttributeNotMatchFullTraceFilter, "spans should never be exported unfiltered")
+		_, app1 := st.Scoped["app1"]
+		_, app2 := st.Scoped["app2"]
+		require.True(t, app1 != app2, "a state should hold a single query")
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace // import "go.opentelemetry.io/otel/sdk/trace"
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+)
+
+// filteredSpan is a span that went through FilterSpans, with its attributes,
+// events and links projected by the query filter. Filtering it again leaves
+// it unchanged.
+type filteredSpan struct {
+	ReadOnlySpan
+
+	attributes []attribute.KeyValue
+	events     []Event
+	links      []Link
+}
+
+// Attributes returns the attributes of the span kept by the query filter.
+func (s *filteredSpan) Attributes() []attribute.KeyValue {
+	return s.attributes
+}
+
+// Events returns the events of the span kept by the query filter.
+func (s *filteredSpan) Events() []Event {
+	return s.events
+}
+
+// Links returns the links of the span, with their attributes kept by the
+// query filter.
+func (s *filteredSpan) Links() []Link {
+	return s.links
+}
+
+// QueryIDsKey is the attribute naming the queries that selected an exported
+// span, set by FilterSpans while named queries are registered, see
+// SetNamedQuery of go.opentelemetry.io/otel/internal/global. The global query
+// filter is named by its query ID, see otel.SetQueryID.
+const QueryIDsKey = attribute.Key("otel.query.ids")
+
+// FilterSpans applies the global query filter, as configured by
+// otel.SetAttributeFilterConfig, to spans. It drops the spans not matching
+// the full-trace condition or the structural patterns, and projects the
+// attributes, events, event attributes and links of the others. Structural
+// patterns are matched over the whole of spans, so spans should be a batch
+// rather than a single span. The spans of the traces selected by a
+// NewTraceBufferSpanProcessor are not dropped by the full-trace condition.
+// With the PropagatedSelectionFilter, the spans of the requests selected
+// upstream are kept and the ones of the requests not selected upstream are
+// dropped, see QueryPropagator. The spans kept are taken off the span budget
+// of a bounded query, the ones beyond it are dropped.
+//
+// The named queries are applied next to the global query filter: a span is
+// kept if any of them selects it, with the attributes and events any of the
+// queries selecting it keeps, and its QueryIDsKey attribute names these
+// queries. The spans a named query selects are taken off its span budget,
+// see InstallNamedQuery. The full-trace selection of a NewTraceBufferSpanProcessor and the
+// propagated selection only apply to the global query filter.
+//
+// The spans returned are already filtered and are returned unchanged by
+// another call, so that an exporter can filter the spans it is given whether
+// or not they went through a NewQueryFilterExporter.
+func FilterSpans(spans []ReadOnlySpan) []ReadOnlySpan {
+	// One filtering pass applies a single filter configuration.
+	st := global.CurrentFilterState()
+	flg, named := st.Flags, st.NamedQueries
+	if (flg == 0 && len(named) == 0) || len(spans) == 0 {
+		return spans
+	}
+
+	var structural map[spanRef]bool
+	if flg&global.StructuralTraceFilter != 0 {
+		// Call chains span several spans, match them over the whole batch.
+		if len(st.Patterns) > 0 {
+			structural = structuralMatches(spans, st.QueryID, st.Patterns)
+		}
+	}
+	namedStructural := make([]map[spanRef]bool, len(named))
+	for i, q := range named {
+		if q.Flags&global.StructuralTraceFilter != 0 && len(q.Patterns) > 0 {
+			namedStructural[i] = structuralMatches(spans, q.Name, q.Patterns)
+		}
+	}
+
+	out := make([]ReadOnlySpan, 0, len(spans))
+	for _, s := range spans {
+		if s == nil {
+			continue
+		}
+		if _, ok := s.(*filteredSpan); ok {
+			out = append(out, s)
+			continue
+		}
+		var p projection
+		if flg != 0 {
+			f := st.FilterFor(serviceName(s), s.InstrumentationScope().Name)
+			if selectedByGlobalQuery(s, f, st, structural) {
+				p.add(projectingQuery{
+					id: st.QueryID, f: f, flg: flg,
+					events: st.Events, eventAttributes: st.EventAttributeFilterFor, links: st.Links,
+				})
+			}
+		}
+		for i, q := range named {
+			f := q.FilterFor(serviceName(s), s.InstrumentationScope().Name)
+			if matchSpan(s, f, q.Flags, namedStructural[i]) && q.TakeSpan() {
+				p.add(projectingQuery{id: q.Name, f: f, flg: q.Flags, events: q.Events})
+			}
+		}
+		if len(p.queries) == 0 {
+			continue
+		}
+		fs := p.project(s)
+		if len(named) > 0 {
+			// The attributes may be the ones of s, append to a copy.
+			attrs := make([]attribute.KeyValue, len(fs.attributes), len(fs.attributes)+1)
+			copy(attrs, fs.attributes)
+			fs.attributes = append(attrs, QueryIDsKey.StringSlice(p.ids()))
+		}
+		out = append(out, fs)
+	}
+	return out
+}
+
+// selectedByGlobalQuery reports whether the global query filter of st
+// selects s, f being the TraceAttributeFilter applying to s. A span selected
+// is taken off the span budget of a bounded query.
+func selectedByGlobalQuery(s ReadOnlySpan, f attribute.TraceAttributeFilter, st *global.FilterState, structural map[spanRef]bool) bool {
+	flg := st.Flags
+	if _, ok := s.(*selectedSpan); ok {
+		// The trace of s was selected as a whole.
+		flg &^= global.AttributeNotMatchFullTraceFilter
+	}
+	if selected, ok := propagatedSelection(s, st); ok {
+		if !selected {
+			return false
+		}
+		// The request was selected upstream, with its call chain.
+		flg &^= global.AttributeNotMatchFullTraceFilter
+		structural = nil
+	}
+	return matchSpan(s, f, flg, structural) && st.TakeQuerySpan()
+}
+
+// matchSpan reports whether s passes the filters enabled in flg, f is the
+// TraceAttributeFilter applying to s. structural holds the spans of the
+// batch taking part in a structural pattern, it is only used if the
+// StructuralTraceFilter is enabled.
+func matchSpan(s ReadOnlySpan, f attribute.TraceAttributeFilter, flg global.FilterConfigFlag, structural map[spanRef]bool) bool {
+	matched := true
+	if flg&global.AttributeNotMatchFullTraceFilter != 0 {
+		f.BatchNotMatch(matchAttributes(s), func() error {
+			matched = false
+			return nil
+		})
+	}
+	if matched && structural != nil {
+		matched = structural[spanRef{tid: s.SpanContext().TraceID(), sid: s.SpanContext().SpanID()}]
+	}
+	return matched
+}
+
+// SpanIntrinsics returns the intrinsic pseudo-attributes of s, its name,
+// kind, status code, duration and instrumentation scope, see
+// attribute.SpanNameKey. The duration is only set once s ended.
+func SpanIntrinsics(s ReadOnlySpan) []attribute.KeyValue {
+	intrinsics := make([]attribute.KeyValue, 0, 5)
+	intrinsics = append(intrinsics,
+		attribute.SpanNameKey.String(s.Name()),
+		attribute.SpanKindKey.String(s.SpanKind().String()),
+		attribute.SpanStatusKey.String(strings.ToUpper(s.Status().Code.String())),
+		attribute.SpanScopeKey.String(s.InstrumentationScope().Name),
+	)
+	if end := s.EndTime(); !end.IsZero() {
+		intrinsics = append(intrinsics, attribute.SpanDurationKey.Int64(int64(end.Sub(s.StartTime()))))
+	}
+	return intrinsics
+}
+
+// matchAttributes returns the attributes of s followed by its intrinsics, the
+// attributes the conditions of the filters are evaluated against.
+func matchAttributes(s ReadOnlySpan) []attribute.KeyValue {
+	attrs := s.Attributes()
+	out := make([]attribute.KeyValue, 0, len(attrs)+5)
+	out = append(out, attrs...)
+	return append(out, SpanIntrinsics(s)...)
+}
+
+// projection gathers the queries selecting a span. An attribute, an event or
+// an attribute of an event or a link is kept if any of them keeps it.
+type projection struct {
+	queries []projectingQuery
+}
+
+// projectingQuery is a query selecting a span, with what it needs to project
+// it.
+type projectingQuery struct {
+	id  string
+	f   attribute.TraceAttributeFilter
+	flg global.FilterConfigFlag
+	// events has a key match on the names of the events kept by the
+	// EventFilter, eventAttributes returns the filter of the attributes of
+	// the events of a name for the EventAttributeFilter.
+	events          attribute.TraceAttributeFilter
+	eventAttributes func(name string) (attribute.TraceAttributeFilter, bool)
+	// links is the filter of the attributes of the links for the
+	// LinkAttributeFilter.
+	links attribute.TraceAttributeFilter
+}
+
+// add adds q, a query selecting the span.
+func (p *projection) add(q projectingQuery) {
+	p.queries = append(p.queries, q)
+}
+
+// ids returns the identities of the queries, skipping the empty ones.
+func (p *projection) ids() []string {
+	ids := make([]string, 0, len(p.queries))
+	for _, q := range p.queries {
+		if q.id != "" {
+			ids = append(ids, q.id)
+		}
+	}
+	return ids
+}
+
+// project returns s with the attributes, events and links the queries keep.
+func (p *projection) project(s ReadOnlySpan) *filteredSpan {
+	attrs := make([]attribute.TraceAttributeFilter, len(p.queries))
+	var links []attribute.TraceAttributeFilter
+	for i, q := range p.queries {
+		if q.flg&global.AttributeFilter != 0 {
+			attrs[i] = q.f
+		}
+		if q.flg&global.LinkAttributeFilter != 0 {
+			links = append(links, q.links)
+		} else {
+			links = append(links, nil)
+		}
+	}
+	return &filteredSpan{
+		ReadOnlySpan: s,
+		attributes:   filterAttributes(s.Attributes(), attrs),
+		events:       p.filterEvents(s.Events()),
+		links:        filterLinks(s.Links(), links),
+	}
+}
+
+// filterAttributes returns the attributes of attrs matching any of fs, a
+// nil filter matching all of them.
+func filterAttributes(attrs []attribute.KeyValue, fs []attribute.TraceAttributeFilter) []attribute.KeyValue {
+	if len(attrs) == 0 {
+		return attrs
+	}
+	for _, f := range fs {
+		if f == nil {
+			return attrs
+		}
+	}
+	var out []attribute.KeyValue
+	for _, kv := range attrs {
+		for _, f := range fs {
+			if f.Match(kv.Key, kv.Value) {
+				out = append(out, kv)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// filterEvents returns the events of es kept by any query: the events
+// matching its event filter if the EventFilter is enabled, with their
+// attributes matching their event attribute filter if the
+// EventAttributeFilter is enabled. Without filter for an event, none of its
+// attributes is kept.
+func (p *projection) filterEvents(es []Event) []Event {
+	if len(es) == 0 {
+		return es
+	}
+	all := true
+	for _, q := range p.queries {
+		if q.flg&(global.EventFilter|global.EventAttributeFilter) != 0 {
+			all = false
+		}
+	}
+	if all {
+		return es
+	}
+	out := make([]Event, 0, len(es))
+	for _, e := range es {
+		kept := false
+		var fs []attribute.TraceAttributeFilter
+		for _, q := range p.queries {
+			if q.flg&global.EventFilter != 0 && !q.events.Match(attribute.Key(e.Name), attribute.InvalidValue()) {
+				continue
+			}
+			kept = true
+			if q.flg&global.EventAttributeFilter == 0 {
+				fs = append(fs, nil)
+			} else if f, ok := q.eventAttributes(e.Name); ok {
+				fs = append(fs, f)
+			}
+		}
+		if !kept {
+			continue
+		}
+		e.Attributes = filterAttributes(e.Attributes, fs)
+		out = append(out, e)
+	}
+	return out
+}
+
+// filterLinks returns links with their attributes matching any of fs, a nil
+// filter matching all of them.
+func filterLinks(links []Link, fs []attribute.TraceAttributeFilter) []Link {
+	for _, f := range fs {
+		if f == nil {
+			return links
+		}
+	}
+	if len(links) == 0 {
+		return links
+	}
+	out := make([]Link, len(links))
+	for i, l := range links {
+		l.Attributes = filterAttributes(l.Attributes, fs)
+		out[i] = l
+	}
+	return out
+}
+
+// queryFilterExporter is a SpanExporter applying the global query filter to
+// the spans before exporting them.
+type queryFilterExporter struct {
+	exporter SpanExporter
+}
+
+var _ SpanExporter = (*queryFilterExporter)(nil)
+
+// NewQueryFilterExporter returns a SpanExporter applying the global query
+// filter to the spans with FilterSpans before passing them to exporter, so
+// that any exporter honors the query, e.g.
+//
+//	tp := NewTracerProvider(WithBatcher(NewQueryFilterExporter(exporter)))
+//
+// A batch left empty by the filter is not exported.
+func NewQueryFilterExporter(exporter SpanExporter) SpanExporter {
+	return &queryFilterExporter{exporter: exporter}
+}
+
+// ExportSpans filters spans and exports the ones left.
+func (e *queryFilterExporter) ExportSpans(ctx context.Context, spans []ReadOnlySpan) error {
+	spans = FilterSpans(spans)
+	if len(spans) == 0 {
+		return nil
+	}
+	return e.exporter.ExportSpans(ctx, spans)
+}
+
+// Shutdown shuts the wrapped exporter down.
+func (e *queryFilterExporter) Shutdown(ctx context.Context) error {
+	return e.exporter.Shutdown(ctx)
+}
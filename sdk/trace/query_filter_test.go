@@ -0,0 +1,386 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attrKeys returns the keys of attrs.
+func attrKeys(attrs []attribute.KeyValue) []string {
+	keys := []string{}
+	for _, kv := range attrs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestFilterSpansScoped(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.ClearScopedTraceAttributeFilters()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	app1 := global.ScopedTraceAttributeFilter("app1")
+	app1.AddKeyMatch("a")
+	app1.SetCondition(attribute.Equal("b", attribute.IntValue(1)))
+	global.ScopedTraceAttributeFilter("app2").AddKeyMatch(attribute.WildcardKey)
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+
+	span := func(id byte, service string, attrs ...attribute.KeyValue) ReadOnlySpan {
+		s := structSpan(id, 0, service)
+		s.name = string(rune('0' + id))
+		s.attributes = attrs
+		return s
+	}
+	got := make(map[string][]string)
+	for _, s := range FilterSpans([]ReadOnlySpan{
+		span(1, "app1", attribute.Int("a", 0), attribute.Int("b", 1)),
+		span(2, "app1", attribute.Int("a", 0), attribute.Int("b", 2)),
+		span(3, "app2", attribute.Int("a", 0), attribute.Int("b", 2)),
+		nil,
+	}) {
+		got[s.Name()] = attrKeys(s.Attributes())
+	}
+	assert.Equal(t, map[string][]string{"1": {"a"}, "3": {"a", "b"}}, got)
+}
+
+func TestFilterSpansEvents(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceEventFilter().Clear()
+		global.ClearTraceEventAttributeFilters()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	s := structSpan(1, 0, "app1")
+	s.events = []Event{
+		{Name: "exception", Attributes: []attribute.KeyValue{
+			attribute.String("exception.type", "E"), attribute.String("exception.stacktrace", "..."),
+		}},
+		{Name: "retry", Attributes: []attribute.KeyValue{attribute.Int("attempt", 2), attribute.String("secret", "x")}},
+		{Name: "exception"},
+	}
+	events := func() []string {
+		var out []string
+		for _, e := range FilterSpans([]ReadOnlySpan{s})[0].Events() {
+			out = append(out, e.Name+":"+strings.Join(attrKeys(e.Attributes), ","))
+		}
+		return out
+	}
+
+	global.TraceEventFilter().AddKeyMatch("exception")
+	global.TraceEventAttributeFilter("exception").AddKeyMatch("exception.type")
+	assert.Equal(t, []string{"exception:exception.type,exception.stacktrace", "retry:attempt,secret", "exception:"}, events(),
+		"events should not be filtered without the flags")
+
+	global.SetFilterConfigFlags(global.EventFilter)
+	assert.Equal(t, []string{"exception:exception.type,exception.stacktrace", "exception:"}, events())
+
+	global.SetFilterConfigFlags(global.EventFilter | global.EventAttributeFilter)
+	assert.Equal(t, []string{"exception:exception.type", "exception:"}, events())
+
+	global.TraceEventFilter().AddKeyMatch(attribute.WildcardKey)
+	assert.Equal(t, []string{"exception:exception.type", "retry:", "exception:"}, events(),
+		"events without a filter should keep no attribute")
+
+	assert.Len(t, s.events[0].Attributes, 2, "the filtered span should not be changed")
+}
+
+func TestFilterSpansLinks(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceLinkAttributeFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	s := structSpan(1, 0, "app1")
+	s.links = []Link{{Attributes: []attribute.KeyValue{attribute.String("peer", "app2"), attribute.String("secret", "x")}}}
+	global.TraceLinkAttributeFilter().AddKeyMatch("peer")
+	global.SetFilterConfigFlags(global.LinkAttributeFilter)
+
+	got := FilterSpans([]ReadOnlySpan{s})
+	require.Len(t, got, 1)
+	require.Len(t, got[0].Links(), 1)
+	assert.Equal(t, []string{"peer"}, attrKeys(got[0].Links()[0].Attributes))
+	assert.Equal(t, []string{"peer", "secret"}, attrKeys(s.links[0].Attributes))
+}
+
+func TestFilterSpansIdempotent(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	global.TraceAttributeFilter().AddKeyMatch("a")
+	global.TraceAttributeFilter().SetCondition(attribute.Equal("b", attribute.IntValue(1)))
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+
+	s := structSpan(1, 0, "app1")
+	s.attributes = []attribute.KeyValue{attribute.Int("a", 0), attribute.Int("b", 1)}
+	once := FilterSpans([]ReadOnlySpan{s})
+	require.Len(t, once, 1)
+	assert.Equal(t, []string{"a"}, attrKeys(once[0].Attributes()))
+
+	// Without b, the projected span no longer matches the condition, it
+	// must not be evaluated again.
+	twice := FilterSpans(once)
+	require.Len(t, twice, 1)
+	assert.Same(t, once[0], twice[0])
+}
+
+func TestFilterSpansIntrinsics(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	global.TraceAttributeFilter().AddKeyMatch(attribute.WildcardKey)
+	global.TraceAttributeFilter().SetCondition(attribute.And(
+		attribute.Equal(attribute.SpanKindKey, attribute.StringValue("server")),
+		attribute.Or(
+			attribute.Equal(attribute.SpanStatusKey, attribute.StringValue("ERROR")),
+			attribute.InRange(attribute.SpanDurationKey, attribute.Int64Value(int64(200*time.Millisecond)), attribute.Int64Value(math.MaxInt64)),
+		),
+	))
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+
+	span := func(id byte, kind trace.SpanKind, status codes.Code, d time.Duration) ReadOnlySpan {
+		s := structSpan(id, 0, "app1")
+		s.name = string(rune('0' + id))
+		s.spanKind = kind
+		s.status = Status{Code: status}
+		s.startTime = time.Unix(0, 0)
+		s.endTime = s.startTime.Add(d)
+		s.attributes = []attribute.KeyValue{attribute.Int("a", 1)}
+		return s
+	}
+	var got []string
+	for _, s := range FilterSpans([]ReadOnlySpan{
+		span(1, trace.SpanKindServer, codes.Error, time.Millisecond),
+		span(2, trace.SpanKindServer, codes.Ok, time.Second),
+		span(3, trace.SpanKindServer, codes.Ok, time.Millisecond),
+		span(4, trace.SpanKindClient, codes.Error, time.Second),
+	}) {
+		got = append(got, s.Name())
+		assert.Equal(t, []string{"a"}, attrKeys(s.Attributes()), "the intrinsics should not be exported")
+	}
+	assert.Equal(t, []string{"1", "2"}, got)
+}
+
+func TestFilterSpansNamedQueries(t *testing.T) {
+	flags, id := global.FilterConfigFlags(), global.QueryID()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.ClearNamedQueries()
+		global.SetFilterConfigFlags(flags)
+		global.SetQueryID(id)
+	})
+
+	named := func(name string, key attribute.Key, cond attribute.Condition) *global.NamedQuery {
+		app1 := attribute.NewMapTraceAttributeFilter()
+		app1.AddKeyMatch(key)
+		app1.SetCondition(cond)
+		q := &global.NamedQuery{
+			Name:   name,
+			Flags:  global.AttributeFilter | global.AttributeNotMatchFullTraceFilter | global.EventFilter,
+			Filter: attribute.NewMapTraceAttributeFilter(),
+			Scoped: map[string]attribute.TraceAttributeFilter{"app1": app1},
+		}
+		q.Filter.SetCondition(attribute.Or())
+		return q
+	}
+	global.SetNamedQuery(named("errors", "a", attribute.Equal("error", attribute.BoolValue(true))))
+	slow := named("slow", "b", attribute.InRange("duration", attribute.Int64Value(100), attribute.Int64Value(math.MaxInt64)))
+	slow.Events = attribute.NewMapTraceAttributeFilter()
+	slow.Events.AddKeyMatch("exception")
+	global.SetNamedQuery(slow)
+
+	span := func(id byte, service string, attrs ...attribute.KeyValue) ReadOnlySpan {
+		s := structSpan(id, 0, service)
+		s.name = string(rune('0' + id))
+		s.attributes = append([]attribute.KeyValue{attribute.Int("a", 0), attribute.Int("b", 0), attribute.Int("c", 0)}, attrs...)
+		s.events = []Event{{Name: "exception"}, {Name: "retry"}}
+		return s
+	}
+	type result struct {
+		attrs  []string
+		ids    []string
+		events int
+	}
+	filter := func(spans ...ReadOnlySpan) map[string]result {
+		got := make(map[string]result)
+		for _, s := range FilterSpans(spans) {
+			r := result{events: len(s.Events())}
+			for _, kv := range s.Attributes() {
+				if kv.Key == QueryIDsKey {
+					r.ids = kv.Value.AsStringSlice()
+					continue
+				}
+				r.attrs = append(r.attrs, string(kv.Key))
+			}
+			got[s.Name()] = r
+		}
+		return got
+	}
+
+	assert.Equal(t, map[string]result{
+		"1": {attrs: []string{"a"}, ids: []string{"errors"}, events: 0},
+		"2": {attrs: []string{"b"}, ids: []string{"slow"}, events: 1},
+		"3": {attrs: []string{"a", "b"}, ids: []string{"errors", "slow"}, events: 1},
+	}, filter(
+		span(1, "app1", attribute.Bool("error", true)),
+		span(2, "app1", attribute.Int("duration", 200)),
+		span(3, "app1", attribute.Bool("error", true), attribute.Int("duration", 200)),
+		span(4, "app1"),
+		span(5, "app2", attribute.Bool("error", true)),
+	), "each query should select and project its own spans")
+
+	global.TraceAttributeFilter().AddKeyMatch("c")
+	global.TraceAttributeFilter().SetCondition(attribute.Equal("error", attribute.BoolValue(true)))
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+	global.SetQueryID("global")
+	assert.Equal(t, map[string]result{
+		"1": {attrs: []string{"a", "c"}, ids: []string{"global", "errors"}, events: 2},
+		"5": {attrs: []string{"c"}, ids: []string{"global"}, events: 2},
+	}, filter(span(1, "app1", attribute.Bool("error", true)), span(5, "app2", attribute.Bool("error", true))),
+		"the global query filter should run next to the named queries")
+
+	global.SetFilterConfigFlags(0)
+	require.NoError(t, global.InstallNamedQuery(named("errors", "a", attribute.Equal("error", attribute.BoolValue(true))), global.QueryBounds{MaxSpans: 1}))
+	assert.Equal(t, map[string]result{
+		"1": {attrs: []string{"a"}, ids: []string{"errors"}, events: 0},
+	}, filter(span(1, "app1", attribute.Bool("error", true)), span(3, "app1", attribute.Bool("error", true))),
+		"the spans beyond the budget of a named query should be dropped")
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+
+	global.ClearNamedQueries()
+	got := FilterSpans([]ReadOnlySpan{span(1, "app1", attribute.Bool("error", true))})
+	require.Len(t, got, 1)
+	assert.Equal(t, []string{"c"}, attrKeys(got[0].Attributes()), "spans should not be tagged without named queries")
+}
+
+type filterRecorder struct {
+	batches  [][]ReadOnlySpan
+	shutdown bool
+}
+
+func (r *filterRecorder) ExportSpans(_ context.Context, spans []ReadOnlySpan) error {
+	r.batches = append(r.batches, spans)
+	return nil
+}
+
+func (r *filterRecorder) Shutdown(context.Context) error {
+	r.shutdown = true
+	return nil
+}
+
+func TestFilterSpansBudget(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+	})
+	// The previous configuration drops all the spans.
+	global.TraceAttributeFilter().SetCondition(attribute.Or())
+	global.SetFilterConfigFlags(global.AttributeNotMatchFullTraceFilter)
+	require.NoError(t, global.InstallQuery(global.QueryBounds{MaxSpans: 3}, func() error {
+		global.TraceAttributeFilter().SetCondition(attribute.Equal("error", attribute.BoolValue(true)))
+		global.SetFilterConfigFlags(global.AttributeNotMatchFullTraceFilter | global.AttributeFilter)
+		return nil
+	}))
+
+	span := func(id byte, selected bool) ReadOnlySpan {
+		s := structSpan(id, 0, "app1")
+		s.attributes = []attribute.KeyValue{attribute.Bool("error", selected)}
+		return s
+	}
+	got := FilterSpans([]ReadOnlySpan{span(1, true), span(2, false), span(3, true)})
+	require.Len(t, got, 2, "unselected spans should not be taken off the budget")
+	assert.Len(t, FilterSpans(got), 2, "filtered spans should not be taken off the budget again")
+
+	got = FilterSpans([]ReadOnlySpan{span(4, true), span(5, true)})
+	require.Len(t, got, 1, "spans beyond the budget should be dropped")
+	assert.Eventually(t, func() bool {
+		return global.FilterConfigFlags() == global.AttributeNotMatchFullTraceFilter
+	}, time.Second, time.Millisecond, "the previous configuration should be restored")
+}
+
+func TestQueryFilterExporter(t *testing.T) {
+	flags, patterns := global.FilterConfigFlags(), global.TraceStructuralPatterns()
+	t.Cleanup(func() {
+		global.SetFilterConfigFlags(flags)
+		global.SetTraceStructuralPatterns(patterns)
+	})
+
+	rec := &filterRecorder{}
+	exp := NewQueryFilterExporter(rec)
+	ctx := context.Background()
+
+	global.SetFilterConfigFlags(global.StructuralTraceFilter)
+	global.SetTraceStructuralPatterns([][]string{{"app2", "db"}})
+	require.NoError(t, exp.ExportSpans(ctx, callTree()))
+	global.SetTraceStructuralPatterns([][]string{{"app1", "app5"}})
+	require.NoError(t, exp.ExportSpans(ctx, callTree()))
+	require.Len(t, rec.batches, 1, "an empty batch should not be exported")
+	assert.Len(t, rec.batches[0], 3)
+
+	require.NoError(t, exp.Shutdown(ctx))
+	assert.True(t, rec.shutdown)
+}
+
+func BenchmarkFilterSpans(b *testing.B) {
+	flags := global.FilterConfigFlags()
+	b.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+	})
+	global.TraceAttributeFilter().AddKeyMatch("http.route")
+	global.TraceAttributeFilter().SetCondition(attribute.InRange("http.status_code", attribute.Int64Value(500), attribute.Int64Value(599)))
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+
+	spans := make([]ReadOnlySpan, 512)
+	for i := range spans {
+		s := structSpan(byte(i), 0, "app1")
+		s.attributes = []attribute.KeyValue{
+			attribute.String("http.route", "/cart"),
+			attribute.Int("http.status_code", 200+i%400),
+			attribute.String("http.method", "GET"),
+		}
+		spans[i] = s
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			FilterSpans(spans)
+		}
+	})
+}
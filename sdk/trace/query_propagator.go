@@ -0,0 +1,235 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace // import "go.opentelemetry.io/otel/sdk/trace"
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracestateHeader = "tracestate"
+	// queryStateKey is the key of the tracestate member carrying the
+	// identity of the active query and whether it selected the request, e.g.
+	// otelquery=4f2a9c0d1e3b5a7f:1.
+	queryStateKey = "otelquery"
+)
+
+// QueryPropagator is a propagator carrying the selection of the requests by
+// the active query, see otel.SetQueryID, in the W3C tracestate header. It
+// lets the downstream services keep the spans of the requests selected
+// upstream and drop the others, see otel.WithPropagatedSelectionFilter, so
+// that a query on several services returns whole call chains. It also
+// carries the positions the request reached in the structural patterns of
+// the queries, so that the call chains spanning several services match
+// although each service exports its own spans, see
+// otel.WithStructuralTraceFilter.
+//
+// A request is selected if it was selected upstream or if the span of the
+// injected context satisfies the condition of the query with the attributes
+// set so far. QueryPropagator amends the tracestate header of the
+// TraceContext propagator, it must come after it in a composite propagator,
+// e.g.
+//
+//	propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, QueryPropagator{})
+type QueryPropagator struct{}
+
+var _ propagation.TextMapPropagator = QueryPropagator{}
+
+// Inject sets the selection of the request of ctx by the active query, and
+// the positions the request reached in the call chains of the queries with
+// structural patterns, in the tracestate of carrier. Nothing is injected
+// without active query nor structural patterns.
+func (QueryPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	st := global.CurrentFilterState()
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	ts := sc.TraceState()
+	if h := carrier.Get(tracestateHeader); h != "" {
+		var err error
+		if ts, err = trace.ParseTraceState(h); err != nil {
+			return
+		}
+	}
+	injected := false
+	if id := st.QueryID; id != "" {
+		// The query ID may not be a valid tracestate value.
+		if next, err := ts.Insert(queryStateKey, encodeQueryState(id, requestSelected(ctx, st))); err == nil {
+			ts, injected = next, true
+		}
+	}
+	if next, ok := injectChainState(ctx, st, ts); ok {
+		ts, injected = next, true
+	}
+	if injected {
+		carrier.Set(tracestateHeader, ts.String())
+	}
+}
+
+// injectChainState returns ts with the positions the request of ctx reached
+// in the patterns of the queries of st that have structural patterns, see
+// chainPositions. It returns false if there are no such queries or ctx has
+// no span to locate the request at.
+func injectChainState(ctx context.Context, st *global.FilterState, ts trace.TraceState) (trace.TraceState, bool) {
+	s, ok := trace.SpanFromContext(ctx).(ReadOnlySpan)
+	if !ok {
+		return ts, false
+	}
+	service := serviceName(s)
+	value := ts.Get(chainStateKey)
+	injected := false
+	inject := func(id string, patterns [][]string) {
+		if !validChainID(id) || len(patterns) == 0 {
+			return
+		}
+		upstream := decodeChainState(value, id)
+		positions := make([]uint64, len(patterns))
+		for i, pattern := range patterns {
+			var u uint64
+			if i < len(upstream) {
+				u = upstream[i]
+			}
+			positions[i] = chainPositions(pattern, u, service)
+		}
+		value = encodeChainState(value, id, positions)
+		injected = true
+	}
+	if st.Flags&global.StructuralTraceFilter != 0 {
+		inject(st.QueryID, st.Patterns)
+	}
+	for _, q := range st.NamedQueries {
+		if q.Flags&global.StructuralTraceFilter != 0 {
+			inject(q.Name, q.Patterns)
+		}
+	}
+	if !injected {
+		return ts, false
+	}
+	next, err := ts.Insert(chainStateKey, value)
+	if err != nil {
+		// The positions exceed the size of a tracestate value.
+		return ts, false
+	}
+	return next, true
+}
+
+// Extract adds the selection of the request by the active query, and the
+// positions it reached in the call chains, found in the tracestate of
+// carrier to the tracestate of the remote span context of ctx. It is a no-op
+// if the span context was extracted with its tracestate, e.g. by the
+// TraceContext propagator.
+func (QueryPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsRemote() {
+		return ctx
+	}
+	h := carrier.Get(tracestateHeader)
+	if h == "" {
+		return ctx
+	}
+	carried, err := trace.ParseTraceState(h)
+	if err != nil {
+		return ctx
+	}
+	ts := sc.TraceState()
+	for _, key := range []string{chainStateKey, queryStateKey} {
+		value := carried.Get(key)
+		if value == "" || ts.Get(key) == value {
+			continue
+		}
+		if next, err := ts.Insert(key, value); err == nil {
+			ts = next
+		}
+	}
+	if ts.String() == sc.TraceState().String() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc.WithTraceState(ts))
+}
+
+// Fields returns the keys whose values are set with Inject.
+func (QueryPropagator) Fields() []string {
+	return []string{tracestateHeader}
+}
+
+// encodeQueryState returns the tracestate value of the selection of a
+// request by the query id.
+func encodeQueryState(id string, selected bool) string {
+	if selected {
+		return id + ":1"
+	}
+	return id + ":0"
+}
+
+// decodeQueryState returns whether value, the tracestate value of a query
+// selection, carries the selection of a request by the query id.
+func decodeQueryState(value, id string) (selected, ok bool) {
+	i := strings.LastIndexByte(value, ':')
+	if i < 0 || value[:i] != id {
+		return false, false
+	}
+	switch value[i+1:] {
+	case "1":
+		return true, true
+	case "0":
+		return false, true
+	}
+	return false, false
+}
+
+// requestSelected reports whether the request of ctx is selected by the
+// active query of st: it was selected upstream, or the span of ctx satisfies
+// the condition of its TraceAttributeFilter in st, if the full-trace
+// condition is enabled.
+func requestSelected(ctx context.Context, st *global.FilterState) bool {
+	sc := trace.SpanContextFromContext(ctx)
+	if selected, ok := decodeQueryState(sc.TraceState().Get(queryStateKey), st.QueryID); ok && selected {
+		return true
+	}
+	s, ok := trace.SpanFromContext(ctx).(ReadOnlySpan)
+	if !ok {
+		return false
+	}
+	flg := st.Flags & global.AttributeNotMatchFullTraceFilter
+	return matchSpan(s, st.FilterFor(serviceName(s), s.InstrumentationScope().Name), flg, nil)
+}
+
+// propagatedSelection returns the selection of the request of s by the
+// active query of st in an upstream service, if the
+// PropagatedSelectionFilter is enabled in st and the selection was
+// propagated.
+func propagatedSelection(s ReadOnlySpan, st *global.FilterState) (selected, ok bool) {
+	return tracestateSelection(s.SpanContext().TraceState(), st)
+}
+
+// tracestateSelection returns the selection of a request by the active query
+// of st carried by ts, if the PropagatedSelectionFilter is enabled in st.
+func tracestateSelection(ts trace.TraceState, st *global.FilterState) (selected, ok bool) {
+	if st.Flags&global.PropagatedSelectionFilter == 0 {
+		return false, false
+	}
+	id := st.QueryID
+	if id == "" {
+		return false, false
+	}
+	return decodeQueryState(ts.Get(queryStateKey), id)
+}
@@ -0,0 +1,118 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// selectQuery installs the query id selecting the spans with an error
+// attribute.
+func selectQuery(t *testing.T, id string, flags global.FilterConfigFlag) {
+	old := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.SetFilterConfigFlags(old)
+		global.SetQueryID("")
+	})
+	global.TraceAttributeFilter().SetCondition(attribute.Equal("error", attribute.BoolValue(true)))
+	global.SetFilterConfigFlags(flags)
+	global.SetQueryID(id)
+}
+
+func TestQueryPropagatorInject(t *testing.T) {
+	prop := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, QueryPropagator{})
+	tracer := NewTracerProvider().Tracer("test")
+	inject := func(ctx context.Context) string {
+		carrier := propagation.MapCarrier{}
+		prop.Inject(ctx, carrier)
+		return carrier.Get(tracestateHeader)
+	}
+
+	ctx, span := tracer.Start(context.Background(), "client", trace.WithAttributes(attribute.Bool("error", true)))
+	defer span.End()
+	assert.Empty(t, inject(ctx), "nothing should be injected without active query")
+
+	selectQuery(t, "q1", global.AttributeNotMatchFullTraceFilter)
+	assert.Equal(t, "otelquery=q1:1", inject(ctx))
+
+	ts, err := trace.ParseTraceState("vendor=x")
+	require.NoError(t, err)
+	ctx, other := tracer.Start(trace.ContextWithSpanContext(context.Background(), span.SpanContext().WithTraceState(ts)), "other")
+	defer other.End()
+	assert.Equal(t, "otelquery=q1:0,vendor=x", inject(ctx), "other tracestate members should be kept")
+
+	upstream, err := ts.Insert(queryStateKey, "q1:1")
+	require.NoError(t, err)
+	ctx, downstream := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), span.SpanContext().WithTraceState(upstream)), "downstream")
+	defer downstream.End()
+	assert.Equal(t, "otelquery=q1:1,vendor=x", inject(ctx), "a request selected upstream should stay selected")
+
+	global.SetQueryID("q2")
+	assert.Equal(t, "otelquery=q2:0,vendor=x", inject(ctx), "selections of other queries should be ignored")
+
+	global.SetQueryID("not,valid")
+	assert.Equal(t, "otelquery=q1:1,vendor=x", inject(ctx))
+}
+
+func TestQueryPropagatorExtract(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01},
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), sc)
+	carrier := propagation.MapCarrier{tracestateHeader: "otelquery=q1:1,vendor=x"}
+
+	got := trace.SpanContextFromContext(QueryPropagator{}.Extract(ctx, carrier))
+	assert.Equal(t, "otelquery=q1:1", got.TraceState().String(), "only the query selection should be extracted")
+	assert.Equal(t, sc.SpanID(), got.SpanID())
+
+	assert.Equal(t, context.Background(), QueryPropagator{}.Extract(context.Background(), carrier), "no span context should be made up")
+	assert.Equal(t, []string{tracestateHeader}, QueryPropagator{}.Fields())
+}
+
+func TestFilterSpansPropagatedSelection(t *testing.T) {
+	selectQuery(t, "q1", global.AttributeNotMatchFullTraceFilter|global.PropagatedSelectionFilter)
+
+	span := func(id byte, state string) ReadOnlySpan {
+		s := structSpan(id, 0, "app2")
+		ts, err := trace.ParseTraceState(state)
+		require.NoError(t, err)
+		s.spanContext = s.spanContext.WithTraceState(ts)
+		return s
+	}
+	got := FilterSpans([]ReadOnlySpan{
+		span(1, "otelquery=q1:1"),
+		span(2, "otelquery=q1:0"),
+		span(3, "otelquery=q0:1"),
+		span(4, ""),
+	})
+	require.Len(t, got, 1, "only the request selected upstream should be kept")
+	assert.Equal(t, trace.SpanID{1}, got[0].SpanContext().SpanID())
+
+	global.SetFilterConfigFlags(global.AttributeNotMatchFullTraceFilter)
+	assert.Empty(t, FilterSpans([]ReadOnlySpan{span(1, "otelquery=q1:1")}), "the selection should be ignored without the filter")
+}
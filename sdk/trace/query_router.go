@@ -0,0 +1,209 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace // import "go.opentelemetry.io/otel/sdk/trace"
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+)
+
+// QueryRoute routes the spans selected by a query, or by a filter, to an
+// exporter.
+type QueryRoute struct {
+	// Query is the name of the named query selecting the spans, see
+	// ApplyNamed of go.opentelemetry.io/otel/sdk/queryparser. The spans are
+	// projected by the query. A route on a query not installed selects no
+	// span.
+	Query string
+	// Filter selects the spans satisfying its condition, or without
+	// condition, matching all its matches, as the full-trace condition does.
+	// It is used if Query is empty, the spans are exported unchanged. It
+	// must not be changed while the exporter is in use.
+	Filter attribute.TraceAttributeFilter
+	// Exporter exports the spans selected.
+	Exporter SpanExporter
+}
+
+// queryRoutingExporter is a SpanExporter routing the spans to exporters by
+// the queries selecting them.
+type queryRoutingExporter struct {
+	routes   []QueryRoute
+	fallback SpanExporter
+	// exporters are the distinct exporters of the routes and the fallback
+	// exporter, shut down by Shutdown.
+	exporters []SpanExporter
+}
+
+var _ SpanExporter = (*queryRoutingExporter)(nil)
+
+// NewQueryRoutingExporter returns a SpanExporter passing the spans to the
+// exporter of every route selecting them, and the spans no route selects to
+// fallback, e.g. to send the spans of a support case to a file next to the
+// usual telemetry:
+//
+//	exp := NewQueryRoutingExporter(otlpExporter, QueryRoute{Query: "case-42", Exporter: fileExporter})
+//
+// The routes are evaluated on the spans as recorded, the global query filter
+// does not apply to them: wrap fallback with NewQueryFilterExporter to apply
+// it to the other spans. A nil fallback drops the spans no route selects.
+// Structural patterns of a query are matched over the whole batch, as by
+// FilterSpans.
+//
+// The exporters are compared directly to shut each of them down once: they
+// must be comparable, e.g. pointers.
+func NewQueryRoutingExporter(fallback SpanExporter, routes ...QueryRoute) SpanExporter {
+	e := &queryRoutingExporter{
+		routes:   append([]QueryRoute(nil), routes...),
+		fallback: fallback,
+	}
+	for _, r := range e.routes {
+		e.addExporter(r.Exporter)
+	}
+	e.addExporter(fallback)
+	return e
+}
+
+// addExporter adds exp to the exporters of e, unless it is nil or there
+// already.
+func (e *queryRoutingExporter) addExporter(exp SpanExporter) {
+	if exp == nil {
+		return
+	}
+	for _, known := range e.exporters {
+		if known == exp {
+			return
+		}
+	}
+	e.exporters = append(e.exporters, exp)
+}
+
+// queryRouteMatcher selects the spans of a batch for a QueryRoute.
+type queryRouteMatcher struct {
+	query      *global.NamedQuery
+	structural map[spanRef]bool
+	filter     attribute.TraceAttributeFilter
+}
+
+// matcher returns the matcher of r over raw, the spans of a batch as
+// recorded. It returns false if r selects no span.
+func (r QueryRoute) matcher(raw []ReadOnlySpan) (queryRouteMatcher, bool) {
+	if r.Query == "" {
+		return queryRouteMatcher{filter: r.Filter}, r.Filter != nil
+	}
+	q, ok := global.LookupNamedQuery(r.Query)
+	if !ok {
+		return queryRouteMatcher{}, false
+	}
+	m := queryRouteMatcher{query: q}
+	if q.Flags&global.StructuralTraceFilter != 0 && len(q.Patterns) > 0 {
+		m.structural = structuralMatches(raw, q.Name, q.Patterns)
+	}
+	return m, true
+}
+
+// route returns s as exported by the route if it selects raw, s as recorded.
+func (m queryRouteMatcher) route(s, raw ReadOnlySpan) (ReadOnlySpan, bool) {
+	if m.query == nil {
+		return s, matchSpan(raw, m.filter, global.AttributeNotMatchFullTraceFilter, nil)
+	}
+	q := m.query
+	f := q.FilterFor(serviceName(raw), raw.InstrumentationScope().Name)
+	if !matchSpan(raw, f, q.Flags, m.structural) {
+		return nil, false
+	}
+	var p projection
+	p.add(projectingQuery{id: q.Name, f: f, flg: q.Flags, events: q.Events})
+	return p.project(raw), true
+}
+
+// ExportSpans passes the spans to the exporters of the routes selecting them,
+// and the other ones to the fallback exporter. All the exporters are called,
+// their errors are returned together.
+func (e *queryRoutingExporter) ExportSpans(ctx context.Context, spans []ReadOnlySpan) error {
+	raw := make([]ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		raw[i] = s
+		if fs, ok := s.(*filteredSpan); ok {
+			// The routes apply to the span as recorded.
+			raw[i] = fs.ReadOnlySpan
+		}
+	}
+
+	matchers := make([]queryRouteMatcher, 0, len(e.routes))
+	exporters := make([]SpanExporter, 0, len(e.routes))
+	for _, r := range e.routes {
+		if m, ok := r.matcher(raw); ok {
+			matchers = append(matchers, m)
+			exporters = append(exporters, r.Exporter)
+		}
+	}
+	batches := make([][]ReadOnlySpan, len(matchers))
+	var unrouted []ReadOnlySpan
+	for i, s := range spans {
+		if s == nil {
+			continue
+		}
+		routed := false
+		for j, m := range matchers {
+			if out, ok := m.route(s, raw[i]); ok {
+				batches[j] = append(batches[j], out)
+				routed = true
+			}
+		}
+		if !routed {
+			unrouted = append(unrouted, s)
+		}
+	}
+
+	var retErr error
+	export := func(exp SpanExporter, batch []ReadOnlySpan) {
+		if exp == nil || len(batch) == 0 {
+			return
+		}
+		if err := exp.ExportSpans(ctx, batch); err != nil {
+			if retErr == nil {
+				retErr = err
+			} else {
+				// Poor man's list of errors
+				retErr = fmt.Errorf("%v; %v", retErr, err)
+			}
+		}
+	}
+	for i, batch := range batches {
+		export(exporters[i], batch)
+	}
+	export(e.fallback, unrouted)
+	return retErr
+}
+
+// Shutdown shuts the exporters of the routes and the fallback exporter down,
+// each once.
+func (e *queryRoutingExporter) Shutdown(ctx context.Context) error {
+	var retErr error
+	for _, exp := range e.exporters {
+		if err := exp.Shutdown(ctx); err != nil {
+			if retErr == nil {
+				retErr = err
+			} else {
+				// Poor man's list of errors
+				retErr = fmt.Errorf("%v; %v", retErr, err)
+			}
+		}
+	}
+	return retErr
+}
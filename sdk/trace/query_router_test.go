@@ -0,0 +1,127 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+)
+
+type failingExporter struct {
+	filterRecorder
+	err error
+}
+
+func (e *failingExporter) ExportSpans(ctx context.Context, spans []ReadOnlySpan) error {
+	_ = e.filterRecorder.ExportSpans(ctx, spans)
+	return e.err
+}
+
+func TestQueryRoutingExporter(t *testing.T) {
+	t.Cleanup(global.ClearNamedQueries)
+
+	app1 := attribute.NewMapTraceAttributeFilter()
+	app1.AddKeyMatch("user.id")
+	app1.SetCondition(attribute.Equal("user.id", attribute.IntValue(42)))
+	q := &global.NamedQuery{
+		Name:   "case-42",
+		Flags:  global.AttributeFilter | global.AttributeNotMatchFullTraceFilter,
+		Filter: attribute.NewMapTraceAttributeFilter(),
+		Scoped: map[string]attribute.TraceAttributeFilter{"app1": app1},
+	}
+	q.Filter.SetCondition(attribute.Or())
+	global.SetNamedQuery(q)
+
+	errorFilter := attribute.NewMapTraceAttributeFilter()
+	errorFilter.SetCondition(attribute.Equal("error", attribute.BoolValue(true)))
+
+	file, errs, fallback := &filterRecorder{}, &failingExporter{err: errors.New("unavailable")}, &filterRecorder{}
+	exp := NewQueryRoutingExporter(fallback,
+		QueryRoute{Query: "case-42", Exporter: file},
+		QueryRoute{Filter: errorFilter, Exporter: errs},
+		QueryRoute{Query: "missing", Exporter: file},
+		QueryRoute{Query: "case-42", Exporter: fallback},
+	)
+
+	span := func(id byte, attrs ...attribute.KeyValue) ReadOnlySpan {
+		s := structSpan(id, 0, "app1")
+		s.name = string(rune('0' + id))
+		s.attributes = append([]attribute.KeyValue{attribute.String("http.method", "GET")}, attrs...)
+		return s
+	}
+	names := func(spans []ReadOnlySpan) []string {
+		var out []string
+		for _, s := range spans {
+			out = append(out, s.Name())
+		}
+		return out
+	}
+	err := exp.ExportSpans(context.Background(), []ReadOnlySpan{
+		span(1, attribute.Int("user.id", 42)),
+		span(2, attribute.Int("user.id", 7), attribute.Bool("error", true)),
+		span(3, attribute.Int("user.id", 42), attribute.Bool("error", true)),
+		span(4),
+		nil,
+	})
+	assert.EqualError(t, err, "unavailable", "the errors of the exporters should be returned")
+
+	require.Len(t, file.batches, 1)
+	assert.Equal(t, []string{"1", "3"}, names(file.batches[0]))
+	assert.Equal(t, []string{"user.id"}, attrKeys(file.batches[0][0].Attributes()), "the spans should be projected by the query")
+	require.Len(t, errs.batches, 1)
+	assert.Equal(t, []string{"2", "3"}, names(errs.batches[0]))
+	assert.Len(t, errs.batches[0][0].Attributes(), 3, "the spans of a filter should be exported unchanged")
+	require.Len(t, fallback.batches, 2)
+	assert.Equal(t, []string{"1", "3"}, names(fallback.batches[0]))
+	assert.Equal(t, []string{"4"}, names(fallback.batches[1]), "the spans no route selects should go to the fallback")
+
+	require.NoError(t, exp.Shutdown(context.Background()))
+	assert.True(t, file.shutdown)
+	assert.True(t, errs.shutdown)
+	assert.True(t, fallback.shutdown)
+}
+
+// countingExporter counts its shutdowns.
+type countingExporter struct {
+	shutdowns int
+}
+
+func (e *countingExporter) ExportSpans(context.Context, []ReadOnlySpan) error { return nil }
+
+func (e *countingExporter) Shutdown(context.Context) error {
+	e.shutdowns++
+	return nil
+}
+
+func TestQueryRoutingExporterShutdownOnce(t *testing.T) {
+	shared, fallback := &countingExporter{}, &countingExporter{}
+	routing := NewQueryRoutingExporter(fallback,
+		QueryRoute{Query: "case-42", Exporter: shared},
+		QueryRoute{Query: "case-43", Exporter: shared},
+		QueryRoute{Query: "case-44", Exporter: fallback},
+		QueryRoute{Query: "case-45"},
+	)
+
+	require.NoError(t, routing.Shutdown(context.Background()))
+	assert.Equal(t, 1, shared.shutdowns, "an exporter of several routes should be shut down once")
+	assert.Equal(t, 1, fallback.shutdowns, "the fallback exporter of a route should be shut down once")
+}
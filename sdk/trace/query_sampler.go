@@ -0,0 +1,120 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace // import "go.opentelemetry.io/otel/sdk/trace"
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type queryFilterSampler struct {
+	noMatch SamplingDecision
+}
+
+func (qs queryFilterSampler) ShouldSample(p SamplingParameters) SamplingResult {
+	ts := trace.SpanContextFromContext(p.ParentContext).TraceState()
+	decision := RecordAndSample
+	if !mayMatchQuery(p, ts) {
+		decision = qs.noMatch
+	}
+	return SamplingResult{
+		Decision:   decision,
+		Tracestate: ts,
+	}
+}
+
+func (qs queryFilterSampler) Description() string {
+	if qs.noMatch == RecordOnly {
+		return "QueryFilterSampler{RecordOnly}"
+	}
+	return "QueryFilterSampler{Drop}"
+}
+
+// QueryFilterSampler returns a Sampler that samples the spans the global
+// query filter may select, and decides noMatch, Drop or RecordOnly, for the
+// spans it can never select. Any other noMatch is treated as Drop. RecordOnly
+// keeps recording the spans, e.g. for the span processors, without
+// exporting them.
+//
+// The query is evaluated with the attributes given at span start, which are
+// assumed not to change, and with the name and the kind of the span. The attributes missing at span start may be set
+// later, so a condition depending on them may still be true: only the spans
+// failing the full-trace condition whatever the attributes set later are not
+// sampled. As the sampler does not know the service and the instrumentation
+// scope of the span, it only rejects spans rejected by all the global and
+// scoped filters, and by all the filters of the named queries. The other
+// query filters are applied at export time, see FilterSpans. While a
+// TraceBufferSpanProcessor is in use, the full-trace condition of the global
+// query filter applies to whole traces and the sampler does not reject spans
+// by it, only by the full-trace conditions of the named queries. With the
+// PropagatedSelectionFilter, the spans of the requests selected by the
+// active query upstream are sampled and the spans of the requests it did not
+// select are rejected by the global query filter, see QueryPropagator.
+func QueryFilterSampler(noMatch SamplingDecision) Sampler {
+	if noMatch != RecordOnly {
+		noMatch = Drop
+	}
+	return queryFilterSampler{noMatch: noMatch}
+}
+
+// mayMatchQuery reports whether the span starting with p, with the
+// tracestate ts, may pass the full-trace condition of the global query
+// filter, or of a named query. With the PropagatedSelectionFilter, the
+// selection of the request upstream carried by ts replaces the full-trace
+// condition of the global query filter, as in FilterSpans.
+func mayMatchQuery(p SamplingParameters, ts trace.TraceState) bool {
+	st := global.CurrentFilterState()
+	flg, named := st.Flags, st.NamedQueries
+	if flg == 0 && len(named) == 0 {
+		return true
+	}
+	attrs := make([]attribute.KeyValue, 0, len(p.Attributes)+2)
+	attrs = append(attrs, p.Attributes...)
+	// The other intrinsics are not known yet.
+	attrs = append(attrs, attribute.SpanNameKey.String(p.Name), attribute.SpanKindKey.String(p.Kind.String()))
+	if flg != 0 && mayMatchGlobal(attrs, ts, st) {
+		return true
+	}
+	for _, q := range named {
+		if mayMatch(attrs, q.Flags, q.Filters()) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatchGlobal reports whether a span starting with attrs and the
+// tracestate ts may be selected by the global query filter of st.
+func mayMatchGlobal(attrs []attribute.KeyValue, ts trace.TraceState, st *global.FilterState) bool {
+	if selected, ok := tracestateSelection(ts, st); ok {
+		return selected
+	}
+	return activeTraceBuffers.Load() > 0 || mayMatch(attrs, st.Flags, st.Filters())
+}
+
+// mayMatch reports whether a span starting with attrs may pass the full-trace
+// condition of one of filters, if enabled in flg.
+func mayMatch(attrs []attribute.KeyValue, flg global.FilterConfigFlag, filters []attribute.TraceAttributeFilter) bool {
+	if flg&global.AttributeNotMatchFullTraceFilter == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f.BatchMayMatch(attrs) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestQueryFilterSampler(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.ClearScopedTraceAttributeFilters()
+		global.ClearNamedQueries()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	global.TraceAttributeFilter().SetCondition(attribute.And(
+		attribute.Equal("http.method", attribute.StringValue("GET")),
+		attribute.InRange("http.status_code", attribute.Int64Value(500), attribute.Int64Value(599)),
+	))
+	sampler := QueryFilterSampler(Drop)
+	decision := func(attrs ...attribute.KeyValue) SamplingDecision {
+		return sampler.ShouldSample(SamplingParameters{ParentContext: context.Background(), Attributes: attrs}).Decision
+	}
+
+	assert.Equal(t, RecordAndSample, decision(attribute.String("http.method", "POST")), "a disabled filter should sample every span")
+
+	global.SetFilterConfigFlags(global.AttributeNotMatchFullTraceFilter)
+	assert.Equal(t, Drop, decision(attribute.String("http.method", "POST")))
+	assert.Equal(t, RecordAndSample, decision(attribute.String("http.method", "GET")), "the status code may be set later")
+	assert.Equal(t, Drop, decision(attribute.String("http.method", "GET"), attribute.Int("http.status_code", 200)))
+	assert.Equal(t, RecordAndSample, decision())
+
+	global.ScopedTraceAttributeFilter("app1").SetCondition(attribute.Equal("http.method", attribute.StringValue("POST")))
+	assert.Equal(t, RecordAndSample, decision(attribute.String("http.method", "POST")), "a scoped filter may select the span")
+	assert.Equal(t, Drop, decision(attribute.String("http.method", "PUT")))
+
+	q := &global.NamedQuery{Name: "puts", Flags: global.AttributeNotMatchFullTraceFilter, Filter: attribute.NewMapTraceAttributeFilter()}
+	q.Filter.SetCondition(attribute.Equal("http.method", attribute.StringValue("PUT")))
+	global.SetNamedQuery(q)
+	assert.Equal(t, RecordAndSample, decision(attribute.String("http.method", "PUT")), "a named query may select the span")
+	global.SetFilterConfigFlags(0)
+	assert.Equal(t, Drop, decision(attribute.String("http.method", "GET")), "only the named queries should apply without global filter")
+	global.SetFilterConfigFlags(global.AttributeNotMatchFullTraceFilter)
+
+	global.ClearScopedTraceAttributeFilters()
+	global.ClearNamedQueries()
+	global.TraceAttributeFilter().SetCondition(attribute.Equal(attribute.SpanKindKey, attribute.StringValue("server")))
+	params := SamplingParameters{ParentContext: context.Background(), Name: "GET /", Kind: trace.SpanKindClient}
+	assert.Equal(t, Drop, sampler.ShouldSample(params).Decision, "the kind of the span should be evaluated")
+	params.Kind = trace.SpanKindServer
+	assert.Equal(t, RecordAndSample, sampler.ShouldSample(params).Decision)
+
+	sampler = QueryFilterSampler(RecordOnly)
+	assert.Equal(t, RecordOnly, decision(attribute.String("http.method", "DELETE")))
+	assert.Equal(t, "QueryFilterSampler{RecordOnly}", sampler.Description())
+	assert.Equal(t, "QueryFilterSampler{Drop}", QueryFilterSampler(RecordAndSample).Description())
+}
+
+func TestQueryFilterSamplerTracer(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+	})
+
+	global.TraceAttributeFilter().SetCondition(attribute.Equal("http.method", attribute.StringValue("GET")))
+	global.SetFilterConfigFlags(global.AttributeNotMatchFullTraceFilter)
+	tracer := NewTracerProvider(WithSampler(QueryFilterSampler(Drop))).Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "get", trace.WithAttributes(attribute.String("http.method", "GET")))
+	assert.True(t, span.IsRecording())
+	assert.True(t, span.SpanContext().IsSampled())
+
+	_, span = tracer.Start(context.Background(), "post", trace.WithAttributes(attribute.String("http.method", "POST")))
+	assert.False(t, span.IsRecording())
+	assert.False(t, span.SpanContext().IsSampled())
+}
+
+func TestQueryFilterSamplerPropagatedSelection(t *testing.T) {
+	selectQuery(t, "q1", global.AttributeNotMatchFullTraceFilter|global.PropagatedSelectionFilter)
+	sampler := QueryFilterSampler(Drop)
+	decision := func(state string, attrs ...attribute.KeyValue) SamplingDecision {
+		ts, err := trace.ParseTraceState(state)
+		require.NoError(t, err)
+		parent := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{1},
+			TraceState: ts,
+			Remote:     true,
+		})
+		ctx := trace.ContextWithRemoteSpanContext(context.Background(), parent)
+		return sampler.ShouldSample(SamplingParameters{ParentContext: ctx, Attributes: attrs}).Decision
+	}
+
+	noError := attribute.Bool("error", false)
+	assert.Equal(t, Drop, decision("", noError))
+	assert.Equal(t, RecordAndSample, decision("otelquery=q1:1", noError), "a request selected upstream should be sampled")
+	assert.Equal(t, Drop, decision("otelquery=q1:0", attribute.Bool("error", true)), "a request not selected upstream should be dropped")
+	assert.Equal(t, RecordAndSample, decision("otelquery=q2:0", attribute.Bool("error", true)), "the selection by another query should be ignored")
+}
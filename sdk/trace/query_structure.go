@@ -0,0 +1,310 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace // import "go.opentelemetry.io/otel/sdk/trace"
+
+import (
+	"strconv"
+	"strings"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// chainStateKey is the key of the tracestate member carrying the positions a
+// request reached in the call chains of the queries, by query, e.g.
+// otelchain=4f2a9c0d1e3b5a7f:1.0;errors:2 for the two patterns of the query
+// 4f2a9c0d1e3b5a7f and the pattern of the named query errors, see
+// chainPositions. It lets the call chains spanning several services match
+// although each service exports its own spans, see QueryPropagator.
+const chainStateKey = "otelchain"
+
+// spanRef identifies a span across traces.
+type spanRef struct {
+	tid trace.TraceID
+	sid trace.SpanID
+}
+
+// callNode is a span of the filtered batch linked to its parent.
+type callNode struct {
+	service string
+	parent  int // index of the parent node, -1 if the parent is not in the batch
+	// upstream are the positions reached upstream in each pattern by the
+	// request of a span whose parent is remote, see chainPositions.
+	upstream []uint64
+	// outgoing is set on the client and producer spans, which call the next
+	// hop of the chain in another service.
+	outgoing bool
+}
+
+// structuralMatches returns the spans of sdl that take part in a call chain
+// matching one of the patterns of the query id. A pattern lists service
+// names in call order, e.g. {"app1", "app2", "app3"} for app1 -> app2 ->
+// app3.
+//
+// Consecutive spans of the same service along a parent/child path form a
+// single hop of the chain. A span takes part in a chain if it lies on a path
+// whose hops spell the whole pattern, e.g. the server and client spans of app2
+// in app1 -> app2 -> app3, but not an app2 database span that does not lead to
+// app3. The parent/child relationships between spans of the batch are known,
+// and the chain crosses services through the tracestate, see chainStateKey:
+// a span whose parent is remote resumes the chain at the positions the
+// request reached upstream, and a client or producer span may lead to the
+// rest of the chain downstream. A span whose parent is neither in the batch
+// nor remote starts a new path.
+func structuralMatches(sdl []ReadOnlySpan, id string, patterns [][]string) map[spanRef]bool {
+	nodes := make([]callNode, 0, len(sdl))
+	refs := make([]spanRef, 0, len(sdl))
+	index := make(map[spanRef]int, len(sdl))
+	for _, sd := range sdl {
+		if sd == nil {
+			continue
+		}
+		ref := spanRef{tid: sd.SpanContext().TraceID(), sid: sd.SpanContext().SpanID()}
+		index[ref] = len(nodes)
+		refs = append(refs, ref)
+		kind := sd.SpanKind()
+		nodes = append(nodes, callNode{
+			service:  serviceName(sd),
+			parent:   -1,
+			outgoing: kind == trace.SpanKindClient || kind == trace.SpanKindProducer,
+		})
+	}
+	i := 0
+	for _, sd := range sdl {
+		if sd == nil {
+			continue
+		}
+		if psid := sd.Parent().SpanID(); psid.IsValid() {
+			if p, ok := index[spanRef{tid: refs[i].tid, sid: psid}]; ok {
+				nodes[i].parent = p
+			} else if sd.Parent().IsRemote() {
+				// A span inherits the tracestate of its parent.
+				nodes[i].upstream = decodeChainState(sd.SpanContext().TraceState().Get(chainStateKey), id)
+			}
+		}
+		i++
+	}
+
+	order := topologicalOrder(nodes)
+	matched := make(map[spanRef]bool)
+	for j, pattern := range patterns {
+		for _, n := range matchPattern(nodes, order, pattern, j) {
+			matched[refs[n]] = true
+		}
+	}
+	return matched
+}
+
+// topologicalOrder returns the indices of nodes ordered so that every parent
+// precedes its children.
+func topologicalOrder(nodes []callNode) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(nodes))
+	order := make([]int, 0, len(nodes))
+	var stack []int
+	for i := range nodes {
+		// Walk up to the first visited ancestor, then emit on the way down.
+		for n := i; n >= 0 && state[n] == unvisited; n = nodes[n].parent {
+			state[n] = visiting
+			stack = append(stack, n)
+		}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if p := nodes[n].parent; p >= 0 && state[p] == visiting {
+				// A parent cycle (malformed input), break it here.
+				nodes[n].parent = -1
+			}
+			state[n] = visited
+			order = append(order, n)
+		}
+	}
+	return order
+}
+
+// matchPattern returns the indices of nodes taking part in pattern, the
+// pattern j of the query.
+//
+// up[n][i] holds if the hops ending at the hop of n spell pattern[:i+1], and
+// down[n][i] holds if some path starting at the hop of n and going through n
+// spells pattern[i:], or may spell it downstream. Node n takes part in the
+// pattern if both hold for the same i.
+func matchPattern(nodes []callNode, order []int, pattern []string, j int) []int {
+	k := len(pattern)
+	if k == 0 {
+		return nil
+	}
+	up := make([][]bool, len(nodes))
+	down := make([][]bool, len(nodes))
+	for n := range nodes {
+		up[n] = make([]bool, k)
+		down[n] = make([]bool, k)
+	}
+
+	for _, n := range order {
+		svc, p := nodes[n].service, nodes[n].parent
+		if p >= 0 && nodes[p].service == svc {
+			copy(up[n], up[p])
+			continue
+		}
+		if p < 0 {
+			// The request resumes the chain where it was upstream.
+			var upstream uint64
+			if j < len(nodes[n].upstream) {
+				upstream = nodes[n].upstream[j]
+			}
+			positions := chainPositions(pattern, upstream, svc)
+			for i := 0; i < k && i < maxChainLength; i++ {
+				up[n][i] = positions&(1<<i) != 0
+			}
+			continue
+		}
+		for i := 0; i < k; i++ {
+			if pattern[i] != svc {
+				continue
+			}
+			up[n][i] = i == 0 || up[p][i-1]
+		}
+	}
+
+	for n := range nodes {
+		down[n][k-1] = pattern[k-1] == nodes[n].service
+		if nodes[n].outgoing {
+			// The rest of the chain may follow in the callee.
+			for i := 0; i < k-1; i++ {
+				down[n][i] = pattern[i] == nodes[n].service
+			}
+		}
+	}
+	for j := len(order) - 1; j >= 0; j-- {
+		n := order[j]
+		p := nodes[n].parent
+		if p < 0 {
+			continue
+		}
+		if nodes[p].service == nodes[n].service {
+			for i := 0; i < k; i++ {
+				down[p][i] = down[p][i] || down[n][i]
+			}
+			continue
+		}
+		for i := 0; i < k-1; i++ {
+			if pattern[i] == nodes[p].service && down[n][i+1] {
+				down[p][i] = true
+			}
+		}
+	}
+
+	var out []int
+	for n := range nodes {
+		for i := 0; i < k; i++ {
+			if up[n][i] && down[n][i] {
+				out = append(out, n)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// serviceName returns the service.name resource attribute of sd, or an empty
+// string if it is not set.
+func serviceName(sd ReadOnlySpan) string {
+	if res := sd.Resource(); res != nil {
+		if v, ok := res.Set().Value(semconv.ServiceNameKey); ok {
+			return v.AsString()
+		}
+	}
+	return ""
+}
+
+// maxChainLength is the length of the longest pattern prefix whose position
+// is propagated in the tracestate.
+const maxChainLength = 64
+
+// chainPositions returns the positions a request reaching service reaches in
+// pattern, upstream being the positions it reached upstream: bit i is set if
+// the hops of the request, ending at service, spell pattern[:i+1].
+// Consecutive hops of the same service form a single hop.
+func chainPositions(pattern []string, upstream uint64, service string) uint64 {
+	var positions uint64
+	for i := 0; i < len(pattern) && i < maxChainLength; i++ {
+		if pattern[i] != service {
+			continue
+		}
+		if i == 0 || upstream&(1<<(i-1)) != 0 || upstream&(1<<i) != 0 {
+			positions |= 1 << i
+		}
+	}
+	return positions
+}
+
+// encodeChainState returns value, the value of the chainStateKey tracestate
+// member, with the positions of the patterns of the query id, replacing the
+// ones already carried for id.
+func encodeChainState(value, id string, positions []uint64) string {
+	masks := make([]string, len(positions))
+	for i, p := range positions {
+		masks[i] = strconv.FormatUint(p, 16)
+	}
+	entries := []string{id + ":" + strings.Join(masks, ".")}
+	for _, entry := range strings.Split(value, ";") {
+		if entry != "" && !strings.HasPrefix(entry, id+":") {
+			entries = append(entries, entry)
+		}
+	}
+	return strings.Join(entries, ";")
+}
+
+// decodeChainState returns the positions of the patterns of the query id
+// carried by value, the value of the chainStateKey tracestate member, nil if
+// there are none.
+func decodeChainState(value, id string) []uint64 {
+	for _, entry := range strings.Split(value, ";") {
+		masks := strings.TrimPrefix(entry, id+":")
+		if masks == entry {
+			continue
+		}
+		var positions []uint64
+		for _, mask := range strings.Split(masks, ".") {
+			p, err := strconv.ParseUint(mask, 16, 64)
+			if err != nil {
+				return nil
+			}
+			positions = append(positions, p)
+		}
+		return positions
+	}
+	return nil
+}
+
+// validChainID reports whether id, a query ID or the name of a named query,
+// may identify a query in the chainStateKey tracestate member.
+func validChainID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c <= ' ' || c > '~' || strings.IndexByte(",=:;", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
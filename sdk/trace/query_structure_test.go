@@ -0,0 +1,258 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var structTraceID = trace.TraceID{0x01}
+
+func structSpan(id, parent byte, service string) *snapshot {
+	s := &snapshot{
+		name: service,
+		spanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: structTraceID,
+			SpanID:  trace.SpanID{id},
+		}),
+		resource: resource.NewSchemaless(semconv.ServiceName(service)),
+	}
+	if parent != 0 {
+		s.parent = trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: structTraceID,
+			SpanID:  trace.SpanID{parent},
+		})
+	}
+	return s
+}
+
+// callTree is app1 -> app2 -> app3, with app2 also querying db and app1
+// calling app4.
+//
+//	1 app1 ─┬─ 2 app2 ─┬─ 3 app2 ── 4 app3
+//	        │          └─ 5 app2 ── 6 db
+//	        └─ 7 app4
+func callTree() []ReadOnlySpan {
+	return []ReadOnlySpan{
+		structSpan(4, 3, "app3"),
+		structSpan(3, 2, "app2"),
+		structSpan(6, 5, "db"),
+		structSpan(5, 2, "app2"),
+		structSpan(2, 1, "app2"),
+		structSpan(7, 1, "app4"),
+		structSpan(1, 0, "app1"),
+	}
+}
+
+func matchedIDs(m map[spanRef]bool) []byte {
+	var ids []byte
+	for id := byte(1); id <= 7; id++ {
+		if m[spanRef{tid: structTraceID, sid: trace.SpanID{id}}] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestStructuralMatches(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		patterns [][]string
+		want     []byte
+	}{
+		{
+			name:     "full chain",
+			patterns: [][]string{{"app1", "app2", "app3"}},
+			want:     []byte{1, 2, 3, 4},
+		},
+		{
+			name:     "chain suffix",
+			patterns: [][]string{{"app2", "app3"}},
+			want:     []byte{2, 3, 4},
+		},
+		{
+			name:     "branch",
+			patterns: [][]string{{"app2", "db"}},
+			want:     []byte{2, 5, 6},
+		},
+		{
+			name:     "several patterns",
+			patterns: [][]string{{"app2", "db"}, {"app1", "app4"}},
+			want:     []byte{1, 2, 5, 6, 7},
+		},
+		{
+			name:     "hops must be contiguous",
+			patterns: [][]string{{"app1", "app3"}},
+			want:     nil,
+		},
+		{
+			name:     "wrong direction",
+			patterns: [][]string{{"app3", "app2"}},
+			want:     nil,
+		},
+		{
+			name:     "unknown service",
+			patterns: [][]string{{"app1", "app5"}},
+			want:     nil,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := structuralMatches(callTree(), "", test.patterns)
+			assert.Equal(t, test.want, matchedIDs(got))
+		})
+	}
+}
+
+func TestStructuralMatchesMissingParent(t *testing.T) {
+	// The app1 span is not part of the batch, app2 cannot be matched as
+	// the callee of app1.
+	spans := []ReadOnlySpan{
+		structSpan(2, 1, "app2"),
+		structSpan(3, 2, "app3"),
+	}
+	assert.Empty(t, structuralMatches(spans, "", [][]string{{"app1", "app2", "app3"}}))
+	assert.Len(t, structuralMatches(spans, "", [][]string{{"app2", "app3"}}), 2)
+}
+
+func TestStructuralMatchesParentCycle(t *testing.T) {
+	// Malformed parent links must not hang, the cycle is broken at an
+	// arbitrary span.
+	spans := []ReadOnlySpan{
+		structSpan(1, 2, "app1"),
+		structSpan(2, 1, "app2"),
+	}
+	got := structuralMatches(spans, "", [][]string{{"app1", "app2"}, {"app2", "app1"}})
+	assert.Len(t, got, 2)
+}
+
+func TestFilterSpansStructural(t *testing.T) {
+	flags, patterns := global.FilterConfigFlags(), global.TraceStructuralPatterns()
+	t.Cleanup(func() {
+		global.SetFilterConfigFlags(flags)
+		global.SetTraceStructuralPatterns(patterns)
+	})
+
+	global.SetFilterConfigFlags(global.StructuralTraceFilter)
+	global.SetTraceStructuralPatterns(nil)
+	assert.Len(t, FilterSpans(callTree()), 7, "no pattern should not filter")
+
+	global.SetTraceStructuralPatterns([][]string{{"app1", "app2", "app3"}})
+	assert.Len(t, FilterSpans(callTree()), 4)
+
+	global.SetFilterConfigFlags(0)
+	assert.Len(t, FilterSpans(callTree()), 7, "disabled filter should not filter")
+}
+
+func TestFilterSpansStructuralAcrossServices(t *testing.T) {
+	flags, patterns, id := global.FilterConfigFlags(), global.TraceStructuralPatterns(), global.QueryID()
+	t.Cleanup(func() {
+		global.SetFilterConfigFlags(flags)
+		global.SetTraceStructuralPatterns(patterns)
+		global.SetQueryID(id)
+	})
+	global.SetTraceStructuralPatterns([][]string{{"app1", "app2", "app3"}})
+	global.SetQueryID("q1")
+	global.SetFilterConfigFlags(global.StructuralTraceFilter)
+
+	// Each service runs in its own process and exports its own spans.
+	prop := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, QueryPropagator{})
+	services := make(map[string]*endedRecorder)
+	tracer := func(service string) trace.Tracer {
+		r := &endedRecorder{}
+		services[service] = r
+		tp := NewTracerProvider(WithSpanProcessor(r), WithResource(resource.NewSchemaless(semconv.ServiceName(service))))
+		return tp.Tracer("test")
+	}
+	app1, app2, app3 := tracer("app1"), tracer("app2"), tracer("app3")
+	// call starts a span of kind in ctx, calls next with the headers
+	// injected in the context of the span and ends it.
+	call := func(ctx context.Context, tr trace.Tracer, name string, kind trace.SpanKind, next func(propagation.MapCarrier)) context.Context {
+		ctx, span := tr.Start(ctx, name, trace.WithSpanKind(kind))
+		defer span.End()
+		if next != nil {
+			carrier := propagation.MapCarrier{}
+			prop.Inject(ctx, carrier)
+			next(carrier)
+		}
+		return ctx
+	}
+	serve := func(tr trace.Tracer, name string, carrier propagation.MapCarrier, handle func(context.Context)) {
+		ctx := prop.Extract(context.Background(), carrier)
+		ctx, span := tr.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		if handle != nil {
+			handle(ctx)
+		}
+	}
+
+	// app1 -> app2 -> app3, with app2 also doing local work.
+	ctx, root := app1.Start(context.Background(), "app1.server", trace.WithSpanKind(trace.SpanKindServer))
+	call(ctx, app1, "app1.client", trace.SpanKindClient, func(c propagation.MapCarrier) {
+		serve(app2, "app2.server", c, func(ctx context.Context) {
+			call(ctx, app2, "app2.local", trace.SpanKindInternal, nil)
+			call(ctx, app2, "app2.client", trace.SpanKindClient, func(c propagation.MapCarrier) {
+				serve(app3, "app3.server", c, nil)
+			})
+		})
+	})
+	root.End()
+	// app1 -> app3, which does not spell the pattern.
+	call(context.Background(), app1, "app1.direct", trace.SpanKindInternal, func(c propagation.MapCarrier) {
+		serve(app3, "app3.direct", c, nil)
+	})
+
+	exported := func(service string) []string {
+		var names []string
+		for _, s := range FilterSpans(services[service].spans) {
+			names = append(names, s.Name())
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"app1.client", "app1.server"}, exported("app1"), "the caller may lead to the rest of the chain")
+	assert.ElementsMatch(t, []string{"app2.server", "app2.client"}, exported("app2"), "the chain should resume from upstream")
+	assert.Equal(t, []string{"app3.server"}, exported("app3"), "the chain should only match the requests that went through app2")
+}
+
+func TestChainState(t *testing.T) {
+	assert.Equal(t, uint64(0b10), chainPositions([]string{"app1", "app2", "app3"}, 0b01, "app2"))
+	assert.Equal(t, uint64(0b10), chainPositions([]string{"app1", "app2", "app3"}, 0b10, "app2"), "consecutive hops of a service should merge")
+	assert.Zero(t, chainPositions([]string{"app1", "app2", "app3"}, 0b01, "app3"))
+	assert.Equal(t, uint64(0b01), chainPositions([]string{"app1", "app2"}, 0, "app1"))
+
+	value := encodeChainState("", "q1", []uint64{1, 0})
+	value = encodeChainState(value, "q", []uint64{2})
+	assert.Equal(t, "q:2;q1:1.0", value)
+	assert.Equal(t, "q:3;q1:1.0", encodeChainState(value, "q", []uint64{3}), "the positions of a query should be replaced")
+	assert.Equal(t, []uint64{1, 0}, decodeChainState(value, "q1"))
+	assert.Equal(t, []uint64{2}, decodeChainState(value, "q"))
+	assert.Nil(t, decodeChainState(value, "q2"))
+	assert.Nil(t, decodeChainState("q1:x", "q1"))
+
+	assert.True(t, validChainID("4f2a9c0d1e3b5a7f"))
+	for _, id := range []string{"", "a b", "a,b", "a=b", "a:b", "a;b"} {
+		assert.False(t, validChainID(id), id)
+	}
+	require.NotPanics(t, func() { chainPositions(make([]string, 100), ^uint64(0), "") })
+}
@@ -0,0 +1,312 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace // import "go.opentelemetry.io/otel/sdk/trace"
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults for TraceBufferSpanProcessorOptions.
+const (
+	DefaultTraceBufferTimeout = 30 * time.Second
+	DefaultMaxBufferedTraces  = 1024
+	DefaultMaxSpansPerTrace   = 1024
+)
+
+// TraceBufferSpanProcessorOption configures a TraceBufferSpanProcessor.
+type TraceBufferSpanProcessorOption func(o *TraceBufferSpanProcessorOptions)
+
+// TraceBufferSpanProcessorOptions is configuration settings for a
+// TraceBufferSpanProcessor.
+type TraceBufferSpanProcessorOptions struct {
+	// Timeout is the maximum duration a trace is buffered after its first
+	// span ended. The trace is decided with the spans ended so far when it is
+	// reached.
+	// The default value of Timeout is 30 seconds.
+	Timeout time.Duration
+
+	// MaxTraces is the maximum number of buffered traces. When a span of a
+	// new trace ends with MaxTraces buffered, the oldest trace is decided
+	// with the spans ended so far.
+	// The default value of MaxTraces is 1024.
+	MaxTraces int
+
+	// MaxSpansPerTrace is the maximum number of buffered spans of a trace. A
+	// trace is decided when it reaches MaxSpansPerTrace spans.
+	// The default value of MaxSpansPerTrace is 1024.
+	MaxSpansPerTrace int
+}
+
+// WithTraceBufferTimeout sets the maximum duration a trace is buffered.
+func WithTraceBufferTimeout(timeout time.Duration) TraceBufferSpanProcessorOption {
+	return func(o *TraceBufferSpanProcessorOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// WithMaxBufferedTraces sets the maximum number of buffered traces.
+func WithMaxBufferedTraces(n int) TraceBufferSpanProcessorOption {
+	return func(o *TraceBufferSpanProcessorOptions) {
+		o.MaxTraces = n
+	}
+}
+
+// WithMaxSpansPerTrace sets the maximum number of buffered spans of a trace.
+func WithMaxSpansPerTrace(n int) TraceBufferSpanProcessorOption {
+	return func(o *TraceBufferSpanProcessorOptions) {
+		o.MaxSpansPerTrace = n
+	}
+}
+
+// activeTraceBuffers is the number of TraceBufferSpanProcessors not shut
+// down. While there is one, the full-trace condition of the global query
+// filter is evaluated over whole traces, so QueryFilterSampler cannot reject
+// a span by it at span start.
+var activeTraceBuffers atomic.Int64
+
+// selectedSpan is a span of a trace selected as a whole by a
+// TraceBufferSpanProcessor. FilterSpans does not evaluate the full-trace
+// condition on it.
+type selectedSpan struct {
+	ReadOnlySpan
+}
+
+// bufferedTrace holds the ended spans of a trace waiting for a decision.
+type bufferedTrace struct {
+	id       trace.TraceID
+	spans    []ReadOnlySpan
+	timer    *time.Timer
+	elem     *list.Element // element of the trace in traceBufferSpanProcessor.order
+	selected bool
+}
+
+// traceBufferSpanProcessor is a SpanProcessor buffering the spans of each
+// trace to evaluate the full-trace condition over the whole trace.
+type traceBufferSpanProcessor struct {
+	next SpanProcessor
+	o    TraceBufferSpanProcessorOptions
+
+	mu      sync.Mutex
+	traces  map[trace.TraceID]*bufferedTrace
+	order   *list.List // buffered trace IDs, oldest first
+	decided map[trace.TraceID]bool
+	// decidedOrder holds the decided trace IDs, oldest first, to forget the
+	// oldest decisions beyond MaxTraces.
+	decidedOrder *list.List
+	stopped      bool
+}
+
+var _ SpanProcessor = (*traceBufferSpanProcessor)(nil)
+
+// NewTraceBufferSpanProcessor returns a SpanProcessor applying the full-trace
+// condition of the global query filter (AttributeNotMatchFullTraceFilter) to
+// whole traces rather than to single spans. It buffers the ended spans of a
+// trace until its local root span ends, the trace times out or exceeds the
+// bounds of options. Then it passes all the spans of the trace to next if any
+// of them satisfies the condition, and drops them otherwise. The spans of a
+// trace ending after its decision follow it.
+//
+// The spans passed to next are not dropped by the full-trace condition of
+// FilterSpans, which still applies the other filters. Spans are passed to
+// next as they end when the AttributeNotMatchFullTraceFilter is disabled.
+//
+// While the processor is not shut down, QueryFilterSampler does not drop the
+// spans by the full-trace condition of the global query filter: a span
+// failing it may belong to a trace that satisfies it.
+func NewTraceBufferSpanProcessor(next SpanProcessor, options ...TraceBufferSpanProcessorOption) SpanProcessor {
+	o := TraceBufferSpanProcessorOptions{
+		Timeout:          DefaultTraceBufferTimeout,
+		MaxTraces:        DefaultMaxBufferedTraces,
+		MaxSpansPerTrace: DefaultMaxSpansPerTrace,
+	}
+	for _, opt := range options {
+		opt(&o)
+	}
+	if o.MaxTraces < 1 {
+		o.MaxTraces = 1
+	}
+	if o.MaxSpansPerTrace < 1 {
+		o.MaxSpansPerTrace = 1
+	}
+	activeTraceBuffers.Add(1)
+	return &traceBufferSpanProcessor{
+		next:         next,
+		o:            o,
+		traces:       make(map[trace.TraceID]*bufferedTrace),
+		order:        list.New(),
+		decided:      make(map[trace.TraceID]bool),
+		decidedOrder: list.New(),
+	}
+}
+
+// OnStart passes s to the next processor.
+func (p *traceBufferSpanProcessor) OnStart(parent context.Context, s ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+// OnEnd buffers s until the decision of its trace.
+func (p *traceBufferSpanProcessor) OnEnd(s ReadOnlySpan) {
+	if global.CurrentFilterState().Flags&global.AttributeNotMatchFullTraceFilter == 0 || !s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+
+	var ready []*bufferedTrace
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	id := s.SpanContext().TraceID()
+	if selected, ok := p.decided[id]; ok {
+		p.mu.Unlock()
+		if selected {
+			p.next.OnEnd(&selectedSpan{ReadOnlySpan: s})
+		}
+		return
+	}
+	t, ok := p.traces[id]
+	if !ok {
+		if p.order.Len() >= p.o.MaxTraces {
+			ready = append(ready, p.decide(p.order.Front().Value.(trace.TraceID)))
+		}
+		t = &bufferedTrace{id: id}
+		t.elem = p.order.PushBack(id)
+		t.timer = time.AfterFunc(p.o.Timeout, func() { p.timeout(id) })
+		p.traces[id] = t
+	}
+	t.spans = append(t.spans, s)
+	if isLocalRoot(s) || len(t.spans) >= p.o.MaxSpansPerTrace {
+		ready = append(ready, p.decide(id))
+	}
+	p.mu.Unlock()
+
+	p.export(ready)
+}
+
+// isLocalRoot reports whether s is the root span of its trace in this
+// process.
+func isLocalRoot(s ReadOnlySpan) bool {
+	return !s.Parent().IsValid() || s.Parent().IsRemote()
+}
+
+// decide removes the buffered trace id and decides whether it is selected,
+// see bufferedTrace.match. The decision is recorded for the spans of the
+// trace ending later, the oldest decisions are forgotten beyond MaxTraces.
+// p.mu must be held.
+func (p *traceBufferSpanProcessor) decide(id trace.TraceID) *bufferedTrace {
+	t := p.traces[id]
+	t.timer.Stop()
+	p.order.Remove(t.elem)
+	delete(p.traces, id)
+
+	t.selected = t.match(global.CurrentFilterState())
+	p.decided[id] = t.selected
+	p.decidedOrder.PushBack(id)
+	if p.decidedOrder.Len() > p.o.MaxTraces {
+		delete(p.decided, p.decidedOrder.Remove(p.decidedOrder.Front()).(trace.TraceID))
+	}
+	return t
+}
+
+// match reports whether t is selected by the filter configuration st: it was
+// selected upstream, if the PropagatedSelectionFilter is enabled, or any of
+// its spans satisfies the full-trace condition.
+func (t *bufferedTrace) match(st *global.FilterState) bool {
+	for _, s := range t.spans {
+		if selected, ok := propagatedSelection(s, st); ok {
+			return selected
+		}
+	}
+	for _, s := range t.spans {
+		f := st.FilterFor(serviceName(s), s.InstrumentationScope().Name)
+		if matchSpan(s, f, global.AttributeNotMatchFullTraceFilter, nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// export passes the spans of the selected traces of ts to the next
+// processor.
+func (p *traceBufferSpanProcessor) export(ts []*bufferedTrace) {
+	for _, t := range ts {
+		if !t.selected {
+			continue
+		}
+		for _, s := range t.spans {
+			p.next.OnEnd(&selectedSpan{ReadOnlySpan: s})
+		}
+	}
+}
+
+// timeout decides the trace id if it is still buffered.
+func (p *traceBufferSpanProcessor) timeout(id trace.TraceID) {
+	p.mu.Lock()
+	if _, ok := p.traces[id]; !ok {
+		p.mu.Unlock()
+		return
+	}
+	t := p.decide(id)
+	p.mu.Unlock()
+	p.export([]*bufferedTrace{t})
+}
+
+// flush decides all the buffered traces with the spans ended so far.
+func (p *traceBufferSpanProcessor) flush() {
+	p.mu.Lock()
+	ready := p.drain()
+	p.mu.Unlock()
+
+	p.export(ready)
+}
+
+// drain decides all the buffered traces, stopping their timers, and returns
+// them. p.mu must be held.
+func (p *traceBufferSpanProcessor) drain() []*bufferedTrace {
+	ready := make([]*bufferedTrace, 0, p.order.Len())
+	for p.order.Len() > 0 {
+		ready = append(ready, p.decide(p.order.Front().Value.(trace.TraceID)))
+	}
+	return ready
+}
+
+// Shutdown decides all the buffered traces and shuts the next processor
+// down. The spans ending afterwards are dropped.
+func (p *traceBufferSpanProcessor) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		activeTraceBuffers.Add(-1)
+	}
+	p.stopped = true
+	ready := p.drain()
+	p.mu.Unlock()
+
+	p.export(ready)
+	return p.next.Shutdown(ctx)
+}
+
+// ForceFlush decides all the buffered traces and flushes the next processor.
+func (p *traceBufferSpanProcessor) ForceFlush(ctx context.Context) error {
+	p.flush()
+	return p.next.ForceFlush(ctx)
+}
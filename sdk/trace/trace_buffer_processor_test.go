@@ -0,0 +1,186 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/internal/global"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// endedRecorder is a SpanProcessor recording the names of the ended spans.
+type endedRecorder struct {
+	mu    sync.Mutex
+	ended []string
+	spans []ReadOnlySpan
+}
+
+func (r *endedRecorder) OnStart(context.Context, ReadWriteSpan) {}
+func (r *endedRecorder) Shutdown(context.Context) error         { return nil }
+func (r *endedRecorder) ForceFlush(context.Context) error       { return nil }
+
+func (r *endedRecorder) OnEnd(s ReadOnlySpan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ended = append(r.ended, s.Name())
+	r.spans = append(r.spans, s)
+}
+
+func (r *endedRecorder) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ended...)
+}
+
+// selectErrors selects the spans with an error attribute with the full-trace
+// condition.
+func selectErrors(t *testing.T) {
+	flags := global.FilterConfigFlags()
+	t.Cleanup(func() {
+		global.TraceAttributeFilter().Clear()
+		global.SetFilterConfigFlags(flags)
+	})
+	global.TraceAttributeFilter().AddKeyMatch("a")
+	global.TraceAttributeFilter().SetCondition(attribute.Equal("error", attribute.BoolValue(true)))
+	global.SetFilterConfigFlags(global.AttributeFilter | global.AttributeNotMatchFullTraceFilter)
+}
+
+// newTraceBuffer returns a TraceBufferSpanProcessor shut down at the end of
+// the test, not to affect QueryFilterSampler in the other tests.
+func newTraceBuffer(t *testing.T, next SpanProcessor, options ...TraceBufferSpanProcessorOption) SpanProcessor {
+	bsp := NewTraceBufferSpanProcessor(next, options...)
+	t.Cleanup(func() { _ = bsp.Shutdown(context.Background()) })
+	return bsp
+}
+
+func TestTraceBufferSpanProcessor(t *testing.T) {
+	selectErrors(t)
+	rec := &endedRecorder{}
+	tracer := NewTracerProvider(WithSpanProcessor(newTraceBuffer(t, rec))).Tracer("test")
+	ctx := context.Background()
+
+	ctx1, root := tracer.Start(ctx, "root")
+	_, child := tracer.Start(ctx1, "child", trace.WithAttributes(attribute.Bool("error", true), attribute.Int("a", 1)))
+	_, sibling := tracer.Start(ctx1, "sibling")
+	sibling.End()
+	child.End()
+	assert.Empty(t, rec.names(), "spans should be buffered until the root ends")
+	root.End()
+	assert.Equal(t, []string{"sibling", "child", "root"}, rec.names())
+
+	got := FilterSpans(rec.spans)
+	require.Len(t, got, 3, "the spans of a selected trace should not be dropped")
+	assert.Equal(t, []attribute.KeyValue{attribute.Int("a", 1)}, got[1].Attributes(), "attributes should still be projected")
+
+	ctx2, other := tracer.Start(ctx, "other")
+	_, late := tracer.Start(ctx2, "late", trace.WithAttributes(attribute.Bool("error", true)))
+	other.End()
+	late.End()
+	assert.Len(t, rec.names(), 3, "spans ending after their trace was dropped should be dropped")
+}
+
+func TestTraceBufferSpanProcessorTimeout(t *testing.T) {
+	selectErrors(t)
+	rec := &endedRecorder{}
+	tracer := NewTracerProvider(WithSpanProcessor(newTraceBuffer(t, rec, WithTraceBufferTimeout(10*time.Millisecond)))).Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	defer root.End()
+	_, child := tracer.Start(ctx, "child", trace.WithAttributes(attribute.Bool("error", true)))
+	child.End()
+	assert.Eventually(t, func() bool { return len(rec.names()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestTraceBufferSpanProcessorBounds(t *testing.T) {
+	selectErrors(t)
+	rec := &endedRecorder{}
+	bsp := newTraceBuffer(t, rec, WithMaxBufferedTraces(1), WithMaxSpansPerTrace(2))
+	tracer := NewTracerProvider(WithSpanProcessor(bsp)).Tracer("test")
+	ctx := context.Background()
+
+	ctx1, root1 := tracer.Start(ctx, "root1")
+	defer root1.End()
+	_, child := tracer.Start(ctx1, "child1", trace.WithAttributes(attribute.Bool("error", true)))
+	child.End()
+
+	ctx2, root2 := tracer.Start(ctx, "root2")
+	defer root2.End()
+	_, child = tracer.Start(ctx2, "child2", trace.WithAttributes(attribute.Bool("error", true)))
+	child.End()
+	assert.Equal(t, []string{"child1"}, rec.names(), "the oldest trace should be decided beyond MaxTraces")
+
+	_, child = tracer.Start(ctx2, "child3")
+	child.End()
+	assert.Equal(t, []string{"child1", "child2", "child3"}, rec.names(), "a trace should be decided at MaxSpansPerTrace")
+}
+
+func TestTraceBufferSpanProcessorDisabled(t *testing.T) {
+	rec := &endedRecorder{}
+	bsp := newTraceBuffer(t, rec)
+	tracer := NewTracerProvider(WithSpanProcessor(bsp)).Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	assert.Equal(t, []string{"child"}, rec.names(), "spans should not be buffered without full-trace filter")
+
+	selectErrors(t)
+	_, child = tracer.Start(ctx, "error", trace.WithAttributes(attribute.Bool("error", true)))
+	child.End()
+	assert.Len(t, rec.names(), 1)
+	require.NoError(t, bsp.ForceFlush(context.Background()))
+	assert.Equal(t, []string{"child", "error"}, rec.names(), "ForceFlush should decide the buffered traces")
+	root.End()
+	assert.Equal(t, []string{"child", "error", "root"}, rec.names())
+}
+
+func TestTraceBufferSpanProcessorShutdown(t *testing.T) {
+	selectErrors(t)
+	rec := &endedRecorder{}
+	bsp := newTraceBuffer(t, rec, WithTraceBufferTimeout(10*time.Millisecond))
+	tracer := NewTracerProvider(WithSpanProcessor(bsp)).Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child", trace.WithAttributes(attribute.Bool("error", true)))
+	child.End()
+	require.NoError(t, bsp.Shutdown(context.Background()))
+	assert.Equal(t, []string{"child"}, rec.names(), "the buffered traces should be decided at shutdown")
+
+	_, late := tracer.Start(context.Background(), "late", trace.WithAttributes(attribute.Bool("error", true)))
+	late.End()
+	root.End()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"child"}, rec.names(), "spans ending after shutdown should be dropped")
+}
+
+func TestTraceBufferSpanProcessorSampler(t *testing.T) {
+	selectErrors(t)
+	sampler := QueryFilterSampler(Drop)
+	params := SamplingParameters{Name: "root", Attributes: []attribute.KeyValue{attribute.Bool("error", false)}}
+	require.Equal(t, Drop, sampler.ShouldSample(params).Decision)
+
+	bsp := newTraceBuffer(t, &endedRecorder{})
+	assert.Equal(t, RecordAndSample, sampler.ShouldSample(params).Decision, "spans should be left to the trace buffer")
+	require.NoError(t, bsp.Shutdown(context.Background()))
+	assert.Equal(t, Drop, sampler.ShouldSample(params).Decision)
+}